@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ovirt/csi-driver-operator/pkg/operator"
+)
+
+// NewCopyVolumeCommand returns the command run in the copy Jobs of a VolumeMigration.
+func NewCopyVolumeCommand() *cobra.Command {
+	var source, target string
+
+	cmd := &cobra.Command{
+		Use:    "copy-volume",
+		Short:  "Copy a volume to the new volume of a migration",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return operator.CopyVolume(cmd.Context(), source, target)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&source, "source", "", "mount point or block device of the source volume")
+	flags.StringVar(&target, "target", "", "mount point or block device of the target volume")
+	_ = cmd.MarkFlagRequired("source")
+	_ = cmd.MarkFlagRequired("target")
+	return cmd
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/ovirt/csi-driver-operator/pkg/operator"
+)
+
+// NewExportDiskCommand returns the command downloading the disk of a PV to a local file.
+func NewExportDiskCommand() *cobra.Command {
+	var opts operator.ExportDiskOptions
+
+	cmd := &cobra.Command{
+		Use:   "export-disk",
+		Short: "Download the disk of a PV to an image file",
+		Long: "Download the oVirt disk of a PV, or a disk by its ID, to a raw or qcow2 image file and write " +
+			"its SHA-256 checksum to <output>.sha256. The oVirt connection is read from OVIRT_CONFIG or " +
+			"~/.ovirt/ovirt-config.yaml. Disks attached read-write to a running VM are refused.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return operator.ExportDisk(cmd.Context(), opts)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.PersistentVolumeName, "pv", "", "PV whose disk is exported")
+	flags.StringVar(&opts.DiskID, "disk-id", "", "ID of the exported disk, instead of --pv")
+	flags.StringVar(&opts.Kubeconfig, "kubeconfig", "", "kubeconfig to read the PV with, in-cluster or $KUBECONFIG by default")
+	flags.StringVar(&opts.Format, "format", "raw", "image format: raw or qcow2")
+	flags.StringVar(&opts.Output, "output", "", "image file to write")
+	_ = cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+// NewReceiveImageCommand returns the command run in the target pod of an OvirtDiskExport.
+func NewReceiveImageCommand() *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:    "receive-image",
+		Short:  "Receive an image file exported by the operator",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return operator.ReceiveImage(cmd.Context(), file)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "image file to write")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
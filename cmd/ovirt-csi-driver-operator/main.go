@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -15,7 +14,10 @@ import (
 	"github.com/ovirt/csi-driver-operator/pkg/version"
 )
 
-var nodeName string
+var (
+	nodeName        string
+	guestKubeConfig string
+)
 
 func main() {
 
@@ -25,12 +27,6 @@ func main() {
 }
 
 func NewOperatorCommand() *cobra.Command {
-	op, err := operator.NewCSIOperator(&nodeName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
-	}
-
 	cmd := &cobra.Command{
 		Use:   "ovirt-csi-driver-operator",
 		Short: "OpenShift oVirt CSI Driver Operator",
@@ -42,12 +38,20 @@ func NewOperatorCommand() *cobra.Command {
 	ctrlCmd := controllercmd.NewControllerCommandConfig(
 		"ovirt-csi-driver-operator",
 		version.Get(),
-		op.RunOperator,
+		func(ctx context.Context, controllerConfig *controllercmd.ControllerContext) error {
+			return operator.NewCSIOperator(&nodeName, &guestKubeConfig).RunOperator(ctx, controllerConfig)
+		},
 	).NewCommandWithContext(context.Background())
 	ctrlCmd.Use = "start"
 	ctrlCmd.Short = "Start the oVirt CSI Driver Operator"
 	ctrlCmd.Flags().StringVar(&nodeName, "node", "", "kubernetes node name")
+	ctrlCmd.Flags().StringVar(&guestKubeConfig, "guest-kubeconfig", "", "kubeconfig of the guest cluster when running with a hosted control plane")
 	cmd.AddCommand(ctrlCmd)
+	cmd.AddCommand(NewRenderCommand())
+	cmd.AddCommand(NewServeImageCommand())
+	cmd.AddCommand(NewExportDiskCommand())
+	cmd.AddCommand(NewReceiveImageCommand())
+	cmd.AddCommand(NewCopyVolumeCommand())
 
 	return cmd
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	configv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+
+	"github.com/ovirt/csi-driver-operator/pkg/operator"
+)
+
+// NewRenderCommand returns the command printing the operand manifests without a cluster.
+func NewRenderCommand() *cobra.Command {
+	var (
+		opts                 operator.RenderOptions
+		logLevel             string
+		controlPlaneTopology string
+		trustedCABundle      string
+		operatorConfig       string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Print the operand manifests as the operator would apply them",
+		Long: "Print the static resources, the controller Deployment, the node DaemonSet and the StorageClass " +
+			"as multi-document YAML. The images are taken from the same environment variables as with start.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.LogLevel = opv1.LogLevel(logLevel)
+			opts.ControlPlaneTopology = configv1.TopologyMode(controlPlaneTopology)
+			if trustedCABundle != "" {
+				data, err := os.ReadFile(trustedCABundle)
+				if err != nil {
+					return fmt.Errorf("failed to read trusted CA bundle: %w", err)
+				}
+				opts.TrustedCABundle = data
+			}
+			if operatorConfig != "" {
+				data, err := os.ReadFile(operatorConfig)
+				if err != nil {
+					return fmt.Errorf("failed to read operator configuration: %w", err)
+				}
+				opts.OperatorConfig = data
+			}
+			return operator.Render(opts, cmd.OutOrStdout())
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&logLevel, "log-level", string(opv1.Normal), "operand log level: Normal, Debug, Trace or TraceAll")
+	flags.StringVar(&opts.InfrastructureName, "infrastructure-name", "", "infrastructure name (cluster ID) of the cluster")
+	flags.StringVar(&controlPlaneTopology, "control-plane-topology", string(configv1.HighlyAvailableTopologyMode), "control plane topology of the cluster")
+	flags.IntVar(&opts.MasterNodes, "master-nodes", 3, "number of master nodes, determines the controller replicas")
+	flags.StringVar(&opts.HTTPProxy, "http-proxy", "", "cluster HTTP proxy")
+	flags.StringVar(&opts.HTTPSProxy, "https-proxy", "", "cluster HTTPS proxy")
+	flags.StringVar(&opts.NoProxy, "no-proxy", "", "cluster proxy exclusions")
+	flags.StringVar(&trustedCABundle, "trusted-ca-bundle", "", "file with the PEM encoded trusted CA bundle injected into the operand")
+	flags.StringVar(&operatorConfig, "config", "", "file with the operator configuration, the config.yaml of the operator ConfigMap")
+	flags.StringVar(&opts.StorageDomain, "storage-domain", "", "oVirt storage domain of the generated StorageClass")
+	return cmd
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ovirt/csi-driver-operator/pkg/operator"
+)
+
+// NewServeImageCommand returns the command run in the source pod of an OvirtImagePopulator.
+func NewServeImageCommand() *cobra.Command {
+	var file, url string
+
+	cmd := &cobra.Command{
+		Use:    "serve-image",
+		Short:  "Serve an image file or URL to the oVirt image populator",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" && url == "" {
+				return fmt.Errorf("one of --file and --url must be set")
+			}
+			return operator.ServeImage(cmd.Context(), file, url)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "image file to serve")
+	cmd.Flags().StringVar(&url, "url", "", "HTTP(S) URL of the image to serve")
+	cmd.MarkFlagsMutuallyExclusive("file", "url")
+	return cmd
+}
@@ -0,0 +1,104 @@
+package ovirt
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"k8s.io/client-go/util/cert"
+)
+
+// EngineCertificates holds the certificates the engine connection relies on.
+type EngineCertificates struct {
+	// Chain is the certificate chain the engine presents, leaf first. It is empty for a plain
+	// HTTP engine URL.
+	Chain []*x509.Certificate
+	// CABundle holds the engine CA certificates, those of the settings or else of ovirt_cafile.
+	CABundle []*x509.Certificate
+	// VerifyError tells why Chain does not validate against the engine CA and the trusted CA
+	// bundle of the settings. It is nil when the chain validates or with ovirt_insecure.
+	VerifyError error
+}
+
+// InspectEngineCertificates reads the engine CA and performs a TLS handshake with the engine
+// using the same TLS configuration and proxy as the client, but without verifying the
+// presented chain, so that an untrusted or expired chain can still be inspected. The chain
+// is then verified like the client does. When the handshake fails, the CA bundle is
+// returned along with the error.
+func InspectEngineCertificates(ctx context.Context, settings *ConnectionSettings) (*EngineCertificates, error) {
+	ovirtConfig, err := GetOvirtConfig()
+	if err != nil {
+		return nil, err
+	}
+	certificates := &EngineCertificates{}
+	switch {
+	case settings != nil && len(settings.EngineCABundle) > 0:
+		if certificates.CABundle, err = cert.ParseCertsPEM(settings.EngineCABundle); err != nil {
+			return nil, fmt.Errorf("failed to parse ovirt_ca_bundle: %w", err)
+		}
+	case ovirtConfig.CAFile != "":
+		data, err := os.ReadFile(ovirtConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ovirt_cafile %s: %w", ovirtConfig.CAFile, err)
+		}
+		if certificates.CABundle, err = cert.ParseCertsPEM(data); err != nil {
+			return nil, fmt.Errorf("failed to parse ovirt_cafile %s: %w", ovirtConfig.CAFile, err)
+		}
+	}
+
+	provider, err := newTLSProvider(ovirtConfig, settings)
+	if err != nil {
+		return certificates, err
+	}
+	tlsConfig, err := provider.CreateTLSConfig()
+	if err != nil {
+		return certificates, err
+	}
+	roots := tlsConfig.RootCAs
+	inspectConfig := tlsConfig.Clone()
+	inspectConfig.InsecureSkipVerify = true
+	transport, err := newEngineTransport(ovirtConfig, settings, inspectConfig)
+	if err != nil {
+		return certificates, err
+	}
+	defer transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(ctx, tlsCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ovirtConfig.URL, nil)
+	if err != nil {
+		return certificates, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return certificates, err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return certificates, err
+	}
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return certificates, nil
+	}
+	certificates.Chain = resp.TLS.PeerCertificates
+	if ovirtConfig.Insecure {
+		return certificates, nil
+	}
+
+	engineURL, err := url.Parse(ovirtConfig.URL)
+	if err != nil {
+		return certificates, fmt.Errorf("failed to parse engine URL %s: %w", ovirtConfig.URL, err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certificates.Chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, certificates.VerifyError = certificates.Chain[0].Verify(x509.VerifyOptions{
+		DNSName:       engineURL.Hostname(),
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return certificates, nil
+}
@@ -0,0 +1,69 @@
+package ovirt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+func TestInspectEngineCertificates(t *testing.T) {
+	otherCA := otherCAFile(t)
+	testCases := []struct {
+		name   string
+		modify func(config *ovirt.Config)
+		// settings gets the CA certificate of the engine
+		settings     func(caCert []byte) *ovirt.ConnectionSettings
+		expectCAs    int
+		expectVerify bool
+	}{
+		{
+			name:         "ovirt_cafile",
+			settings:     func([]byte) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{} },
+			expectCAs:    1,
+			expectVerify: true,
+		},
+		{
+			name:   "engine CA of the settings replaces a stale ovirt_cafile",
+			modify: func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings: func(caCert []byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{EngineCABundle: caCert}
+			},
+			expectCAs:    1,
+			expectVerify: true,
+		},
+		{
+			name: "rotated engine CA",
+			settings: func([]byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{EngineCABundle: readFile(t, otherCA)}
+			},
+			expectCAs: 1,
+		},
+		{
+			name:   "untrusted engine certificate",
+			modify: func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings: func([]byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{}
+			},
+			expectCAs: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startEngine(t, tc.modify)
+			certificates, err := ovirt.InspectEngineCertificates(context.Background(), tc.settings(engine.CACert()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(certificates.Chain) == 0 {
+				t.Error("expected the presented chain")
+			}
+			if len(certificates.CABundle) != tc.expectCAs {
+				t.Errorf("expected %d CA certificates, got %d", tc.expectCAs, len(certificates.CABundle))
+			}
+			if verified := certificates.VerifyError == nil; verified != tc.expectVerify {
+				t.Errorf("expected the chain to verify %t, got %v", tc.expectVerify, certificates.VerifyError)
+			}
+		})
+	}
+}
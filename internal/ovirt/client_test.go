@@ -0,0 +1,373 @@
+package ovirt_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+	"github.com/ovirt/csi-driver-operator/internal/ovirt/fakeengine"
+)
+
+// startEngine starts a fake engine with a storage domain and points OVIRT_CONFIG to its
+// config, after modify changed it.
+func startEngine(t *testing.T, modify func(*ovirt.Config)) *fakeengine.Engine {
+	t.Helper()
+	engine, err := fakeengine.New()
+	if err != nil {
+		t.Fatalf("failed to start the fake engine: %v", err)
+	}
+	t.Cleanup(engine.Close)
+	engine.AddStorageDomain("6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1", "nfs", 80<<30, 20<<30)
+
+	dir := t.TempDir()
+	config, err := engine.Config(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatalf("failed to write the engine CA: %v", err)
+	}
+	if modify != nil {
+		modify(config)
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "ovirt-config.yaml")
+	if err := os.WriteFile(configFile, out, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OVIRT_CONFIG", configFile)
+	return engine
+}
+
+// otherCAFile writes the CA of another fake engine, which does not sign the certificate
+// of the engine under test.
+func otherCAFile(t *testing.T) string {
+	t.Helper()
+	other, err := fakeengine.New()
+	if err != nil {
+		t.Fatalf("failed to start the fake engine: %v", err)
+	}
+	defer other.Close()
+	caFile := filepath.Join(t.TempDir(), "other-ca.pem")
+	if err := os.WriteFile(caFile, other.CACert(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return caFile
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func listStorageDomains(client ovirtclient.Client) (int, error) {
+	storageDomains, err := client.ListStorageDomains(ovirtclient.ContextStrategy(context.Background()))
+	return len(storageDomains), err
+}
+
+func TestNewClientWithSettingsTLS(t *testing.T) {
+	otherCA := otherCAFile(t)
+	testCases := []struct {
+		name string
+		// modify changes the oVirt config, the engine CA is used when it is nil
+		modify func(config *ovirt.Config)
+		// settings gets the CA certificate of the engine
+		settings        func(caCert []byte) *ovirt.ConnectionSettings
+		expectErr       bool
+		expectTLSRefuse bool
+	}{
+		{
+			name: "ovirt_cafile without settings",
+		},
+		{
+			name:     "ovirt_cafile with empty settings",
+			settings: func([]byte) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{} },
+		},
+		{
+			name:      "untrusted engine certificate",
+			modify:    func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings:  func([]byte) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{} },
+			expectErr: true,
+		},
+		{
+			name:   "engine CA in the trusted CA bundle",
+			modify: func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings: func(caCert []byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{TrustedCABundle: caCert}
+			},
+		},
+		{
+			name:   "engine CA of the settings replaces a stale ovirt_cafile",
+			modify: func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings: func(caCert []byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{EngineCABundle: caCert}
+			},
+		},
+		{
+			name: "engine CA of the settings takes precedence over ovirt_cafile",
+			settings: func([]byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{EngineCABundle: readFile(t, otherCA)}
+			},
+			expectErr: true,
+		},
+		{
+			name: "ovirt_insecure",
+			modify: func(config *ovirt.Config) {
+				config.CAFile = ""
+				config.Insecure = true
+			},
+			settings: func([]byte) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{} },
+		},
+		{
+			name: "ovirt_insecure with strict TLS",
+			modify: func(config *ovirt.Config) {
+				config.CAFile = ""
+				config.Insecure = true
+			},
+			settings:        func([]byte) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{StrictTLS: true} },
+			expectErr:       true,
+			expectTLSRefuse: true,
+		},
+		{
+			name: "TLS 1.3 profile",
+			settings: func([]byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{MinTLSVersion: tls.VersionTLS13, StrictTLS: true}
+			},
+		},
+		{
+			name: "TLS 1.2 profile with cipher suites",
+			settings: func([]byte) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{
+					MinTLSVersion: tls.VersionTLS12,
+					CipherSuites:  []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startEngine(t, tc.modify)
+			var settings *ovirt.ConnectionSettings
+			if tc.settings != nil {
+				settings = tc.settings(engine.CACert())
+			}
+
+			client, err := ovirt.NewClientWithSettings(settings)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				var complianceErr *ovirt.TLSComplianceError
+				if errors.As(err, &complianceErr) != tc.expectTLSRefuse {
+					t.Errorf("expected a TLSComplianceError %t, got %v", tc.expectTLSRefuse, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to create the client: %v", err)
+			}
+			if n, err := listStorageDomains(client); err != nil || n != 1 {
+				t.Errorf("expected 1 storage domain, got %d (%v)", n, err)
+			}
+		})
+	}
+}
+
+func TestNewClientWithSettingsAuthentication(t *testing.T) {
+	engine := startEngine(t, func(config *ovirt.Config) { config.Password = "wrong" })
+	if _, err := ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{}); err == nil {
+		t.Fatal("expected the wrong password to be refused")
+	}
+	if n := engine.Requests("/sso/oauth/token"); n != 1 {
+		t.Errorf("expected 1 token request, got %d", n)
+	}
+}
+
+func TestClientExpiredToken(t *testing.T) {
+	engine := startEngine(t, nil)
+	client, err := ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{})
+	if err != nil {
+		t.Fatalf("failed to create the client: %v", err)
+	}
+
+	engine.ExpireTokens()
+	if _, err := listStorageDomains(client); !ovirtclient.HasErrorCode(err, ovirtclient.EAccessDenied) {
+		t.Fatalf("expected access denied with an expired token, got %v", err)
+	}
+	// the operator replaces a client which fails its connection test
+	if err := client.Test(); err == nil {
+		t.Error("expected the connection test to fail with an expired token")
+	}
+	client, err = ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{})
+	if err != nil {
+		t.Fatalf("failed to create the client again: %v", err)
+	}
+	if n, err := listStorageDomains(client); err != nil || n != 1 {
+		t.Errorf("expected 1 storage domain, got %d (%v)", n, err)
+	}
+	if n := engine.Requests("/sso/oauth/token"); n != 2 {
+		t.Errorf("expected 2 token requests, got %d", n)
+	}
+}
+
+func TestClientFaults(t *testing.T) {
+	testCases := []struct {
+		name  string
+		fault fakeengine.Fault
+	}{
+		{
+			name:  "service unavailable",
+			fault: fakeengine.Fault{PathPrefix: "/api/storagedomains", StatusCode: http.StatusServiceUnavailable, Times: 1},
+		},
+		{
+			name:  "internal server error",
+			fault: fakeengine.Fault{Method: http.MethodGet, PathPrefix: "/api/storagedomains", StatusCode: http.StatusInternalServerError, Times: 1},
+		},
+		{
+			name:  "dropped connection",
+			fault: fakeengine.Fault{PathPrefix: "/api/storagedomains", Times: 1},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startEngine(t, nil)
+			client, err := ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{})
+			if err != nil {
+				t.Fatalf("failed to create the client: %v", err)
+			}
+
+			engine.InjectFault(tc.fault)
+			if _, err := listStorageDomains(client); err == nil {
+				t.Fatal("expected the fault to fail the request")
+			}
+			// the fault is consumed, the client keeps working
+			if n, err := listStorageDomains(client); err != nil || n != 1 {
+				t.Errorf("expected 1 storage domain after the fault, got %d (%v)", n, err)
+			}
+			if n := engine.Requests("/api/storagedomains"); n != 2 {
+				t.Errorf("expected 2 storage domain requests, got %d", n)
+			}
+		})
+	}
+}
+
+func TestNewClientWithSettingsAuthenticationFault(t *testing.T) {
+	engine := startEngine(t, nil)
+	engine.InjectFault(fakeengine.Fault{Method: http.MethodPost, PathPrefix: "/sso/oauth/token", StatusCode: http.StatusInternalServerError, Times: 1})
+	if _, err := ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{}); err == nil {
+		t.Fatal("expected the failed authentication to fail the client creation")
+	}
+	if _, err := ovirt.NewClientWithSettings(&ovirt.ConnectionSettings{}); err != nil {
+		t.Errorf("failed to create the client after the fault: %v", err)
+	}
+}
+
+// startConnectProxy starts an HTTP proxy tunneling CONNECT requests and counts them.
+func startConnectProxy(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	var connects atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		connects.Add(1)
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			conn.Close()
+			upstream.Close()
+			return
+		}
+		go func() {
+			defer upstream.Close()
+			_, _ = io.Copy(upstream, conn)
+		}()
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, upstream)
+		}()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy.URL, &connects
+}
+
+func TestNewClientWithSettingsProxy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		settings    func(proxy string) *ovirt.ConnectionSettings
+		expectProxy bool
+	}{
+		{
+			name:        "HTTPS proxy",
+			settings:    func(proxy string) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{HTTPSProxy: proxy} },
+			expectProxy: true,
+		},
+		{
+			name:     "HTTP proxy only",
+			settings: func(proxy string) *ovirt.ConnectionSettings { return &ovirt.ConnectionSettings{HTTPProxy: proxy} },
+		},
+		{
+			name: "engine IP in noProxy",
+			settings: func(proxy string) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{HTTPSProxy: proxy, NoProxy: ".cluster.local,127.0.0.1"}
+			},
+		},
+		{
+			name: "engine network in noProxy",
+			settings: func(proxy string) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{HTTPSProxy: proxy, NoProxy: "127.0.0.0/8"}
+			},
+		},
+		{
+			name: "other hosts in noProxy",
+			settings: func(proxy string) *ovirt.ConnectionSettings {
+				return &ovirt.ConnectionSettings{HTTPSProxy: proxy, NoProxy: "engine.example.com,10.0.0.0/8"}
+			},
+			expectProxy: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			startEngine(t, nil)
+			proxy, connects := startConnectProxy(t)
+			// the settings replace the proxy environment
+			t.Setenv("HTTPS_PROXY", "http://127.0.0.1:1")
+
+			client, err := ovirt.NewClientWithSettings(tc.settings(proxy))
+			if err != nil {
+				t.Fatalf("failed to create the client: %v", err)
+			}
+			if n, err := listStorageDomains(client); err != nil || n != 1 {
+				t.Errorf("expected 1 storage domain, got %d (%v)", n, err)
+			}
+			if used := connects.Load() > 0; used != tc.expectProxy {
+				t.Errorf("expected the proxy to be used %t, got %t", tc.expectProxy, used)
+			}
+		})
+	}
+}
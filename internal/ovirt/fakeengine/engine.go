@@ -0,0 +1,198 @@
+// Package fakeengine serves the subset of the oVirt engine REST API used by the operator
+// over TLS, so that the real ovirtclient.New connection, including the SSO authentication,
+// TLS verification and retries, can be exercised without an engine. Faults can be injected
+// into any request.
+package fakeengine
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+const (
+	pathPrefix = "/ovirt-engine"
+	apiPath    = pathPrefix + "/api"
+
+	DefaultUsername = "admin@internal"
+	DefaultPassword = "password"
+)
+
+// Engine is a fake oVirt engine. It is safe for concurrent use.
+type Engine struct {
+	server   *httptest.Server
+	caCert   []byte
+	username string
+	password string
+
+	lock           sync.Mutex
+	tokens         map[string]bool
+	faults         []*Fault
+	requests       map[string]int
+	vms            map[string]*ovirtsdk.Vm
+	disks          map[string]*ovirtsdk.Disk
+	attachments    map[string][]*ovirtsdk.DiskAttachment
+	storageDomains map[string]*ovirtsdk.StorageDomain
+}
+
+// New starts a fake engine accepting DefaultUsername and DefaultPassword, its TLS
+// certificate is signed by a CA generated for it.
+func New() (*Engine, error) {
+	cert, caCert, err := newServerCertificate()
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		caCert:         caCert,
+		username:       DefaultUsername,
+		password:       DefaultPassword,
+		tokens:         map[string]bool{},
+		requests:       map[string]int{},
+		vms:            map[string]*ovirtsdk.Vm{},
+		disks:          map[string]*ovirtsdk.Disk{},
+		attachments:    map[string][]*ovirtsdk.DiskAttachment{},
+		storageDomains: map[string]*ovirtsdk.StorageDomain{},
+	}
+	e.server = httptest.NewUnstartedServer(http.HandlerFunc(e.serveHTTP))
+	e.server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	e.server.StartTLS()
+	return e, nil
+}
+
+// Close shuts the engine down.
+func (e *Engine) Close() {
+	e.server.Close()
+}
+
+// URL returns the API URL of the engine.
+func (e *Engine) URL() string {
+	return e.server.URL + apiPath
+}
+
+// CACert returns the PEM encoded CA certificate of the engine.
+func (e *Engine) CACert() []byte {
+	return e.caCert
+}
+
+// Config returns the oVirt config of the engine. The CA certificate is written to caFile.
+func (e *Engine) Config(caFile string) (*ovirt.Config, error) {
+	if err := os.WriteFile(caFile, e.caCert, 0600); err != nil {
+		return nil, err
+	}
+	return &ovirt.Config{
+		URL:      e.URL(),
+		Username: e.username,
+		Password: e.password,
+		CAFile:   caFile,
+	}, nil
+}
+
+// WriteConfig writes the oVirt config of the engine to configFile and its CA certificate
+// next to it. Pointing the OVIRT_CONFIG environment variable to configFile makes
+// ovirt.NewClient connect to the engine.
+func (e *Engine) WriteConfig(configFile string) error {
+	config, err := e.Config(configFile + ".ca.pem")
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configFile, out, 0600)
+}
+
+// Requests returns the number of requests received for the path, relative to /ovirt-engine.
+func (e *Engine) Requests(path string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.requests[path]
+}
+
+func (e *Engine) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, pathPrefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")
+	e.lock.Lock()
+	e.requests[path]++
+	e.lock.Unlock()
+
+	if fault := e.takeFault(r, path); fault != nil && e.applyFault(w, fault) {
+		return
+	}
+
+	switch {
+	case path == "/sso/oauth/token":
+		e.serveToken(w, r)
+	case path == "/sso/oauth/revoke":
+		e.serveRevoke(w, r)
+	case path == "/api" || strings.HasPrefix(path, "/api/"):
+		if !e.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeFault(w, http.StatusMethodNotAllowed, "Operation Failed", "method "+r.Method+" is not supported by the fake engine")
+			return
+		}
+		e.serveAPI(w, strings.Split(strings.TrimPrefix(path, "/api"), "/")[1:])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Engine) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error(), "error_code": "invalid_request"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "password" || r.PostForm.Get("username") != e.username || r.PostForm.Get("password") != e.password {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Cannot authenticate user", "error_code": "access_denied"})
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error(), "error_code": "server_error"})
+		return
+	}
+	token := hex.EncodeToString(b)
+	e.lock.Lock()
+	e.tokens[token] = true
+	e.lock.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": token, "token_type": "bearer", "scope": "ovirt-app-api"})
+}
+
+func (e *Engine) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err == nil {
+		e.lock.Lock()
+		delete(e.tokens, r.PostForm.Get("token"))
+		e.lock.Unlock()
+	}
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (e *Engine) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.tokens[token]
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,90 @@
+package fakeengine
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fault makes the engine fail the matching requests.
+type Fault struct {
+	// Method and PathPrefix select the requests, empty values match all of them. The path
+	// is relative to /ovirt-engine, e.g. /api/vms or /sso/oauth/token.
+	Method     string
+	PathPrefix string
+	// StatusCode is returned with a fault body, zero drops the connection without a response.
+	StatusCode int
+	// Delay is waited before failing. With a negative StatusCode the request is only delayed
+	// and then served normally.
+	Delay time.Duration
+	// Times limits the number of affected requests, zero affects all of them until the
+	// fault is cleared.
+	Times int
+}
+
+func (f *Fault) matches(r *http.Request, path string) bool {
+	return (f.Method == "" || f.Method == r.Method) && strings.HasPrefix(path, f.PathPrefix)
+}
+
+// InjectFault adds a fault, the first matching fault is applied to a request.
+func (e *Engine) InjectFault(fault Fault) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.faults = append(e.faults, &fault)
+}
+
+// ClearFaults removes all faults.
+func (e *Engine) ClearFaults() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.faults = nil
+}
+
+// ExpireTokens invalidates all issued SSO tokens, the clients have to authenticate again.
+func (e *Engine) ExpireTokens() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.tokens = map[string]bool{}
+}
+
+// takeFault returns the fault applied to the request and consumes it, or nil.
+func (e *Engine) takeFault(r *http.Request, path string) *Fault {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i, fault := range e.faults {
+		if !fault.matches(r, path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				e.faults = append(e.faults[:i:i], e.faults[i+1:]...)
+			}
+		}
+		applied := *fault
+		return &applied
+	}
+	return nil
+}
+
+// applyFault applies the fault to the request and reports whether the request was answered.
+func (e *Engine) applyFault(w http.ResponseWriter, fault *Fault) bool {
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	switch {
+	case fault.StatusCode < 0:
+		return false
+	case fault.StatusCode == 0:
+		if hijacker, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hijacker.Hijack(); err == nil {
+				conn.Close()
+				return true
+			}
+		}
+		panic(http.ErrAbortHandler)
+	default:
+		writeFault(w, fault.StatusCode, "Injected fault", http.StatusText(fault.StatusCode))
+		return true
+	}
+}
@@ -0,0 +1,268 @@
+package fakeengine
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	ovirtsdk "github.com/ovirt/go-ovirt"
+)
+
+const (
+	clusterID  = "00000000-0000-0000-0000-000000000001"
+	templateID = "00000000-0000-0000-0000-000000000000"
+)
+
+// AddStorageDomain adds an active NFS data storage domain.
+func (e *Engine) AddStorageDomain(id, name string, availableBytes, usedBytes int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.storageDomains[id] = ovirtsdk.NewStorageDomainBuilder().
+		Id(id).
+		Name(name).
+		Type(ovirtsdk.STORAGEDOMAINTYPE_DATA).
+		Status(ovirtsdk.STORAGEDOMAINSTATUS_ACTIVE).
+		Available(availableBytes).
+		Used(usedBytes).
+		Storage(ovirtsdk.NewHostStorageBuilder().Type(ovirtsdk.STORAGETYPE_NFS).MustBuild()).
+		MustBuild()
+}
+
+// AddStorageDomainWithoutSize adds an active NFS data storage domain whose available and
+// used space the engine does not report.
+func (e *Engine) AddStorageDomainWithoutSize(id, name string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.storageDomains[id] = ovirtsdk.NewStorageDomainBuilder().
+		Id(id).
+		Name(name).
+		Type(ovirtsdk.STORAGEDOMAINTYPE_DATA).
+		Status(ovirtsdk.STORAGEDOMAINSTATUS_ACTIVE).
+		Storage(ovirtsdk.NewHostStorageBuilder().Type(ovirtsdk.STORAGETYPE_NFS).MustBuild()).
+		MustBuild()
+}
+
+// AddVM adds a running VM, the id is the system UUID of the node backed by it.
+func (e *Engine) AddVM(id, name string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.vms[id] = ovirtsdk.NewVmBuilder().
+		Id(id).
+		Name(name).
+		Comment("").
+		Status(ovirtsdk.VMSTATUS_UP).
+		Type(ovirtsdk.VMTYPE_SERVER).
+		Cluster(ovirtsdk.NewClusterBuilder().Id(clusterID).MustBuild()).
+		Template(ovirtsdk.NewTemplateBuilder().Id(templateID).MustBuild()).
+		Cpu(ovirtsdk.NewCpuBuilder().Topology(
+			ovirtsdk.NewCpuTopologyBuilder().Cores(4).Threads(1).Sockets(1).MustBuild(),
+		).MustBuild()).
+		Memory(16 << 30).
+		MemoryPolicy(ovirtsdk.NewMemoryPolicyBuilder().Guaranteed(16 << 30).Max(16 << 30).MustBuild()).
+		Os(ovirtsdk.NewOperatingSystemBuilder().Type("rhcos_x64").MustBuild()).
+		SoundcardEnabled(false).
+		MustBuild()
+	if _, ok := e.attachments[id]; !ok {
+		e.attachments[id] = nil
+	}
+}
+
+// SetVMStatus changes the status of the VM, e.g. to paused.
+func (e *Engine) SetVMStatus(id string, status ovirtsdk.VmStatus) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	vm, ok := e.vms[id]
+	if !ok {
+		return fmt.Errorf("VM %s not found", id)
+	}
+	vm.SetStatus(status)
+	return nil
+}
+
+// AddDisk adds a thin provisioned disk in the storage domain.
+func (e *Engine) AddDisk(id, alias, storageDomainID string, provisionedSize int64) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, ok := e.storageDomains[storageDomainID]; !ok {
+		return fmt.Errorf("storage domain %s not found", storageDomainID)
+	}
+	e.disks[id] = ovirtsdk.NewDiskBuilder().
+		Id(id).
+		Alias(alias).
+		ProvisionedSize(provisionedSize).
+		TotalSize(0).
+		Format(ovirtsdk.DISKFORMAT_COW).
+		Sparse(true).
+		Status(ovirtsdk.DISKSTATUS_OK).
+		StorageDomainsOfAny(ovirtsdk.NewStorageDomainBuilder().Id(storageDomainID).MustBuild()).
+		MustBuild()
+	return nil
+}
+
+// SetDiskStatus changes the status of the disk, e.g. to locked or illegal.
+func (e *Engine) SetDiskStatus(id string, status ovirtsdk.DiskStatus) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	disk, ok := e.disks[id]
+	if !ok {
+		return fmt.Errorf("disk %s not found", id)
+	}
+	disk.SetStatus(status)
+	return nil
+}
+
+// AttachDisk attaches the disk to the VM. The VM is listed in the vms of the disk too.
+func (e *Engine) AttachDisk(vmID, diskID string, bootable bool) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, ok := e.vms[vmID]; !ok {
+		return fmt.Errorf("VM %s not found", vmID)
+	}
+	disk, ok := e.disks[diskID]
+	if !ok {
+		return fmt.Errorf("disk %s not found", diskID)
+	}
+	vms := &ovirtsdk.VmSlice{}
+	if current, ok := disk.Vms(); ok {
+		vms.SetSlice(current.Slice())
+	}
+	vms.SetSlice(append(vms.Slice(), ovirtsdk.NewVmBuilder().Id(vmID).MustBuild()))
+	disk.SetVms(vms)
+	e.attachments[vmID] = append(e.attachments[vmID], ovirtsdk.NewDiskAttachmentBuilder().
+		Id(diskID).
+		Vm(ovirtsdk.NewVmBuilder().Id(vmID).MustBuild()).
+		Disk(ovirtsdk.NewDiskBuilder().Id(diskID).MustBuild()).
+		Interface(ovirtsdk.DISKINTERFACE_VIRTIO_SCSI).
+		Bootable(bootable).
+		Active(true).
+		MustBuild())
+	return nil
+}
+
+// RemoveVM removes the VM and its disk attachments.
+func (e *Engine) RemoveVM(id string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.vms, id)
+	delete(e.attachments, id)
+	for _, disk := range e.disks {
+		current, ok := disk.Vms()
+		if !ok {
+			continue
+		}
+		vms := &ovirtsdk.VmSlice{}
+		for _, vm := range current.Slice() {
+			if vmID, _ := vm.Id(); vmID != id {
+				vms.SetSlice(append(vms.Slice(), vm))
+			}
+		}
+		disk.SetVms(vms)
+	}
+}
+
+// serveAPI serves the GET requests below /ovirt-engine/api, split into path segments.
+func (e *Engine) serveAPI(w http.ResponseWriter, segments []string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	writer := ovirtsdk.NewXMLWriter(w)
+	var err error
+	switch {
+	case len(segments) == 0:
+		err = ovirtsdk.XMLApiWriteOne(writer, ovirtsdk.NewApiBuilder().
+			ProductInfo(ovirtsdk.NewProductInfoBuilder().Name("oVirt Engine").Vendor("fakeengine").MustBuild()).
+			MustBuild(), "")
+	case segments[0] == "vms" && len(segments) == 1:
+		slice := &ovirtsdk.VmSlice{}
+		slice.SetSlice(sortedValues(e.vms))
+		err = ovirtsdk.XMLVmWriteMany(writer, slice, "", "")
+	case segments[0] == "vms" && len(segments) == 2:
+		vm, ok := e.vms[segments[1]]
+		if !ok {
+			writeNotFound(w, "VM", segments[1])
+			return
+		}
+		err = ovirtsdk.XMLVmWriteOne(writer, vm, "")
+	case segments[0] == "vms" && len(segments) == 3 && segments[2] == "diskattachments":
+		attachments, ok := e.attachments[segments[1]]
+		if !ok {
+			writeNotFound(w, "VM", segments[1])
+			return
+		}
+		slice := &ovirtsdk.DiskAttachmentSlice{}
+		slice.SetSlice(attachments)
+		err = ovirtsdk.XMLDiskAttachmentWriteMany(writer, slice, "", "")
+	case segments[0] == "vms" && len(segments) == 4 && segments[2] == "diskattachments":
+		attachment := findAttachment(e.attachments[segments[1]], segments[3])
+		if attachment == nil {
+			writeNotFound(w, "disk attachment", segments[3])
+			return
+		}
+		err = ovirtsdk.XMLDiskAttachmentWriteOne(writer, attachment, "")
+	case segments[0] == "disks" && len(segments) == 1:
+		slice := &ovirtsdk.DiskSlice{}
+		slice.SetSlice(sortedValues(e.disks))
+		err = ovirtsdk.XMLDiskWriteMany(writer, slice, "", "")
+	case segments[0] == "disks" && len(segments) == 2:
+		disk, ok := e.disks[segments[1]]
+		if !ok {
+			writeNotFound(w, "disk", segments[1])
+			return
+		}
+		err = ovirtsdk.XMLDiskWriteOne(writer, disk, "")
+	case segments[0] == "storagedomains" && len(segments) == 1:
+		slice := &ovirtsdk.StorageDomainSlice{}
+		slice.SetSlice(sortedValues(e.storageDomains))
+		err = ovirtsdk.XMLStorageDomainWriteMany(writer, slice, "", "")
+	case segments[0] == "storagedomains" && len(segments) == 2:
+		storageDomain, ok := e.storageDomains[segments[1]]
+		if !ok {
+			writeNotFound(w, "storage domain", segments[1])
+			return
+		}
+		err = ovirtsdk.XMLStorageDomainWriteOne(writer, storageDomain, "")
+	default:
+		writeFault(w, http.StatusNotFound, "Operation Failed", "resource is not supported by the fake engine")
+		return
+	}
+	if err != nil {
+		writeFault(w, http.StatusInternalServerError, "Operation Failed", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = writer.Flush()
+}
+
+func findAttachment(attachments []*ovirtsdk.DiskAttachment, id string) *ovirtsdk.DiskAttachment {
+	for _, attachment := range attachments {
+		if attachmentID, _ := attachment.Id(); attachmentID == id {
+			return attachment
+		}
+	}
+	return nil
+}
+
+func writeNotFound(w http.ResponseWriter, kind, id string) {
+	writeFault(w, http.StatusNotFound, "Operation Failed", fmt.Sprintf("Entity not found: %s %s", kind, id))
+}
+
+func writeFault(w http.ResponseWriter, status int, reason, detail string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	writer := ovirtsdk.NewXMLWriter(w)
+	_ = ovirtsdk.XMLFaultWriteOne(writer, ovirtsdk.NewFaultBuilder().Reason(reason).Detail("["+detail+"]").MustBuild(), "")
+	_ = writer.Flush()
+}
+
+func sortedValues[T any](m map[string]T) []T {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]T, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, m[k])
+	}
+	return values
+}
@@ -21,14 +21,52 @@ type Config struct {
 	Insecure bool   `yaml:"ovirt_insecure,omitempty"`
 }
 
+// ConnectionSettings holds cluster-wide settings for the engine connection
+// which are not part of the oVirt config file.
+type ConnectionSettings struct {
+	// HTTPProxy, HTTPSProxy and NoProxy follow the semantics of the cluster Proxy config.
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// EngineCABundle holds the PEM encoded CA certificates of the engine, the ovirt_ca_bundle
+	// of the credentials Secret. When set, it replaces ovirt_cafile, which is written from the
+	// Secret only when the pod starts.
+	EngineCABundle []byte
+	// TrustedCABundle holds PEM encoded CA certificates trusted in addition to the engine CA.
+	TrustedCABundle []byte
+	// MinTLSVersion and CipherSuites restrict the engine connection to the cluster TLS
+	// security profile, zero values keep the Go defaults.
+	MinTLSVersion uint16
+	CipherSuites  []uint16
+	// StrictTLS refuses ovirt_insecure and plain HTTP engine URLs.
+	StrictTLS bool
+}
+
+// NewClient creates an oVirt client using the proxy settings from the environment.
 func NewClient() (ovirtclient.Client, error) {
+	return NewClientWithSettings(nil)
+}
+
+// NewClientWithSettings creates an oVirt client. When settings is not nil, its proxy
+// configuration replaces the environment, its trusted CA bundle is merged with the
+// engine CA and its TLS profile is applied.
+func NewClientWithSettings(settings *ConnectionSettings) (ovirtclient.Client, error) {
 	ovirtConfig, err := GetOvirtConfig()
-	tls := ovirtclient.TLS()
-	if ovirtConfig.Insecure {
-		tls.Insecure()
+	if err != nil {
+		return nil, err
+	}
+	tls, err := newTLSProvider(ovirtConfig, settings)
+	if err != nil {
+		return nil, err
 	}
-	if ovirtConfig.CAFile != "" {
-		tls.CACertsFromFile(ovirtConfig.CAFile)
+	var extraSettings ovirtclient.ExtraSettings
+	if settings != nil {
+		proxy, err := settings.proxyFor(ovirtConfig.URL)
+		if err != nil {
+			return nil, err
+		}
+		// An empty proxy disables the environment proxy settings.
+		extraSettings = ovirtclient.NewExtraSettings().WithProxy(proxy)
 	}
 	logger := kloglogger.New()
 	//TODO: HANDLE VERBUSE
@@ -38,7 +76,7 @@ func NewClient() (ovirtclient.Client, error) {
 		ovirtConfig.Password,
 		tls,
 		logger,
-		nil,
+		extraSettings,
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,62 @@
+package ovirt
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// proxyFor returns the proxy URL to use for reaching engineURL, or an empty
+// string when the engine must be contacted directly.
+func (s *ConnectionSettings) proxyFor(engineURL string) (string, error) {
+	u, err := url.Parse(engineURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse engine URL %s: %w", engineURL, err)
+	}
+	proxy := s.HTTPProxy
+	if u.Scheme == "https" {
+		proxy = s.HTTPSProxy
+	}
+	if proxy == "" || bypassProxy(u.Hostname(), s.NoProxy) {
+		return "", nil
+	}
+	return proxy, nil
+}
+
+// bypassProxy reports whether host matches one of the comma separated noProxy
+// entries. Entries may be "*", IP addresses, CIDRs or domain names, where a
+// domain also matches all of its subdomains.
+func bypassProxy(host string, noProxy string) bool {
+	host = strings.ToLower(host)
+	hostIP := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if hostIP != nil && cidr.Contains(hostIP) {
+				return true
+			}
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			if hostIP != nil && ip.Equal(hostIP) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
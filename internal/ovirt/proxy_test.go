@@ -0,0 +1,77 @@
+package ovirt
+
+import "testing"
+
+func TestBypassProxy(t *testing.T) {
+	testCases := []struct {
+		name    string
+		host    string
+		noProxy string
+		expect  bool
+	}{
+		{name: "empty noProxy", host: "engine.example.com", noProxy: "", expect: false},
+		{name: "wildcard", host: "engine.example.com", noProxy: "*", expect: true},
+		{name: "exact domain", host: "engine.example.com", noProxy: "engine.example.com", expect: true},
+		{name: "parent domain", host: "engine.example.com", noProxy: "example.com", expect: true},
+		{name: "parent domain with leading dot", host: "engine.example.com", noProxy: ".example.com", expect: true},
+		{name: "domain suffix is not a subdomain", host: "engine.badexample.com", noProxy: "example.com", expect: false},
+		{name: "other domain", host: "engine.example.com", noProxy: "example.org", expect: false},
+		{name: "case insensitive", host: "Engine.Example.COM", noProxy: "EXAMPLE.com", expect: true},
+		{name: "entry with port", host: "engine.example.com", noProxy: "engine.example.com:443", expect: true},
+		{name: "spaces and empty entries", host: "engine.example.com", noProxy: " , .svc, example.com ", expect: true},
+		{name: "exact IP", host: "10.0.0.5", noProxy: "10.0.0.5", expect: true},
+		{name: "other IP", host: "10.0.0.5", noProxy: "10.0.0.6", expect: false},
+		{name: "IP in CIDR", host: "10.0.0.5", noProxy: "10.0.0.0/24", expect: true},
+		{name: "IP outside CIDR", host: "10.0.1.5", noProxy: "10.0.0.0/24", expect: false},
+		{name: "IPv6 in CIDR", host: "fd00::5", noProxy: "fd00::/64", expect: true},
+		{name: "CIDR does not match a name", host: "engine.example.com", noProxy: "10.0.0.0/8", expect: false},
+		{name: "IP entry does not match a name", host: "engine.example.com", noProxy: "10.0.0.5", expect: false},
+		{name: "later entry matches", host: "10.0.0.5", noProxy: ".cluster.local,172.30.0.0/16,10.0.0.0/8", expect: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bypassProxy(tc.host, tc.noProxy); got != tc.expect {
+				t.Errorf("bypassProxy(%q, %q) = %t, expected %t", tc.host, tc.noProxy, got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestProxyFor(t *testing.T) {
+	settings := &ConnectionSettings{
+		HTTPProxy:  "http://http-proxy:3128",
+		HTTPSProxy: "http://https-proxy:3128",
+		NoProxy:    ".cluster.local,10.0.0.0/8",
+	}
+	testCases := []struct {
+		name      string
+		settings  *ConnectionSettings
+		engineURL string
+		expect    string
+		expectErr bool
+	}{
+		{name: "https engine", settings: settings, engineURL: "https://engine.example.com/ovirt-engine/api", expect: "http://https-proxy:3128"},
+		{name: "http engine", settings: settings, engineURL: "http://engine.example.com/ovirt-engine/api", expect: "http://http-proxy:3128"},
+		{name: "engine in noProxy domain", settings: settings, engineURL: "https://engine.cluster.local/ovirt-engine/api", expect: ""},
+		{name: "engine in noProxy CIDR", settings: settings, engineURL: "https://10.1.2.3:8443/ovirt-engine/api", expect: ""},
+		{
+			name:      "no HTTPS proxy",
+			settings:  &ConnectionSettings{HTTPProxy: "http://http-proxy:3128"},
+			engineURL: "https://engine.example.com/ovirt-engine/api",
+			expect:    "",
+		},
+		{name: "no proxy", settings: &ConnectionSettings{}, engineURL: "https://engine.example.com/ovirt-engine/api", expect: ""},
+		{name: "invalid engine URL", settings: settings, engineURL: "https://engine example.com/%zz", expectErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.settings.proxyFor(tc.engineURL)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if got != tc.expect {
+				t.Errorf("proxyFor(%q) = %q, expected %q", tc.engineURL, got, tc.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package ovirt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+)
+
+const tlsCheckTimeout = 30 * time.Second
+
+// TLSComplianceError reports an engine connection which does not comply with the TLS
+// settings: the TLS handshake failed, the negotiated version is below the minimum, or an
+// insecure connection is refused with strict TLS.
+type TLSComplianceError struct {
+	Err error
+}
+
+func (e *TLSComplianceError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TLSComplianceError) Unwrap() error {
+	return e.Err
+}
+
+// profileTLSProvider restricts the TLS configuration of the wrapped provider to the
+// minimum version and cipher suites of the cluster TLS security profile.
+type profileTLSProvider struct {
+	ovirtclient.TLSProvider
+	minVersion   uint16
+	cipherSuites []uint16
+}
+
+func (p *profileTLSProvider) CreateTLSConfig() (*tls.Config, error) {
+	config, err := p.TLSProvider.CreateTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if p.minVersion != 0 {
+		config.MinVersion = p.minVersion
+	}
+	if len(p.cipherSuites) > 0 {
+		config.CipherSuites = p.cipherSuites
+	}
+	return config, nil
+}
+
+func newTLSProvider(ovirtConfig *Config, settings *ConnectionSettings) (ovirtclient.TLSProvider, error) {
+	if settings != nil && settings.StrictTLS {
+		if ovirtConfig.Insecure {
+			return nil, &TLSComplianceError{Err: fmt.Errorf("ovirt_insecure is not allowed with strict TLS")}
+		}
+		if !strings.HasPrefix(ovirtConfig.URL, "https://") {
+			return nil, &TLSComplianceError{Err: fmt.Errorf("engine URL %s must use https with strict TLS", ovirtConfig.URL)}
+		}
+	}
+	tls := ovirtclient.TLS()
+	if ovirtConfig.Insecure {
+		tls.Insecure()
+	}
+	switch {
+	case settings != nil && len(settings.EngineCABundle) > 0:
+		tls.CACertsFromMemory(settings.EngineCABundle)
+	case ovirtConfig.CAFile != "":
+		tls.CACertsFromFile(ovirtConfig.CAFile)
+	}
+	if settings == nil {
+		return tls, nil
+	}
+	if len(settings.TrustedCABundle) > 0 {
+		tls.CACertsFromMemory(settings.TrustedCABundle)
+	}
+	return &profileTLSProvider{
+		TLSProvider:  tls,
+		minVersion:   settings.MinTLSVersion,
+		cipherSuites: settings.CipherSuites,
+	}, nil
+}
+
+// CheckEngineTLS performs a TLS handshake with the engine using the same TLS configuration
+// and proxy as the client. It fails with a TLSComplianceError when the engine cannot negotiate
+// a handshake compliant with the TLS profile of the settings, other errors, e.g. an
+// unreachable engine, tell nothing about the compliance.
+func CheckEngineTLS(ctx context.Context, settings *ConnectionSettings) error {
+	ovirtConfig, err := GetOvirtConfig()
+	if err != nil {
+		return err
+	}
+	provider, err := newTLSProvider(ovirtConfig, settings)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := provider.CreateTLSConfig()
+	if err != nil {
+		return err
+	}
+	transport, err := newEngineTransport(ovirtConfig, settings, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(ctx, tlsCheckTimeout)
+	defer cancel()
+	// Only a failed handshake tells that the engine does not comply, not a failed connection
+	var handshakeErr error
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			handshakeErr = err
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ovirtConfig.URL, nil)
+	if err != nil {
+		return err
+	}
+	// Any HTTP response means the handshake succeeded
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		if handshakeErr != nil {
+			return &TLSComplianceError{Err: err}
+		}
+		return err
+	}
+	if err := resp.Body.Close(); err != nil {
+		return err
+	}
+	if resp.TLS != nil && settings != nil && resp.TLS.Version < settings.MinTLSVersion {
+		return &TLSComplianceError{Err: fmt.Errorf("the engine negotiated %s, below the minimum %s", tls.VersionName(resp.TLS.Version), tls.VersionName(settings.MinTLSVersion))}
+	}
+	return nil
+}
+
+// newEngineTransport returns a transport to the engine with the TLS configuration and the
+// proxy of the client.
+func newEngineTransport(ovirtConfig *Config, settings *ConnectionSettings, tlsConfig *tls.Config) (*http.Transport, error) {
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           http.ProxyFromEnvironment,
+	}
+	if settings != nil {
+		proxy, err := settings.proxyFor(ovirtConfig.URL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = nil
+		if proxy != "" {
+			proxyURL, err := url.Parse(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse proxy URL %s: %w", proxy, err)
+			}
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	return transport, nil
+}
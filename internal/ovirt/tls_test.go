@@ -0,0 +1,81 @@
+package ovirt_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+func TestCheckEngineTLS(t *testing.T) {
+	otherCA := otherCAFile(t)
+	testCases := []struct {
+		name   string
+		modify func(config *ovirt.Config)
+		// stop closes the engine before the check
+		stop            bool
+		settings        *ovirt.ConnectionSettings
+		expectErr       bool
+		expectNonComply bool
+	}{
+		{
+			name:     "compliant engine",
+			settings: &ovirt.ConnectionSettings{MinTLSVersion: tls.VersionTLS12},
+		},
+		{
+			name:     "TLS 1.3 profile",
+			settings: &ovirt.ConnectionSettings{MinTLSVersion: tls.VersionTLS13},
+		},
+		{
+			name:            "untrusted engine certificate",
+			modify:          func(config *ovirt.Config) { config.CAFile = otherCA },
+			settings:        &ovirt.ConnectionSettings{},
+			expectErr:       true,
+			expectNonComply: true,
+		},
+		{
+			name: "ovirt_insecure with strict TLS",
+			modify: func(config *ovirt.Config) {
+				config.CAFile = ""
+				config.Insecure = true
+			},
+			settings:        &ovirt.ConnectionSettings{StrictTLS: true},
+			expectErr:       true,
+			expectNonComply: true,
+		},
+		{
+			name: "plain HTTP with strict TLS",
+			modify: func(config *ovirt.Config) {
+				config.URL = "http://engine.example.com/ovirt-engine/api"
+			},
+			settings:        &ovirt.ConnectionSettings{StrictTLS: true},
+			expectErr:       true,
+			expectNonComply: true,
+		},
+		{
+			name:      "unreachable engine tells nothing about the compliance",
+			stop:      true,
+			settings:  &ovirt.ConnectionSettings{},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startEngine(t, tc.modify)
+			if tc.stop {
+				engine.Close()
+			}
+
+			err := ovirt.CheckEngineTLS(context.Background(), tc.settings)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			var complianceErr *ovirt.TLSComplianceError
+			if errors.As(err, &complianceErr) != tc.expectNonComply {
+				t.Errorf("expected a TLSComplianceError %t, got %v", tc.expectNonComply, err)
+			}
+		})
+	}
+}
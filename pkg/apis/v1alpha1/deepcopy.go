@@ -0,0 +1,129 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeepCopy returns a copy of the import which shares no memory with it.
+func (in *OvirtDiskImport) DeepCopy() *OvirtDiskImport {
+	if in == nil {
+		return nil
+	}
+	out := &OvirtDiskImport{
+		TypeMeta: in.TypeMeta,
+		Spec:     *in.Spec.DeepCopy(),
+		Status:   *in.Status.DeepCopy(),
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	return out
+}
+
+// DeepCopy returns a copy of the spec which shares no memory with it.
+func (in *OvirtDiskImportSpec) DeepCopy() *OvirtDiskImportSpec {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	if in.Claim != nil {
+		claim := *in.Claim
+		out.Claim = &claim
+	}
+	return &out
+}
+
+// DeepCopy returns a copy of the status which shares no memory with it.
+func (in *OvirtDiskImportStatus) DeepCopy() *OvirtDiskImportStatus {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	if in.Conditions != nil {
+		out.Conditions = make([]metav1.Condition, len(in.Conditions))
+		for i := range in.Conditions {
+			in.Conditions[i].DeepCopyInto(&out.Conditions[i])
+		}
+	}
+	return &out
+}
+
+// DeepCopy returns a copy of the populator which shares no memory with it.
+func (in *OvirtImagePopulator) DeepCopy() *OvirtImagePopulator {
+	if in == nil {
+		return nil
+	}
+	out := &OvirtImagePopulator{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+		Status:   *in.Status.DeepCopy(),
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.PersistentVolumeClaim != nil {
+		claim := *in.Spec.PersistentVolumeClaim
+		out.Spec.PersistentVolumeClaim = &claim
+	}
+	return out
+}
+
+// DeepCopy returns a copy of the status which shares no memory with it.
+func (in *OvirtImagePopulatorStatus) DeepCopy() *OvirtImagePopulatorStatus {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	if in.Volumes != nil {
+		out.Volumes = append([]OvirtImagePopulatorVolumeStatus(nil), in.Volumes...)
+	}
+	return &out
+}
+
+// DeepCopy returns a copy of the export which shares no memory with it.
+func (in *OvirtDiskExport) DeepCopy() *OvirtDiskExport {
+	if in == nil {
+		return nil
+	}
+	out := &OvirtDiskExport{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+		Status:   in.Status,
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Status.CompletionTime != nil {
+		out.Status.CompletionTime = in.Status.CompletionTime.DeepCopy()
+	}
+	return out
+}
+
+// DeepCopy returns a copy of the migration which shares no memory with it.
+func (in *VolumeMigration) DeepCopy() *VolumeMigration {
+	if in == nil {
+		return nil
+	}
+	out := &VolumeMigration{
+		TypeMeta: in.TypeMeta,
+		Spec:     in.Spec,
+		Status:   *in.Status.DeepCopy(),
+	}
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	if in.Spec.ClaimNames != nil {
+		out.Spec.ClaimNames = append([]string(nil), in.Spec.ClaimNames...)
+	}
+	return out
+}
+
+// DeepCopy returns a copy of the status which shares no memory with it.
+func (in *VolumeMigrationStatus) DeepCopy() *VolumeMigrationStatus {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	if in.Workloads != nil {
+		out.Workloads = append([]string(nil), in.Workloads...)
+	}
+	if in.Volumes != nil {
+		out.Volumes = append([]VolumeMigrationVolumeStatus(nil), in.Volumes...)
+	}
+	if in.CompletionTime != nil {
+		out.CompletionTime = in.CompletionTime.DeepCopy()
+	}
+	return &out
+}
@@ -0,0 +1,254 @@
+// Package v1alpha1 contains the custom resources reconciled by the oVirt CSI driver operator.
+// They are read and written through the dynamic client, there is no generated clientset.
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const GroupName = "csi.ovirt.org"
+
+var (
+	GroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	OvirtDiskImportResource     = GroupVersion.WithResource("ovirtdiskimports")
+	OvirtImagePopulatorResource = GroupVersion.WithResource("ovirtimagepopulators")
+	OvirtDiskExportResource     = GroupVersion.WithResource("ovirtdiskexports")
+	VolumeMigrationResource     = GroupVersion.WithResource("volumemigrations")
+)
+
+// OvirtDiskImport imports an existing oVirt disk, created outside of Kubernetes, as a
+// statically provisioned PV of the csi.ovirt.org driver.
+type OvirtDiskImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OvirtDiskImportSpec   `json:"spec"`
+	Status OvirtDiskImportStatus `json:"status,omitempty"`
+}
+
+// OvirtDiskImportSpec selects the disk and describes the PV and PVC created for it.
+type OvirtDiskImportSpec struct {
+	// DiskID is the ID of the disk. Exactly one of DiskID and DiskAlias must be set.
+	DiskID string `json:"diskID,omitempty"`
+	// DiskAlias is the alias of the disk, it must be unique in the engine.
+	DiskAlias string `json:"diskAlias,omitempty"`
+
+	// PersistentVolumeName is the name of the PV, the name of the import by default.
+	PersistentVolumeName string `json:"persistentVolumeName,omitempty"`
+	// StorageClassName of the PV and PVC, none by default.
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// VolumeMode of the PV and PVC, Filesystem by default.
+	VolumeMode corev1.PersistentVolumeMode `json:"volumeMode,omitempty"`
+	// FSType of a Filesystem volume, ext4 by default.
+	FSType string `json:"fsType,omitempty"`
+	// ReclaimPolicy of the PV, Retain by default so that the disk outlives the PV.
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+
+	// Claim creates a PVC pre-bound to the PV.
+	Claim *OvirtDiskImportClaim `json:"claim,omitempty"`
+}
+
+// OvirtDiskImportClaim is the PVC created for the imported disk.
+type OvirtDiskImportClaim struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// OvirtDiskImportStatus reports the imported disk and the created PV.
+type OvirtDiskImportStatus struct {
+	// DiskID is the ID of the imported disk.
+	DiskID string `json:"diskID,omitempty"`
+	// PersistentVolumeName is the name of the created PV.
+	PersistentVolumeName string `json:"persistentVolumeName,omitempty"`
+	// Capacity of the PV, the provisioned size of the disk.
+	Capacity string `json:"capacity,omitempty"`
+	// Conditions hold the Ready condition of the import.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// OvirtDiskImportConditionReady is True once the PV, and the PVC when requested, exist.
+const OvirtDiskImportConditionReady = "Ready"
+
+// OvirtImagePopulatorKind is the kind referenced by the dataSourceRef of the populated PVCs.
+const OvirtImagePopulatorKind = "OvirtImagePopulator"
+
+// OvirtImagePopulator is a volume populator: a PVC with a dataSourceRef to it in its namespace
+// gets a new oVirt disk filled from the source image.
+type OvirtImagePopulator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OvirtImagePopulatorSpec   `json:"spec"`
+	Status OvirtImagePopulatorStatus `json:"status,omitempty"`
+}
+
+// OvirtImagePopulatorSpec describes the source image, a qcow2 or raw file.
+type OvirtImagePopulatorSpec struct {
+	// URL is an HTTP(S) URL of the image, fetched by a pod in the namespace of the PVC. Exactly
+	// one of URL and PersistentVolumeClaim must be set.
+	URL string `json:"url,omitempty"`
+	// PersistentVolumeClaim holds the image as a file.
+	PersistentVolumeClaim *OvirtImagePopulatorClaimSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+// OvirtImagePopulatorClaimSource is an image file on a PVC in the namespace of the populator.
+type OvirtImagePopulatorClaimSource struct {
+	ClaimName string `json:"claimName"`
+	// Path of the image file relative to the root of the volume, without "..".
+	Path string `json:"path"`
+}
+
+// OvirtImagePopulatorPhase is the state of the population of one PVC.
+type OvirtImagePopulatorPhase string
+
+const (
+	OvirtImagePopulatorPending   OvirtImagePopulatorPhase = "Pending"
+	OvirtImagePopulatorUploading OvirtImagePopulatorPhase = "Uploading"
+	OvirtImagePopulatorSucceeded OvirtImagePopulatorPhase = "Succeeded"
+	OvirtImagePopulatorFailed    OvirtImagePopulatorPhase = "Failed"
+)
+
+// OvirtImagePopulatorStatus reports the population of the PVCs referencing the populator.
+type OvirtImagePopulatorStatus struct {
+	Volumes []OvirtImagePopulatorVolumeStatus `json:"volumes,omitempty"`
+}
+
+// OvirtImagePopulatorVolumeStatus reports the population of one PVC.
+type OvirtImagePopulatorVolumeStatus struct {
+	ClaimName string                   `json:"claimName"`
+	Phase     OvirtImagePopulatorPhase `json:"phase"`
+	// DiskID is the ID of the disk once it is uploaded.
+	DiskID string `json:"diskID,omitempty"`
+	// Progress of the upload in percent.
+	Progress      string `json:"progress,omitempty"`
+	UploadedBytes int64  `json:"uploadedBytes,omitempty"`
+	TotalBytes    int64  `json:"totalBytes,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+// OvirtDiskExportKind is the kind of OvirtDiskExport, the owner of its target pod.
+const OvirtDiskExportKind = "OvirtDiskExport"
+
+// OvirtDiskExport downloads the disk of a PV bound to a PVC in its namespace to an image file
+// on another PVC, e.g. for an offline backup.
+type OvirtDiskExport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OvirtDiskExportSpec   `json:"spec"`
+	Status OvirtDiskExportStatus `json:"status,omitempty"`
+}
+
+// OvirtDiskExportSpec selects the exported volume and the image file.
+type OvirtDiskExportSpec struct {
+	// ClaimName is the PVC whose disk is exported.
+	ClaimName string `json:"claimName"`
+	// Format of the image, raw or qcow2, raw by default.
+	Format string `json:"format,omitempty"`
+	// Target is the image file, its SHA-256 checksum is written next to it.
+	Target OvirtDiskExportTarget `json:"target"`
+}
+
+// OvirtDiskExportTarget is a file on a PVC in the namespace of the export.
+type OvirtDiskExportTarget struct {
+	ClaimName string `json:"claimName"`
+	// Path of the image file relative to the root of the volume, without "..".
+	Path string `json:"path"`
+}
+
+// OvirtDiskExportPhase is the state of an export.
+type OvirtDiskExportPhase string
+
+const (
+	OvirtDiskExportPending   OvirtDiskExportPhase = "Pending"
+	OvirtDiskExportRunning   OvirtDiskExportPhase = "Exporting"
+	OvirtDiskExportSucceeded OvirtDiskExportPhase = "Succeeded"
+	OvirtDiskExportFailed    OvirtDiskExportPhase = "Failed"
+)
+
+// OvirtDiskExportStatus reports the progress and result of the export.
+type OvirtDiskExportStatus struct {
+	Phase OvirtDiskExportPhase `json:"phase,omitempty"`
+	// DiskID is the ID of the exported disk.
+	DiskID string `json:"diskID,omitempty"`
+	// Progress of the download in percent.
+	Progress        string `json:"progress,omitempty"`
+	DownloadedBytes int64  `json:"downloadedBytes,omitempty"`
+	TotalBytes      int64  `json:"totalBytes,omitempty"`
+	// Checksum is the SHA-256 checksum of the image, verified against the written file.
+	Checksum       string       `json:"checksum,omitempty"`
+	Message        string       `json:"message,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// VolumeMigrationKind is the kind of VolumeMigration, the owner of its copy Jobs.
+const VolumeMigrationKind = "VolumeMigration"
+
+// VolumeMigration moves the data of PVCs bound to PVs of csi.ovirt.org in its namespace to new
+// PVCs of another StorageClass and switches the Deployments and StatefulSets using them over.
+type VolumeMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VolumeMigrationSpec   `json:"spec"`
+	Status VolumeMigrationStatus `json:"status,omitempty"`
+}
+
+// VolumeMigrationSpec selects the migrated PVCs and their new StorageClass.
+type VolumeMigrationSpec struct {
+	// ClaimNames are the migrated PVCs. Each gets a new PVC named <name>-migrated.
+	ClaimNames []string `json:"claimNames"`
+	// StorageClassName of the new PVCs, of another driver.
+	StorageClassName string `json:"storageClassName"`
+	// ServiceAccountName runs the copy Jobs, the default service account by default. It must
+	// be allowed to run as root, which preserves the owners of the files.
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// VolumeMigrationPhase is the state of a migration.
+type VolumeMigrationPhase string
+
+const (
+	VolumeMigrationPending     VolumeMigrationPhase = "Pending"
+	VolumeMigrationScalingDown VolumeMigrationPhase = "ScalingDown"
+	VolumeMigrationCopying     VolumeMigrationPhase = "Copying"
+	VolumeMigrationCuttingOver VolumeMigrationPhase = "CuttingOver"
+	VolumeMigrationSucceeded   VolumeMigrationPhase = "Succeeded"
+	VolumeMigrationFailed      VolumeMigrationPhase = "Failed"
+)
+
+// VolumeMigrationStatus reports the progress and result of the migration.
+type VolumeMigrationStatus struct {
+	Phase   VolumeMigrationPhase `json:"phase,omitempty"`
+	Message string               `json:"message,omitempty"`
+	// Workloads use the PVCs and are scaled down during the copy, e.g. Deployment/web.
+	Workloads []string                      `json:"workloads,omitempty"`
+	Volumes   []VolumeMigrationVolumeStatus `json:"volumes,omitempty"`
+
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// VolumeMigrationVolumePhase is the state of the copy of one PVC.
+type VolumeMigrationVolumePhase string
+
+const (
+	VolumeMigrationVolumePending VolumeMigrationVolumePhase = "Pending"
+	VolumeMigrationVolumeCopying VolumeMigrationVolumePhase = "Copying"
+	VolumeMigrationVolumeCopied  VolumeMigrationVolumePhase = "Copied"
+	VolumeMigrationVolumeFailed  VolumeMigrationVolumePhase = "Failed"
+)
+
+// VolumeMigrationVolumeStatus reports the copy of one PVC.
+type VolumeMigrationVolumeStatus struct {
+	ClaimName       string                     `json:"claimName"`
+	TargetClaimName string                     `json:"targetClaimName,omitempty"`
+	Phase           VolumeMigrationVolumePhase `json:"phase"`
+	// Progress of the copy in percent.
+	Progress    string `json:"progress,omitempty"`
+	CopiedBytes int64  `json:"copiedBytes,omitempty"`
+	TotalBytes  int64  `json:"totalBytes,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
@@ -0,0 +1,442 @@
+package operator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ovirt/csi-driver-operator/assets"
+)
+
+const (
+	operatorConfigMap = "ovirt-csi-driver-operator-config"
+	operatorConfigKey = "config.yaml"
+
+	maxLogLevel = 10
+)
+
+var supportedFSTypes = sets.NewString("ext4", "ext3", "xfs")
+
+// OperatorConfig is the optional operator configuration, stored as YAML under the
+// config.yaml key of the ovirt-csi-driver-operator-config ConfigMap. It is applied
+// on top of the embedded assets by the Deployment and DaemonSet hooks.
+type OperatorConfig struct {
+	Controller ControllerConfig `json:"controller,omitempty"`
+	Node       NodeConfig       `json:"node,omitempty"`
+	Engine     EngineConfig     `json:"engine,omitempty"`
+	Overcommit OvercommitConfig `json:"overcommit,omitempty"`
+	DiskStatus DiskStatusConfig `json:"diskStatus,omitempty"`
+	Alerts     AlertsConfig     `json:"alerts,omitempty"`
+	// DefaultStorageClassFailover moves the default StorageClass of the driver away from an
+	// unusable storage domain.
+	DefaultStorageClassFailover StorageClassFailoverConfig `json:"defaultStorageClassFailover,omitempty"`
+	// Maintenance pauses the provisioning of new volumes, e.g. during a maintenance of the
+	// storage domains. Attaching, mounting and resizing volumes keep working.
+	Maintenance bool `json:"maintenance,omitempty"`
+}
+
+// DiskStatusConfig tunes the checks of the status of the disks behind the PVs.
+type DiskStatusConfig struct {
+	// Threshold is how long a disk may stay locked or illegal before upgrades are blocked.
+	Threshold *metav1.Duration `json:"threshold,omitempty"`
+	// BatchSize is the number of PVs whose disk status is recorded per minute.
+	BatchSize *int `json:"batchSize,omitempty"`
+}
+
+// StorageClassFailoverConfig tunes the failover of the default StorageClass between the
+// StorageClasses of the driver. The thresholds differ to avoid flapping.
+type StorageClassFailoverConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// MinFreePercent is the free space, in percent of the size of the storage domain, below
+	// which the default StorageClass fails over.
+	MinFreePercent *int `json:"minFreePercent,omitempty"`
+	// RecoveryFreePercent is the free space a storage domain needs to take over the default,
+	// or to get it back.
+	RecoveryFreePercent *int `json:"recoveryFreePercent,omitempty"`
+	// FailbackDelay is how long the storage domain of the original default StorageClass has to
+	// stay usable before the default moves back.
+	FailbackDelay *metav1.Duration `json:"failbackDelay,omitempty"`
+}
+
+// AlertsConfig holds the thresholds of the alerting rules of the driver.
+type AlertsConfig struct {
+	// OperationErrorPercent is the share of failed provisioner or attacher operations over
+	// 10 minutes above which an alert fires.
+	OperationErrorPercent *int `json:"operationErrorPercent,omitempty"`
+	// StorageDomainFreePercent is the free space of a storage domain, in percent of its size,
+	// below which an alert fires.
+	StorageDomainFreePercent *int `json:"storageDomainFreePercent,omitempty"`
+	// VolumeAttachmentTimeout is how long a volume may wait to be attached or detached before
+	// an alert fires.
+	VolumeAttachmentTimeout *metav1.Duration `json:"volumeAttachmentTimeout,omitempty"`
+}
+
+// OvercommitEnforcement is the action taken on overcommitted storage domains.
+type OvercommitEnforcement string
+
+const (
+	// OvercommitEnforcementNone only reports overcommitted storage domains.
+	OvercommitEnforcementNone OvercommitEnforcement = "None"
+	// OvercommitEnforcementDemoteStorageClass additionally marks the default StorageClass of
+	// the driver as non-default while its storage domain is overcommitted.
+	OvercommitEnforcementDemoteStorageClass OvercommitEnforcement = "DemoteStorageClass"
+)
+
+// OvercommitConfig guards the storage domains against thin provisioning overcommit.
+type OvercommitConfig struct {
+	// MaxPercent limits the provisioned size of the CSI disks in a storage domain, in percent
+	// of the size of the storage domain (available plus used). Zero disables the guard.
+	MaxPercent *int `json:"maxPercent,omitempty"`
+	// Enforcement defaults to None.
+	Enforcement OvercommitEnforcement `json:"enforcement,omitempty"`
+}
+
+// EngineConfig tunes the connection of the operator to the oVirt engine.
+type EngineConfig struct {
+	// StrictTLS refuses ovirt_insecure and plain HTTP engine URLs. The cluster TLS
+	// security profile is applied to the engine connection in any case.
+	StrictTLS bool `json:"strictTLS,omitempty"`
+	// CertificateExpiryWarning is how long before the expiry of the certificate chain of the
+	// engine or of ovirt_cafile the operator warns about it.
+	CertificateExpiryWarning *metav1.Duration `json:"certificateExpiryWarning,omitempty"`
+}
+
+// ControllerConfig tunes the containers of the controller Deployment.
+type ControllerConfig struct {
+	Provisioner SidecarConfig `json:"provisioner,omitempty"`
+	Attacher    SidecarConfig `json:"attacher,omitempty"`
+	Resizer     SidecarConfig `json:"resizer,omitempty"`
+	// Resources overrides requests and limits, keyed by container name.
+	Resources map[string]corev1.ResourceRequirements `json:"resources,omitempty"`
+	// LogLevels overrides the --v verbosity, keyed by container name.
+	LogLevels map[string]int `json:"logLevels,omitempty"`
+}
+
+// NodeConfig tunes the containers and the placement of the node DaemonSet.
+type NodeConfig struct {
+	// Resources overrides requests and limits, keyed by container name.
+	Resources map[string]corev1.ResourceRequirements `json:"resources,omitempty"`
+	// LogLevels overrides the --v verbosity, keyed by container name.
+	LogLevels map[string]int `json:"logLevels,omitempty"`
+	// NodeSelector restricts the node plugin to the matching nodes.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations replace the default toleration of all taints.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// OvirtVMsOnly restricts the node plugin to nodes backed by an oVirt VM. The operator
+	// detects them and records the result in the ovirtVMNodeLabel node label.
+	OvirtVMsOnly bool `json:"ovirtVMsOnly,omitempty"`
+	// StartupTaint taints new nodes with startupTaintKey until the node plugin is ready on them.
+	StartupTaint bool `json:"startupTaint,omitempty"`
+	// CordonPausedVMs cordons the nodes whose VM the engine paused or reports not responding
+	// and taints them with vmPausedTaintKey until the VM is up again.
+	CordonPausedVMs bool `json:"cordonPausedVMs,omitempty"`
+}
+
+// SidecarConfig holds the arguments of a CSI sidecar.
+type SidecarConfig struct {
+	Timeout       *metav1.Duration `json:"timeout,omitempty"`
+	WorkerThreads *int             `json:"workerThreads,omitempty"`
+	// DefaultFSType is only supported by the provisioner.
+	DefaultFSType string `json:"defaultFSType,omitempty"`
+}
+
+// setDefaults fills the unset fields with the values shipped in the assets and the
+// sidecar defaults.
+func (c *OperatorConfig) setDefaults() {
+	c.Controller.Provisioner.setDefaults(180*time.Second, 100)
+	if c.Controller.Provisioner.DefaultFSType == "" {
+		c.Controller.Provisioner.DefaultFSType = "ext4"
+	}
+	c.Controller.Attacher.setDefaults(120*time.Second, 10)
+	c.Controller.Resizer.setDefaults(120*time.Second, 10)
+	if c.Overcommit.MaxPercent == nil {
+		maxPercent := 200
+		c.Overcommit.MaxPercent = &maxPercent
+	}
+	if c.Overcommit.Enforcement == "" {
+		c.Overcommit.Enforcement = OvercommitEnforcementNone
+	}
+	if c.DiskStatus.Threshold == nil {
+		c.DiskStatus.Threshold = &metav1.Duration{Duration: 15 * time.Minute}
+	}
+	if c.DiskStatus.BatchSize == nil {
+		batchSize := 20
+		c.DiskStatus.BatchSize = &batchSize
+	}
+	if c.Alerts.OperationErrorPercent == nil {
+		operationErrorPercent := 10
+		c.Alerts.OperationErrorPercent = &operationErrorPercent
+	}
+	if c.Alerts.StorageDomainFreePercent == nil {
+		storageDomainFreePercent := 10
+		c.Alerts.StorageDomainFreePercent = &storageDomainFreePercent
+	}
+	if c.Alerts.VolumeAttachmentTimeout == nil {
+		c.Alerts.VolumeAttachmentTimeout = &metav1.Duration{Duration: 10 * time.Minute}
+	}
+	if c.Engine.CertificateExpiryWarning == nil {
+		c.Engine.CertificateExpiryWarning = &metav1.Duration{Duration: 30 * 24 * time.Hour}
+	}
+	failover := &c.DefaultStorageClassFailover
+	if failover.MinFreePercent == nil {
+		minFreePercent := 5
+		failover.MinFreePercent = &minFreePercent
+	}
+	if failover.RecoveryFreePercent == nil {
+		recoveryFreePercent := 15
+		if *failover.MinFreePercent > recoveryFreePercent {
+			recoveryFreePercent = *failover.MinFreePercent
+		}
+		failover.RecoveryFreePercent = &recoveryFreePercent
+	}
+	if failover.FailbackDelay == nil {
+		failover.FailbackDelay = &metav1.Duration{Duration: 15 * time.Minute}
+	}
+}
+
+func (s *SidecarConfig) setDefaults(timeout time.Duration, workerThreads int) {
+	if s.Timeout == nil {
+		s.Timeout = &metav1.Duration{Duration: timeout}
+	}
+	if s.WorkerThreads == nil {
+		s.WorkerThreads = &workerThreads
+	}
+}
+
+// validate checks the configuration against the containers of the controller and node assets.
+func (c *OperatorConfig) validate(controllerContainers, nodeContainers sets.String) error {
+	var errs []string
+	errs = append(errs, c.Controller.Provisioner.validate("controller.provisioner", true)...)
+	errs = append(errs, c.Controller.Attacher.validate("controller.attacher", false)...)
+	errs = append(errs, c.Controller.Resizer.validate("controller.resizer", false)...)
+	errs = append(errs, validateContainerOverrides("controller", controllerContainers, c.Controller.Resources, c.Controller.LogLevels)...)
+	errs = append(errs, validateContainerOverrides("node", nodeContainers, c.Node.Resources, c.Node.LogLevels)...)
+	errs = append(errs, c.Node.validatePlacement()...)
+	if c.Overcommit.MaxPercent != nil && *c.Overcommit.MaxPercent < 0 {
+		errs = append(errs, "overcommit.maxPercent must not be negative")
+	}
+	if c.DiskStatus.Threshold != nil && c.DiskStatus.Threshold.Duration < 0 {
+		errs = append(errs, "diskStatus.threshold must not be negative")
+	}
+	if c.DiskStatus.BatchSize != nil && *c.DiskStatus.BatchSize <= 0 {
+		errs = append(errs, "diskStatus.batchSize must be positive")
+	}
+	if p := c.Alerts.OperationErrorPercent; p != nil && (*p < 0 || *p > 100) {
+		errs = append(errs, "alerts.operationErrorPercent must be between 0 and 100")
+	}
+	if p := c.Alerts.StorageDomainFreePercent; p != nil && (*p < 0 || *p > 100) {
+		errs = append(errs, "alerts.storageDomainFreePercent must be between 0 and 100")
+	}
+	if c.Alerts.VolumeAttachmentTimeout != nil && c.Alerts.VolumeAttachmentTimeout.Duration < time.Minute {
+		errs = append(errs, "alerts.volumeAttachmentTimeout must be at least 1m")
+	}
+	if c.Engine.CertificateExpiryWarning != nil && c.Engine.CertificateExpiryWarning.Duration < 0 {
+		errs = append(errs, "engine.certificateExpiryWarning must not be negative")
+	}
+	errs = append(errs, c.DefaultStorageClassFailover.validate()...)
+	switch c.Overcommit.Enforcement {
+	case "", OvercommitEnforcementNone, OvercommitEnforcementDemoteStorageClass:
+	default:
+		errs = append(errs, fmt.Sprintf("overcommit.enforcement must be one of %s, %s", OvercommitEnforcementNone, OvercommitEnforcementDemoteStorageClass))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (f *StorageClassFailoverConfig) validate() []string {
+	var errs []string
+	if p := f.MinFreePercent; p != nil && (*p < 0 || *p > 100) {
+		errs = append(errs, "defaultStorageClassFailover.minFreePercent must be between 0 and 100")
+	}
+	if p := f.RecoveryFreePercent; p != nil && (*p < 0 || *p > 100) {
+		errs = append(errs, "defaultStorageClassFailover.recoveryFreePercent must be between 0 and 100")
+	}
+	if f.MinFreePercent != nil && f.RecoveryFreePercent != nil && *f.RecoveryFreePercent < *f.MinFreePercent {
+		errs = append(errs, "defaultStorageClassFailover.recoveryFreePercent must not be below minFreePercent")
+	}
+	if f.FailbackDelay != nil && f.FailbackDelay.Duration < 0 {
+		errs = append(errs, "defaultStorageClassFailover.failbackDelay must not be negative")
+	}
+	return errs
+}
+
+func (s *SidecarConfig) validate(path string, supportsFSType bool) []string {
+	var errs []string
+	if s.Timeout != nil && s.Timeout.Duration < time.Second {
+		errs = append(errs, fmt.Sprintf("%s.timeout must be at least 1s", path))
+	}
+	if s.WorkerThreads != nil && *s.WorkerThreads < 1 {
+		errs = append(errs, fmt.Sprintf("%s.workerThreads must be at least 1", path))
+	}
+	if s.DefaultFSType != "" {
+		if !supportsFSType {
+			errs = append(errs, fmt.Sprintf("%s.defaultFSType is not supported", path))
+		} else if !supportedFSTypes.Has(s.DefaultFSType) {
+			errs = append(errs, fmt.Sprintf("%s.defaultFSType must be one of %s", path, strings.Join(supportedFSTypes.List(), ", ")))
+		}
+	}
+	return errs
+}
+
+func (n *NodeConfig) validatePlacement() []string {
+	var errs []string
+	for _, key := range sortedKeys(n.NodeSelector) {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Sprintf("node.nodeSelector key %q: %s", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(n.NodeSelector[key]) {
+			errs = append(errs, fmt.Sprintf("node.nodeSelector[%s]: %s", key, msg))
+		}
+	}
+	for i, t := range n.Tolerations {
+		switch t.Operator {
+		case corev1.TolerationOpExists:
+			if t.Value != "" {
+				errs = append(errs, fmt.Sprintf("node.tolerations[%d]: value must be empty with operator Exists", i))
+			}
+		case corev1.TolerationOpEqual, "":
+		default:
+			errs = append(errs, fmt.Sprintf("node.tolerations[%d]: unsupported operator %q", i, t.Operator))
+		}
+		switch t.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute, "":
+		default:
+			errs = append(errs, fmt.Sprintf("node.tolerations[%d]: unsupported effect %q", i, t.Effect))
+		}
+	}
+	return errs
+}
+
+func validateContainerOverrides(path string, containers sets.String, resources map[string]corev1.ResourceRequirements, logLevels map[string]int) []string {
+	var errs []string
+	for _, name := range sortedKeys(resources) {
+		if !containers.Has(name) {
+			errs = append(errs, fmt.Sprintf("%s.resources: unknown container %q", path, name))
+			continue
+		}
+		r := resources[name]
+		for resourceName, limit := range r.Limits {
+			if request, ok := r.Requests[resourceName]; ok && request.Cmp(limit) > 0 {
+				errs = append(errs, fmt.Sprintf("%s.resources[%s]: %s request must not exceed its limit", path, name, resourceName))
+			}
+		}
+	}
+	for _, name := range sortedKeys(logLevels) {
+		if !containers.Has(name) {
+			errs = append(errs, fmt.Sprintf("%s.logLevels: unknown container %q", path, name))
+			continue
+		}
+		if level := logLevels[name]; level < 0 || level > maxLogLevel {
+			errs = append(errs, fmt.Sprintf("%s.logLevels[%s] must be between 0 and %d", path, name, maxLogLevel))
+		}
+	}
+	return errs
+}
+
+// String summarizes the effective configuration for the operator status.
+func (c *OperatorConfig) String() string {
+	p, a, r := c.Controller.Provisioner, c.Controller.Attacher, c.Controller.Resizer
+	failover := c.DefaultStorageClassFailover
+	return fmt.Sprintf(
+		"provisioner timeout=%s workerThreads=%d defaultFSType=%s; attacher timeout=%s workerThreads=%d; resizer timeout=%s workerThreads=%d; %d resource and %d log level overrides; overcommit maxPercent=%d enforcement=%s; disk status threshold=%s batchSize=%d; alerts operationErrorPercent=%d storageDomainFreePercent=%d volumeAttachmentTimeout=%s; engine certificateExpiryWarning=%s; default StorageClass failover enabled=%t minFreePercent=%d recoveryFreePercent=%d failbackDelay=%s; maintenance=%t",
+		p.Timeout.Duration, *p.WorkerThreads, p.DefaultFSType,
+		a.Timeout.Duration, *a.WorkerThreads,
+		r.Timeout.Duration, *r.WorkerThreads,
+		len(c.Controller.Resources)+len(c.Node.Resources),
+		len(c.Controller.LogLevels)+len(c.Node.LogLevels),
+		*c.Overcommit.MaxPercent, c.Overcommit.Enforcement,
+		c.DiskStatus.Threshold.Duration, *c.DiskStatus.BatchSize,
+		*c.Alerts.OperationErrorPercent, *c.Alerts.StorageDomainFreePercent, c.Alerts.VolumeAttachmentTimeout.Duration,
+		c.Engine.CertificateExpiryWarning.Duration,
+		failover.Enabled, *failover.MinFreePercent, *failover.RecoveryFreePercent, failover.FailbackDelay.Duration,
+		c.Maintenance,
+	)
+}
+
+// newOperatorConfigGetter returns a function which reads, defaults and validates the
+// operator configuration. A missing ConfigMap results in the default configuration.
+func newOperatorConfigGetter(configMapLister corelisters.ConfigMapLister) func() (*OperatorConfig, error) {
+	controllerContainers := assetContainerNames("controller.yaml")
+	nodeContainers := assetContainerNames("node.yaml")
+	return func() (*OperatorConfig, error) {
+		config := &OperatorConfig{}
+		cm, err := configMapLister.ConfigMaps(defaultNamespace).Get(operatorConfigMap)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get ConfigMap %s/%s: %w", defaultNamespace, operatorConfigMap, err)
+		}
+		if err == nil {
+			if err := yaml.UnmarshalStrict([]byte(cm.Data[operatorConfigKey]), config); err != nil {
+				return nil, fmt.Errorf("failed to parse %s in ConfigMap %s/%s: %w", operatorConfigKey, defaultNamespace, operatorConfigMap, err)
+			}
+		}
+		if err := config.validate(controllerContainers, nodeContainers); err != nil {
+			return nil, fmt.Errorf("invalid operator configuration in ConfigMap %s/%s: %w", defaultNamespace, operatorConfigMap, err)
+		}
+		config.setDefaults()
+		return config, nil
+	}
+}
+
+// newStrictTLSGetter returns a function reading engine.strictTLS of the operator configuration
+// on its own. Unlike newOperatorConfigGetter it ignores the rest of the configuration, so that
+// an invalid setting elsewhere does not break the engine connection.
+func newStrictTLSGetter(configMapLister corelisters.ConfigMapLister) func() (bool, error) {
+	return func() (bool, error) {
+		cm, err := configMapLister.ConfigMaps(defaultNamespace).Get(operatorConfigMap)
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to get ConfigMap %s/%s: %w", defaultNamespace, operatorConfigMap, err)
+		}
+		var config struct {
+			Engine struct {
+				StrictTLS bool `json:"strictTLS,omitempty"`
+			} `json:"engine,omitempty"`
+		}
+		if err := yaml.Unmarshal([]byte(cm.Data[operatorConfigKey]), &config); err != nil {
+			return false, fmt.Errorf("failed to parse engine.strictTLS in ConfigMap %s/%s: %w", defaultNamespace, operatorConfigMap, err)
+		}
+		return config.Engine.StrictTLS, nil
+	}
+}
+
+// assetContainerNames returns the names of all containers of the Deployment or DaemonSet asset.
+func assetContainerNames(file string) sets.String {
+	manifest, err := assets.ReadFile(file)
+	if err != nil {
+		panic(fmt.Sprintf("asset: Asset(%v): %v", file, err))
+	}
+	var containers []corev1.Container
+	if strings.Contains(string(manifest), "kind: DaemonSet") {
+		containers = resourceread.ReadDaemonSetV1OrDie(manifest).Spec.Template.Spec.Containers
+	} else {
+		containers = resourceread.ReadDeploymentV1OrDie(manifest).Spec.Template.Spec.Containers
+	}
+	names := sets.NewString()
+	for _, c := range containers {
+		names.Insert(c.Name)
+	}
+	return names
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package operator
+
+import (
+	"context"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// OvirtOperatorConfigController validates the operator configuration and reports the
+// effective values in the <name>Available condition. An invalid configuration sets
+// <name>Degraded, and the operand hooks keep failing until it is fixed.
+type OvirtOperatorConfigController struct {
+	name           string
+	operatorClient v1helpers.OperatorClient
+	getConfig      func() (*OperatorConfig, error)
+	eventRecorder  events.Recorder
+}
+
+func NewOvirtOperatorConfigController(
+	operatorClient v1helpers.OperatorClient,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtOperatorConfigController{
+		name:           "OvirtOperatorConfigController",
+		operatorClient: operatorClient,
+		getConfig:      getConfig,
+		eventRecorder:  eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		configMapInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtOperatorConfigController) sync(ctx context.Context, _ factory.SyncContext) error {
+	config, err := c.getConfig()
+	if err != nil {
+		klog.Errorf("failed to load operator configuration: %v", err)
+		return err
+	}
+
+	availableCondition := operatorapi.OperatorCondition{
+		Type:    c.name + operatorapi.OperatorStatusTypeAvailable,
+		Status:  operatorapi.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: config.String(),
+	}
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(availableCondition))
+	return err
+}
@@ -0,0 +1,310 @@
+package operator
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	configv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/operator/csi/csidrivernodeservicecontroller"
+	dc "github.com/openshift/library-go/pkg/operator/deploymentcontroller"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	provisionerContainer          = "csi-provisioner"
+	provisionerRBACProxyContainer = "provisioner-kube-rbac-proxy"
+	attacherContainer             = "csi-attacher"
+	resizerContainer              = "csi-resizer"
+
+	tlsCipherSuitesFlag = "--tls-cipher-suites"
+)
+
+// withOperatorConfigDeploymentHook applies the sidecar arguments, resources and log
+// levels from the operator configuration to the controller Deployment. In maintenance
+// the provisioner and the proxy of its metrics are removed, withMaintenanceServiceMonitor
+// stops scraping them.
+func withOperatorConfigDeploymentHook(getConfig func() (*OperatorConfig, error)) dc.DeploymentHookFunc {
+	return func(_ *opv1.OperatorSpec, deployment *appsv1.Deployment) error {
+		config, err := getConfig()
+		if err != nil {
+			return err
+		}
+		podSpec := &deployment.Spec.Template.Spec
+		for i := range podSpec.Containers {
+			container := &podSpec.Containers[i]
+			switch container.Name {
+			case provisionerContainer:
+				applySidecarConfig(container, config.Controller.Provisioner, "--worker-threads")
+				setContainerArg(container, "--default-fstype", config.Controller.Provisioner.DefaultFSType)
+			case attacherContainer:
+				applySidecarConfig(container, config.Controller.Attacher, "--worker-threads")
+			case resizerContainer:
+				applySidecarConfig(container, config.Controller.Resizer, "--workers")
+			}
+		}
+		if err := applyContainerOverrides(podSpec, config.Controller.Resources, config.Controller.LogLevels); err != nil {
+			return err
+		}
+		if config.Maintenance {
+			removeContainers(podSpec, provisionerContainer, provisionerRBACProxyContainer)
+		}
+		return nil
+	}
+}
+
+// withOperatorConfigDaemonSetHook applies the resources, log levels and placement from
+// the operator configuration to the node DaemonSet.
+func withOperatorConfigDaemonSetHook(getConfig func() (*OperatorConfig, error)) csidrivernodeservicecontroller.DaemonSetHookFunc {
+	return func(_ *opv1.OperatorSpec, daemonSet *appsv1.DaemonSet) error {
+		config, err := getConfig()
+		if err != nil {
+			return err
+		}
+		podSpec := &daemonSet.Spec.Template.Spec
+		if len(config.Node.NodeSelector) > 0 {
+			if podSpec.NodeSelector == nil {
+				podSpec.NodeSelector = map[string]string{}
+			}
+			for k, v := range config.Node.NodeSelector {
+				podSpec.NodeSelector[k] = v
+			}
+		}
+		if config.Node.OvirtVMsOnly {
+			podSpec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{ovirtVMNodeRequirement},
+					}},
+				},
+			}}
+		}
+		if len(config.Node.Tolerations) > 0 {
+			podSpec.Tolerations = config.Node.Tolerations
+			if config.Node.StartupTaint {
+				// the node plugin must run on the nodes waiting for it
+				podSpec.Tolerations = append(podSpec.Tolerations, corev1.Toleration{
+					Key:      startupTaint.Key,
+					Operator: corev1.TolerationOpExists,
+					Effect:   startupTaint.Effect,
+				})
+			}
+		}
+		return applyContainerOverrides(podSpec, config.Node.Resources, config.Node.LogLevels)
+	}
+}
+
+// withTLSProfileDeploymentHook sets the cipher suites of the kube-rbac-proxy containers of
+// the controller Deployment to those of the TLS profile of the cluster API server, instead
+// of the default list library-go fills in.
+func withTLSProfileDeploymentHook(apiServerLister configlisters.APIServerLister) dc.DeploymentHookFunc {
+	return func(_ *opv1.OperatorSpec, deployment *appsv1.Deployment) error {
+		return applyTLSProfile(&deployment.Spec.Template.Spec, apiServerLister)
+	}
+}
+
+// withTLSProfileDaemonSetHook sets the cipher suites of the kube-rbac-proxy of the node
+// DaemonSet like withTLSProfileDeploymentHook. The DaemonSet controller of library-go does
+// not replace ${TLS_CIPHER_SUITES}, so the node.yaml placeholder is always filled here.
+func withTLSProfileDaemonSetHook(apiServerLister configlisters.APIServerLister) csidrivernodeservicecontroller.DaemonSetHookFunc {
+	return func(_ *opv1.OperatorSpec, daemonSet *appsv1.DaemonSet) error {
+		return applyTLSProfile(&daemonSet.Spec.Template.Spec, apiServerLister)
+	}
+}
+
+// applyTLSProfile sets the cipher suites of the API server TLS profile on the containers
+// having the --tls-cipher-suites flag. Without an APIServer the Intermediate profile applies.
+func applyTLSProfile(podSpec *corev1.PodSpec, apiServerLister configlisters.APIServerLister) error {
+	apiServer, err := apiServerLister.Get(clusterConfigName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get APIServer %s: %w", clusterConfigName, err)
+	}
+	var profile *configv1.TLSSecurityProfile
+	if err == nil {
+		profile = apiServer.Spec.TLSSecurityProfile
+	}
+	_, cipherSuites, err := tlsProfileSettings(profile)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(cipherSuites))
+	for _, cipherSuite := range cipherSuites {
+		names = append(names, tls.CipherSuiteName(cipherSuite))
+	}
+	for i := range podSpec.Containers {
+		for _, arg := range podSpec.Containers[i].Args {
+			if strings.HasPrefix(arg, tlsCipherSuitesFlag+"=") {
+				setContainerArg(&podSpec.Containers[i], tlsCipherSuitesFlag, strings.Join(names, ","))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// prometheusRuleAsset holds the alerting rules with placeholders for their thresholds
+const prometheusRuleAsset = "prometheusrule.yaml"
+
+// withAlertThresholds returns an AssetFunc which fills the alert thresholds of the operator
+// configuration into the PrometheusRule asset and passes the other assets through.
+func withAlertThresholds(assetFunc resourceapply.AssetFunc, getConfig func() (*OperatorConfig, error)) resourceapply.AssetFunc {
+	return func(name string) ([]byte, error) {
+		manifest, err := assetFunc(name)
+		if err != nil || name != prometheusRuleAsset {
+			return manifest, err
+		}
+		config, err := getConfig()
+		if err != nil {
+			return nil, err
+		}
+		alerts := config.Alerts
+		// 0 disables the overcommit check, no storage domain exceeds Inf
+		overcommitMaxPercent := "Inf"
+		if maxPercent := *config.Overcommit.MaxPercent; maxPercent > 0 {
+			overcommitMaxPercent = strconv.Itoa(maxPercent)
+		}
+		return []byte(strings.NewReplacer(
+			"${OPERATION_ERROR_PERCENT}", strconv.Itoa(*alerts.OperationErrorPercent),
+			"${STORAGE_DOMAIN_FREE_PERCENT}", strconv.Itoa(*alerts.StorageDomainFreePercent),
+			"${VOLUME_ATTACHMENT_TIMEOUT_SECONDS}", strconv.Itoa(int(alerts.VolumeAttachmentTimeout.Seconds())),
+			"${OVERCOMMIT_MAX_PERCENT}", overcommitMaxPercent,
+		).Replace(string(manifest))), nil
+	}
+}
+
+// serviceMonitorAsset is the ServiceMonitor of the controller metrics
+const serviceMonitorAsset = "servicemonitor.yaml"
+
+// provisionerMetricsPort is the Service port of the provisioner metrics
+const provisionerMetricsPort = "provisioner-m"
+
+// withMaintenanceServiceMonitor returns an AssetFunc which drops the endpoint of the
+// provisioner metrics from the ServiceMonitor asset in maintenance, when the provisioner
+// does not run, and passes the other assets through.
+func withMaintenanceServiceMonitor(assetFunc resourceapply.AssetFunc, getConfig func() (*OperatorConfig, error)) resourceapply.AssetFunc {
+	return func(name string) ([]byte, error) {
+		manifest, err := assetFunc(name)
+		if err != nil || name != serviceMonitorAsset {
+			return manifest, err
+		}
+		config, err := getConfig()
+		if err != nil {
+			return nil, err
+		}
+		if !config.Maintenance {
+			return manifest, nil
+		}
+		var serviceMonitor map[string]interface{}
+		if err := yaml.Unmarshal(manifest, &serviceMonitor); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		spec, _ := serviceMonitor["spec"].(map[string]interface{})
+		endpoints, _ := spec["endpoints"].([]interface{})
+		var kept []interface{}
+		for _, endpoint := range endpoints {
+			if e, ok := endpoint.(map[string]interface{}); ok && e["port"] == provisionerMetricsPort {
+				continue
+			}
+			kept = append(kept, endpoint)
+		}
+		spec["endpoints"] = kept
+		return yaml.Marshal(serviceMonitor)
+	}
+}
+
+// ovirtVMNodeRequirement keeps the node plugin off the nodes labeled as not backed by an
+// oVirt VM. Nodes not labeled yet stay eligible, so that the DaemonSet template does not
+// change while the OvirtNodePlacementController labels new nodes.
+var ovirtVMNodeRequirement = corev1.NodeSelectorRequirement{
+	Key:      ovirtVMNodeLabel,
+	Operator: corev1.NodeSelectorOpNotIn,
+	Values:   []string{"false"},
+}
+
+// nodePluginRunsOn tells whether the node plugin is placed on the node with the operator
+// configuration.
+func nodePluginRunsOn(config *OperatorConfig, node *corev1.Node) bool {
+	if !labels.SelectorFromSet(config.Node.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+	return !config.Node.OvirtVMsOnly || node.Labels[ovirtVMNodeLabel] != "false"
+}
+
+func applySidecarConfig(container *corev1.Container, config SidecarConfig, workersFlag string) {
+	// truncate to whole seconds to keep the arguments of the assets ("180s")
+	setContainerArg(container, "--timeout", fmt.Sprintf("%ds", int(config.Timeout.Seconds())))
+	setContainerArg(container, workersFlag, strconv.Itoa(*config.WorkerThreads))
+}
+
+func applyContainerOverrides(podSpec *corev1.PodSpec, resources map[string]corev1.ResourceRequirements, logLevels map[string]int) error {
+	for _, name := range sortedKeys(resources) {
+		container := findContainer(podSpec, name)
+		if container == nil {
+			return fmt.Errorf("container %s not found", name)
+		}
+		mergeResources(&container.Resources, resources[name])
+	}
+	for _, name := range sortedKeys(logLevels) {
+		container := findContainer(podSpec, name)
+		if container == nil {
+			return fmt.Errorf("container %s not found", name)
+		}
+		setContainerArg(container, "--v", strconv.Itoa(logLevels[name]))
+	}
+	return nil
+}
+
+// setContainerArg replaces the value of an existing flag=value argument or appends it.
+func setContainerArg(container *corev1.Container, flag, value string) {
+	arg := flag + "=" + value
+	for i := range container.Args {
+		if strings.HasPrefix(container.Args[i], flag+"=") {
+			container.Args[i] = arg
+			return
+		}
+	}
+	container.Args = append(container.Args, arg)
+}
+
+func mergeResources(dst *corev1.ResourceRequirements, src corev1.ResourceRequirements) {
+	if len(src.Requests) > 0 && dst.Requests == nil {
+		dst.Requests = corev1.ResourceList{}
+	}
+	for name, quantity := range src.Requests {
+		dst.Requests[name] = quantity
+	}
+	if len(src.Limits) > 0 && dst.Limits == nil {
+		dst.Limits = corev1.ResourceList{}
+	}
+	for name, quantity := range src.Limits {
+		dst.Limits[name] = quantity
+	}
+}
+
+func removeContainers(podSpec *corev1.PodSpec, names ...string) {
+	containers := podSpec.Containers[:0]
+	for _, container := range podSpec.Containers {
+		if !sets.NewString(names...).Has(container.Name) {
+			containers = append(containers, container)
+		}
+	}
+	podSpec.Containers = containers
+}
+
+func findContainer(podSpec *corev1.PodSpec, name string) *corev1.Container {
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == name {
+			return &podSpec.Containers[i]
+		}
+	}
+	return nil
+}
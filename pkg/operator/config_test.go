@@ -0,0 +1,581 @@
+package operator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ovirt/csi-driver-operator/assets"
+)
+
+func TestOperatorConfigGetter(t *testing.T) {
+	testCases := []struct {
+		name string
+		// config is the content of the ConfigMap, nil means no ConfigMap
+		config    *string
+		expectErr string
+		check     func(t *testing.T, config *OperatorConfig)
+	}{
+		{
+			name: "no ConfigMap",
+			check: func(t *testing.T, config *OperatorConfig) {
+				if config.Maintenance || config.Engine.StrictTLS || config.DefaultStorageClassFailover.Enabled {
+					t.Errorf("expected the default configuration, got %s", config)
+				}
+			},
+		},
+		{
+			name:   "empty configuration",
+			config: stringPtr(""),
+			check: func(t *testing.T, config *OperatorConfig) {
+				if *config.Overcommit.MaxPercent != 200 {
+					t.Errorf("expected the default configuration, got %s", config)
+				}
+			},
+		},
+		{
+			name: "valid configuration",
+			config: stringPtr(`
+controller:
+  provisioner:
+    timeout: 5m
+    workerThreads: 50
+    defaultFSType: xfs
+  logLevels:
+    csi-provisioner: 5
+node:
+  nodeSelector:
+    node-role.kubernetes.io/worker: ""
+  tolerations:
+    - key: node-role.kubernetes.io/master
+      operator: Exists
+      effect: NoSchedule
+overcommit:
+  maxPercent: 150
+  enforcement: DemoteStorageClass
+maintenance: true
+`),
+			check: func(t *testing.T, config *OperatorConfig) {
+				provisioner := config.Controller.Provisioner
+				if provisioner.Timeout.Duration != 5*time.Minute || *provisioner.WorkerThreads != 50 || provisioner.DefaultFSType != "xfs" {
+					t.Errorf("unexpected provisioner configuration %+v", provisioner)
+				}
+				if *config.Overcommit.MaxPercent != 150 || config.Overcommit.Enforcement != OvercommitEnforcementDemoteStorageClass {
+					t.Errorf("unexpected overcommit configuration %+v", config.Overcommit)
+				}
+				if !config.Maintenance {
+					t.Error("expected maintenance")
+				}
+				// the unset fields are defaulted
+				if config.Controller.Attacher.Timeout.Duration != 120*time.Second {
+					t.Errorf("expected the default attacher timeout, got %s", config.Controller.Attacher.Timeout.Duration)
+				}
+			},
+		},
+		{
+			name:      "unknown key",
+			config:    stringPtr("controller:\n  provisoner:\n    timeout: 5m\n"),
+			expectErr: "failed to parse",
+		},
+		{
+			name:      "unknown container",
+			config:    stringPtr("controller:\n  logLevels:\n    csi-snapshotter: 5\n"),
+			expectErr: `controller.logLevels: unknown container "csi-snapshotter"`,
+		},
+		{
+			name:      "node container in the controller",
+			config:    stringPtr("node:\n  resources:\n    csi-provisioner:\n      limits:\n        memory: 1Gi\n"),
+			expectErr: `node.resources: unknown container "csi-provisioner"`,
+		},
+		{
+			name:      "request above limit",
+			config:    stringPtr("node:\n  resources:\n    csi-driver:\n      requests:\n        memory: 2Gi\n      limits:\n        memory: 1Gi\n"),
+			expectErr: "node.resources[csi-driver]: memory request must not exceed its limit",
+		},
+		{
+			name:      "log level out of range",
+			config:    stringPtr("node:\n  logLevels:\n    csi-driver: 11\n"),
+			expectErr: "node.logLevels[csi-driver] must be between 0 and 10",
+		},
+		{
+			name:      "defaultFSType of the attacher",
+			config:    stringPtr("controller:\n  attacher:\n    defaultFSType: xfs\n"),
+			expectErr: "controller.attacher.defaultFSType is not supported",
+		},
+		{
+			name:      "unsupported defaultFSType",
+			config:    stringPtr("controller:\n  provisioner:\n    defaultFSType: btrfs\n"),
+			expectErr: "controller.provisioner.defaultFSType must be one of ext3, ext4, xfs",
+		},
+		{
+			name:      "timeout below a second",
+			config:    stringPtr("controller:\n  resizer:\n    timeout: 500ms\n"),
+			expectErr: "controller.resizer.timeout must be at least 1s",
+		},
+		{
+			name:      "no worker threads",
+			config:    stringPtr("controller:\n  attacher:\n    workerThreads: 0\n"),
+			expectErr: "controller.attacher.workerThreads must be at least 1",
+		},
+		{
+			name:      "invalid node selector",
+			config:    stringPtr("node:\n  nodeSelector:\n    \"bad key!\": x\n"),
+			expectErr: `node.nodeSelector key "bad key!"`,
+		},
+		{
+			name:      "toleration with value and Exists",
+			config:    stringPtr("node:\n  tolerations:\n    - key: a\n      operator: Exists\n      value: b\n"),
+			expectErr: "node.tolerations[0]: value must be empty with operator Exists",
+		},
+		{
+			name:      "unknown overcommit enforcement",
+			config:    stringPtr("overcommit:\n  enforcement: Reject\n"),
+			expectErr: "overcommit.enforcement must be one of None, DemoteStorageClass",
+		},
+		{
+			name:      "negative overcommit",
+			config:    stringPtr("overcommit:\n  maxPercent: -1\n"),
+			expectErr: "overcommit.maxPercent must not be negative",
+		},
+		{
+			name:      "short volume attachment timeout",
+			config:    stringPtr("alerts:\n  volumeAttachmentTimeout: 30s\n"),
+			expectErr: "alerts.volumeAttachmentTimeout must be at least 1m",
+		},
+		{
+			name:      "recovery below the failover threshold",
+			config:    stringPtr("defaultStorageClassFailover:\n  minFreePercent: 20\n  recoveryFreePercent: 10\n"),
+			expectErr: "defaultStorageClassFailover.recoveryFreePercent must not be below minFreePercent",
+		},
+		{
+			name:      "negative certificate expiry warning",
+			config:    stringPtr("engine:\n  certificateExpiryWarning: -1h\n"),
+			expectErr: "engine.certificateExpiryWarning must not be negative",
+		},
+		{
+			name:      "all errors are reported",
+			config:    stringPtr("diskStatus:\n  batchSize: 0\nalerts:\n  operationErrorPercent: 101\n"),
+			expectErr: "diskStatus.batchSize must be positive, alerts.operationErrorPercent must be between 0 and 100",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var configMaps []*corev1.ConfigMap
+			if tc.config != nil {
+				configMaps = append(configMaps, operatorConfigMapWith(*tc.config))
+			}
+			config, err := newOperatorConfigGetter(newConfigMapLister(t, configMaps...))()
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected an error containing %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tc.check(t, config)
+		})
+	}
+}
+
+func TestOperatorConfigSetDefaults(t *testing.T) {
+	config := &OperatorConfig{}
+	config.setDefaults()
+	defaulted := config.String()
+	config.setDefaults()
+	if got := config.String(); got != defaulted {
+		t.Fatalf("defaulting twice changed the configuration from %s to %s", defaulted, got)
+	}
+	expectations := []struct {
+		name   string
+		got    interface{}
+		expect interface{}
+	}{
+		{"provisioner timeout", config.Controller.Provisioner.Timeout.Duration, 180 * time.Second},
+		{"provisioner workerThreads", *config.Controller.Provisioner.WorkerThreads, 100},
+		{"provisioner defaultFSType", config.Controller.Provisioner.DefaultFSType, "ext4"},
+		{"attacher timeout", config.Controller.Attacher.Timeout.Duration, 120 * time.Second},
+		{"attacher workerThreads", *config.Controller.Attacher.WorkerThreads, 10},
+		{"resizer timeout", config.Controller.Resizer.Timeout.Duration, 120 * time.Second},
+		{"resizer workerThreads", *config.Controller.Resizer.WorkerThreads, 10},
+		{"overcommit maxPercent", *config.Overcommit.MaxPercent, 200},
+		{"overcommit enforcement", config.Overcommit.Enforcement, OvercommitEnforcementNone},
+		{"diskStatus threshold", config.DiskStatus.Threshold.Duration, 15 * time.Minute},
+		{"diskStatus batchSize", *config.DiskStatus.BatchSize, 20},
+		{"alerts operationErrorPercent", *config.Alerts.OperationErrorPercent, 10},
+		{"alerts storageDomainFreePercent", *config.Alerts.StorageDomainFreePercent, 10},
+		{"alerts volumeAttachmentTimeout", config.Alerts.VolumeAttachmentTimeout.Duration, 10 * time.Minute},
+		{"engine certificateExpiryWarning", config.Engine.CertificateExpiryWarning.Duration, 720 * time.Hour},
+		{"failover minFreePercent", *config.DefaultStorageClassFailover.MinFreePercent, 5},
+		{"failover recoveryFreePercent", *config.DefaultStorageClassFailover.RecoveryFreePercent, 15},
+		{"failover failbackDelay", config.DefaultStorageClassFailover.FailbackDelay.Duration, 15 * time.Minute},
+	}
+	for _, e := range expectations {
+		if e.got != e.expect {
+			t.Errorf("expected %s %v, got %v", e.name, e.expect, e.got)
+		}
+	}
+
+	// set values are kept, the recovery threshold follows a higher failover threshold
+	minFreePercent, workerThreads := 20, 3
+	config = &OperatorConfig{
+		Controller:                  ControllerConfig{Attacher: SidecarConfig{WorkerThreads: &workerThreads}},
+		DefaultStorageClassFailover: StorageClassFailoverConfig{MinFreePercent: &minFreePercent},
+	}
+	config.setDefaults()
+	if *config.Controller.Attacher.WorkerThreads != 3 {
+		t.Errorf("expected the attacher workerThreads to be kept, got %d", *config.Controller.Attacher.WorkerThreads)
+	}
+	if *config.DefaultStorageClassFailover.RecoveryFreePercent != 20 {
+		t.Errorf("expected recoveryFreePercent to follow minFreePercent, got %d", *config.DefaultStorageClassFailover.RecoveryFreePercent)
+	}
+}
+
+func TestOperatorConfigDeploymentHook(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config OperatorConfig
+		check  func(t *testing.T, podSpec *corev1.PodSpec)
+	}{
+		{
+			name: "defaults keep the asset arguments",
+			check: func(t *testing.T, podSpec *corev1.PodSpec) {
+				expectArgs(t, podSpec, provisionerContainer, "--timeout=180s", "--worker-threads=100", "--default-fstype=ext4")
+				expectArgs(t, podSpec, attacherContainer, "--timeout=120s", "--worker-threads=10")
+				expectArgs(t, podSpec, resizerContainer, "--timeout=120s", "--workers=10")
+			},
+		},
+		{
+			name: "sidecar arguments, resources and log levels",
+			config: OperatorConfig{
+				Controller: ControllerConfig{
+					Provisioner: SidecarConfig{Timeout: &metav1.Duration{Duration: 5 * time.Minute}, DefaultFSType: "xfs"},
+					Resizer:     SidecarConfig{WorkerThreads: intPtr(4)},
+					Resources: map[string]corev1.ResourceRequirements{
+						attacherContainer: {Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}},
+					},
+					LogLevels: map[string]int{provisionerContainer: 6},
+				},
+			},
+			check: func(t *testing.T, podSpec *corev1.PodSpec) {
+				expectArgs(t, podSpec, provisionerContainer, "--timeout=300s", "--default-fstype=xfs", "--v=6")
+				expectArgs(t, podSpec, resizerContainer, "--workers=4")
+				limit := findContainer(podSpec, attacherContainer).Resources.Limits[corev1.ResourceMemory]
+				if limit.String() != "1Gi" {
+					t.Errorf("expected the attacher memory limit 1Gi, got %s", limit.String())
+				}
+				if requests := findContainer(podSpec, attacherContainer).Resources.Requests; len(requests) == 0 {
+					t.Error("expected the requests of the asset to be kept")
+				}
+			},
+		},
+		{
+			name:   "maintenance removes the provisioner",
+			config: OperatorConfig{Maintenance: true},
+			check: func(t *testing.T, podSpec *corev1.PodSpec) {
+				for _, name := range []string{provisionerContainer, provisionerRBACProxyContainer} {
+					if findContainer(podSpec, name) != nil {
+						t.Errorf("expected container %s to be removed", name)
+					}
+				}
+				for _, name := range []string{attacherContainer, resizerContainer} {
+					if findContainer(podSpec, name) == nil {
+						t.Errorf("expected container %s to be kept", name)
+					}
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := tc.config
+			config.setDefaults()
+			deployment := readDeploymentAsset(t)
+			hook := withOperatorConfigDeploymentHook(func() (*OperatorConfig, error) { return &config, nil })
+			if err := hook(&opv1.OperatorSpec{}, deployment); err != nil {
+				t.Fatalf("hook failed: %v", err)
+			}
+			tc.check(t, &deployment.Spec.Template.Spec)
+		})
+	}
+}
+
+func TestOperatorConfigDaemonSetHook(t *testing.T) {
+	testCases := []struct {
+		name              string
+		node              NodeConfig
+		expectSelector    map[string]string
+		expectAffinity    bool
+		expectTolerations []corev1.Toleration
+	}{
+		{
+			name:           "node selector",
+			node:           NodeConfig{NodeSelector: map[string]string{"node-role.kubernetes.io/worker": ""}},
+			expectSelector: map[string]string{"node-role.kubernetes.io/worker": ""},
+		},
+		{
+			name:           "ovirtVMsOnly",
+			node:           NodeConfig{OvirtVMsOnly: true},
+			expectAffinity: true,
+		},
+		{
+			name: "tolerations with the startup taint",
+			node: NodeConfig{
+				StartupTaint: true,
+				Tolerations:  []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "storage", Effect: corev1.TaintEffectNoSchedule}},
+			},
+			expectTolerations: []corev1.Toleration{
+				{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "storage", Effect: corev1.TaintEffectNoSchedule},
+				{Key: startupTaint.Key, Operator: corev1.TolerationOpExists, Effect: startupTaint.Effect},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := OperatorConfig{Node: tc.node}
+			config.setDefaults()
+			daemonSet := readDaemonSetAsset(t)
+			assetTolerations := daemonSet.Spec.Template.Spec.Tolerations
+			hook := withOperatorConfigDaemonSetHook(func() (*OperatorConfig, error) { return &config, nil })
+			if err := hook(&opv1.OperatorSpec{}, daemonSet); err != nil {
+				t.Fatalf("hook failed: %v", err)
+			}
+			podSpec := daemonSet.Spec.Template.Spec
+			for k, v := range tc.expectSelector {
+				if got, ok := podSpec.NodeSelector[k]; !ok || got != v {
+					t.Errorf("expected node selector %s=%s, got %v", k, v, podSpec.NodeSelector)
+				}
+			}
+			if _, ok := podSpec.NodeSelector[ovirtVMNodeLabel]; ok {
+				t.Errorf("expected no %s node selector, got %v", ovirtVMNodeLabel, podSpec.NodeSelector)
+			}
+			hasAffinity := podSpec.Affinity != nil && podSpec.Affinity.NodeAffinity != nil &&
+				reflect.DeepEqual(podSpec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms,
+					[]corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{ovirtVMNodeRequirement}}})
+			if hasAffinity != tc.expectAffinity {
+				t.Errorf("expected the %s affinity %v, got %+v", ovirtVMNodeLabel, tc.expectAffinity, podSpec.Affinity)
+			}
+			expectTolerations := tc.expectTolerations
+			if expectTolerations == nil {
+				expectTolerations = assetTolerations
+			}
+			if len(podSpec.Tolerations) != len(expectTolerations) {
+				t.Fatalf("expected tolerations %v, got %v", expectTolerations, podSpec.Tolerations)
+			}
+			for i := range expectTolerations {
+				if podSpec.Tolerations[i] != expectTolerations[i] {
+					t.Errorf("expected toleration %v, got %v", expectTolerations[i], podSpec.Tolerations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTLSProfileDaemonSetHook(t *testing.T) {
+	testCases := []struct {
+		name              string
+		profile           *configv1.TLSSecurityProfile
+		expectSuitesFlags string
+	}{
+		{
+			name: "no APIServer profile",
+			// the list library-go fills into the controller Deployment by default
+			expectSuitesFlags: "--tls-cipher-suites=TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256," +
+				"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384," +
+				"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+		},
+		{
+			name: "custom profile",
+			profile: &configv1.TLSSecurityProfile{
+				Type: configv1.TLSProfileCustomType,
+				Custom: &configv1.CustomTLSProfile{TLSProfileSpec: configv1.TLSProfileSpec{
+					Ciphers:       []string{"ECDHE-RSA-AES256-GCM-SHA384"},
+					MinTLSVersion: configv1.VersionTLS12,
+				}},
+			},
+			expectSuitesFlags: "--tls-cipher-suites=TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			if tc.profile != nil {
+				apiServer := &configv1.APIServer{
+					ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName},
+					Spec:       configv1.APIServerSpec{TLSSecurityProfile: tc.profile},
+				}
+				if err := indexer.Add(apiServer); err != nil {
+					t.Fatal(err)
+				}
+			}
+			daemonSet := readDaemonSetAsset(t)
+			hook := withTLSProfileDaemonSetHook(configlisters.NewAPIServerLister(indexer))
+			if err := hook(&opv1.OperatorSpec{}, daemonSet); err != nil {
+				t.Fatalf("hook failed: %v", err)
+			}
+			expectArgs(t, &daemonSet.Spec.Template.Spec, "node-kube-rbac-proxy", tc.expectSuitesFlags)
+			for _, container := range daemonSet.Spec.Template.Spec.Containers {
+				for _, arg := range container.Args {
+					if strings.Contains(arg, "${TLS_CIPHER_SUITES}") {
+						t.Errorf("expected the cipher suites filled in container %s, got %s", container.Name, arg)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNodePluginRunsOn(t *testing.T) {
+	worker := map[string]string{"node-role.kubernetes.io/worker": ""}
+	testCases := []struct {
+		name   string
+		node   NodeConfig
+		labels map[string]string
+		expect bool
+	}{
+		{
+			name:   "no placement",
+			expect: true,
+		},
+		{
+			name:   "node selector matches",
+			node:   NodeConfig{NodeSelector: worker},
+			labels: map[string]string{"node-role.kubernetes.io/worker": "", ovirtVMNodeLabel: "false"},
+			expect: true,
+		},
+		{
+			name: "node selector does not match",
+			node: NodeConfig{NodeSelector: worker},
+		},
+		{
+			name:   "ovirtVMsOnly, unlabeled node",
+			node:   NodeConfig{OvirtVMsOnly: true},
+			expect: true,
+		},
+		{
+			name:   "ovirtVMsOnly, oVirt VM",
+			node:   NodeConfig{OvirtVMsOnly: true},
+			labels: map[string]string{ovirtVMNodeLabel: "true"},
+			expect: true,
+		},
+		{
+			name:   "ovirtVMsOnly, not an oVirt VM",
+			node:   NodeConfig{OvirtVMsOnly: true},
+			labels: map[string]string{ovirtVMNodeLabel: "false"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &OperatorConfig{Node: tc.node}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Labels: tc.labels}}
+			if got := nodePluginRunsOn(config, node); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestAssetFuncHooks(t *testing.T) {
+	config := OperatorConfig{
+		Alerts: AlertsConfig{
+			OperationErrorPercent:    intPtr(25),
+			StorageDomainFreePercent: intPtr(7),
+			VolumeAttachmentTimeout:  &metav1.Duration{Duration: 20 * time.Minute},
+		},
+		Overcommit: OvercommitConfig{MaxPercent: intPtr(150)},
+	}
+	config.setDefaults()
+	getConfig := func() (*OperatorConfig, error) { return &config, nil }
+
+	rules, err := withAlertThresholds(assets.ReadFile, getConfig)(prometheusRuleAsset)
+	if err != nil {
+		t.Fatalf("failed to render %s: %v", prometheusRuleAsset, err)
+	}
+	if strings.Contains(string(rules), "${") {
+		t.Errorf("expected all thresholds to be filled in, got %s", rules)
+	}
+	for _, threshold := range []string{"25", "7", "1200", "150"} {
+		if !strings.Contains(string(rules), threshold) {
+			t.Errorf("expected the threshold %s in the rules", threshold)
+		}
+	}
+
+	// A disabled overcommit check never fires the alert
+	config.Overcommit.MaxPercent = intPtr(0)
+	rules, err = withAlertThresholds(assets.ReadFile, getConfig)(prometheusRuleAsset)
+	if err != nil {
+		t.Fatalf("failed to render %s: %v", prometheusRuleAsset, err)
+	}
+	if !strings.Contains(string(rules), "ovirt_csi_storage_domain_size_bytes > Inf") {
+		t.Errorf("expected the overcommit alert to be disabled, got %s", rules)
+	}
+
+	for _, maintenance := range []bool{false, true} {
+		config.Maintenance = maintenance
+		serviceMonitor, err := withMaintenanceServiceMonitor(assets.ReadFile, getConfig)(serviceMonitorAsset)
+		if err != nil {
+			t.Fatalf("failed to render %s: %v", serviceMonitorAsset, err)
+		}
+		if scraped := strings.Contains(string(serviceMonitor), "port: "+provisionerMetricsPort); scraped == maintenance {
+			t.Errorf("expected the provisioner metrics to be scraped %t in maintenance %t", !maintenance, maintenance)
+		}
+		if !strings.Contains(string(serviceMonitor), "port: attacher-m") {
+			t.Errorf("expected the attacher metrics to be scraped in maintenance %t", maintenance)
+		}
+	}
+}
+
+func readDeploymentAsset(t *testing.T) *appsv1.Deployment {
+	t.Helper()
+	manifest, err := assets.ReadFile("controller.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resourceread.ReadDeploymentV1OrDie(manifest)
+}
+
+func readDaemonSetAsset(t *testing.T) *appsv1.DaemonSet {
+	t.Helper()
+	manifest, err := assets.ReadFile("node.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resourceread.ReadDaemonSetV1OrDie(manifest)
+}
+
+func expectArgs(t *testing.T, podSpec *corev1.PodSpec, name string, args ...string) {
+	t.Helper()
+	container := findContainer(podSpec, name)
+	if container == nil {
+		t.Fatalf("container %s not found", name)
+	}
+	for _, arg := range args {
+		found := false
+		for _, a := range container.Args {
+			found = found || a == arg
+		}
+		if !found {
+			t.Errorf("expected argument %s of container %s, got %v", arg, name, container.Args)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func intPtr(i int) *int {
+	return &i
+}
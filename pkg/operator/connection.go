@@ -0,0 +1,121 @@
+package operator
+
+import (
+	"fmt"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/openshift/library-go/pkg/crypto"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+const (
+	// name of the cluster-wide Proxy and APIServer configs
+	clusterConfigName = "cluster"
+	caBundleKey       = "ca-bundle.crt"
+	// engineCABundleKey is the key of the engine CA in the credentials Secret
+	engineCABundleKey = "ovirt_ca_bundle"
+)
+
+// newConnectionSettingsGetter returns a function which builds the engine connection
+// settings from the cluster-wide Proxy config, the engine CA of the credentials Secret,
+// the trusted CA bundle injected into the operator namespace and the APIServer TLS
+// security profile.
+func newConnectionSettingsGetter(
+	proxyLister configlisters.ProxyLister,
+	apiServerLister configlisters.APIServerLister,
+	configMapLister corelisters.ConfigMapLister,
+	secretLister corelisters.SecretLister,
+	namespace string,
+	getStrictTLS func() (bool, error),
+) func() (*ovirt.ConnectionSettings, error) {
+	return func() (*ovirt.ConnectionSettings, error) {
+		settings := &ovirt.ConnectionSettings{}
+
+		proxy, err := proxyLister.Get(clusterConfigName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get Proxy %s: %w", clusterConfigName, err)
+		}
+		if err == nil {
+			settings.HTTPProxy = proxy.Status.HTTPProxy
+			settings.HTTPSProxy = proxy.Status.HTTPSProxy
+			settings.NoProxy = proxy.Status.NoProxy
+		}
+
+		// The Secret is read on every call, ovirt_cafile is only written when the pod starts
+		// and goes stale when the credentials are rotated
+		secret, err := secretLister.Secrets(namespace).Get(secretName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get Secret %s/%s: %w", namespace, secretName, err)
+		}
+		if err == nil && len(secret.Data[engineCABundleKey]) > 0 {
+			settings.EngineCABundle = secret.Data[engineCABundleKey]
+		}
+
+		cm, err := configMapLister.ConfigMaps(namespace).Get(trustedCAConfigMap)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get ConfigMap %s/%s: %w", namespace, trustedCAConfigMap, err)
+		}
+		if err == nil && cm.Data[caBundleKey] != "" {
+			settings.TrustedCABundle = []byte(cm.Data[caBundleKey])
+		}
+
+		apiServer, err := apiServerLister.Get(clusterConfigName)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get APIServer %s: %w", clusterConfigName, err)
+		}
+		var profile *configv1.TLSSecurityProfile
+		if err == nil {
+			profile = apiServer.Spec.TLSSecurityProfile
+		}
+		if settings.MinTLSVersion, settings.CipherSuites, err = tlsProfileSettings(profile); err != nil {
+			return nil, err
+		}
+
+		if settings.StrictTLS, err = getStrictTLS(); err != nil {
+			return nil, err
+		}
+
+		return settings, nil
+	}
+}
+
+// tlsProfileSettings returns the minimum TLS version and the cipher suites of the profile,
+// falling back to the Intermediate profile like the API server does.
+func tlsProfileSettings(profile *configv1.TLSSecurityProfile) (uint16, []uint16, error) {
+	profileType := configv1.TLSProfileIntermediateType
+	if profile != nil && profile.Type != "" {
+		profileType = profile.Type
+	}
+
+	var spec *configv1.TLSProfileSpec
+	if profileType == configv1.TLSProfileCustomType {
+		if profile.Custom == nil {
+			return 0, nil, fmt.Errorf("custom TLS security profile without parameters")
+		}
+		spec = &profile.Custom.TLSProfileSpec
+	} else {
+		var ok bool
+		if spec, ok = configv1.TLSProfiles[profileType]; !ok {
+			return 0, nil, fmt.Errorf("unknown TLS security profile type %s", profileType)
+		}
+	}
+
+	minVersion, err := crypto.TLSVersion(string(spec.MinTLSVersion))
+	if err != nil {
+		return 0, nil, err
+	}
+	// Cipher suites unknown to Go are left out, TLS 1.3 cipher suites are not configurable anyway
+	var cipherSuites []uint16
+	for _, name := range crypto.OpenSSLToIANACipherSuites(spec.Ciphers) {
+		cipherSuite, err := crypto.CipherSuite(name)
+		if err != nil {
+			continue
+		}
+		cipherSuites = append(cipherSuites, cipherSuite)
+	}
+	return minVersion, cipherSuites, nil
+}
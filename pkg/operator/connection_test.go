@@ -0,0 +1,219 @@
+package operator
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+
+	configv1 "github.com/openshift/api/config/v1"
+	configlisters "github.com/openshift/client-go/config/listers/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newConfigMapLister returns a lister of the ConfigMaps.
+func newConfigMapLister(t *testing.T, configMaps ...*corev1.ConfigMap) corelisters.ConfigMapLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, cm := range configMaps {
+		if err := indexer.Add(cm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return corelisters.NewConfigMapLister(indexer)
+}
+
+// operatorConfigMapWith returns the operator ConfigMap with the configuration.
+func operatorConfigMapWith(config string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: operatorConfigMap, Namespace: defaultNamespace},
+		Data:       map[string]string{operatorConfigKey: config},
+	}
+}
+
+func TestTLSProfileSettings(t *testing.T) {
+	intermediateCiphers := []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+	testCases := []struct {
+		name          string
+		profile       *configv1.TLSSecurityProfile
+		expectVersion uint16
+		expectCiphers []uint16
+		expectErr     bool
+	}{
+		{
+			name:          "no profile falls back to Intermediate",
+			profile:       nil,
+			expectVersion: tls.VersionTLS12,
+			expectCiphers: intermediateCiphers,
+		},
+		{
+			name:          "profile without type falls back to Intermediate",
+			profile:       &configv1.TLSSecurityProfile{},
+			expectVersion: tls.VersionTLS12,
+			expectCiphers: intermediateCiphers,
+		},
+		{
+			name:          "Intermediate",
+			profile:       &configv1.TLSSecurityProfile{Type: configv1.TLSProfileIntermediateType},
+			expectVersion: tls.VersionTLS12,
+			expectCiphers: intermediateCiphers,
+		},
+		{
+			name:          "Modern leaves the TLS 1.3 cipher suites to Go",
+			profile:       &configv1.TLSSecurityProfile{Type: configv1.TLSProfileModernType},
+			expectVersion: tls.VersionTLS13,
+			expectCiphers: nil,
+		},
+		{
+			name: "Custom skips cipher suites unknown to Go",
+			profile: &configv1.TLSSecurityProfile{
+				Type: configv1.TLSProfileCustomType,
+				Custom: &configv1.CustomTLSProfile{TLSProfileSpec: configv1.TLSProfileSpec{
+					Ciphers:       []string{"ECDHE-RSA-AES128-GCM-SHA256", "UNKNOWN-CIPHER"},
+					MinTLSVersion: configv1.VersionTLS11,
+				}},
+			},
+			expectVersion: tls.VersionTLS11,
+			expectCiphers: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			name:      "Custom without parameters",
+			profile:   &configv1.TLSSecurityProfile{Type: configv1.TLSProfileCustomType},
+			expectErr: true,
+		},
+		{
+			name: "Custom with an invalid version",
+			profile: &configv1.TLSSecurityProfile{
+				Type:   configv1.TLSProfileCustomType,
+				Custom: &configv1.CustomTLSProfile{TLSProfileSpec: configv1.TLSProfileSpec{MinTLSVersion: "VersionTLS99"}},
+			},
+			expectErr: true,
+		},
+		{
+			name:      "unknown type",
+			profile:   &configv1.TLSSecurityProfile{Type: "Paranoid"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			version, ciphers, err := tlsProfileSettings(tc.profile)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			if version != tc.expectVersion {
+				t.Errorf("expected minimum version %s, got %s", tls.VersionName(tc.expectVersion), tls.VersionName(version))
+			}
+			if !reflect.DeepEqual(ciphers, tc.expectCiphers) {
+				t.Errorf("expected cipher suites %x, got %x", tc.expectCiphers, ciphers)
+			}
+		})
+	}
+}
+
+func TestStrictTLSGetter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		configMap *corev1.ConfigMap
+		expect    bool
+		expectErr bool
+	}{
+		{
+			name:   "no ConfigMap",
+			expect: false,
+		},
+		{
+			name:      "strictTLS",
+			configMap: operatorConfigMapWith("engine:\n  strictTLS: true\n"),
+			expect:    true,
+		},
+		{
+			name:      "strictTLS with an invalid key elsewhere",
+			configMap: operatorConfigMapWith("engine:\n  strictTLS: true\ncontroller:\n  unknownKey: 1\n"),
+			expect:    true,
+		},
+		{
+			name:      "strictTLS with an invalid value elsewhere",
+			configMap: operatorConfigMapWith("engine:\n  strictTLS: true\novercommit:\n  maxPercent: -5\n"),
+			expect:    true,
+		},
+		{
+			name:      "no engine section",
+			configMap: operatorConfigMapWith("maintenance: true\n"),
+			expect:    false,
+		},
+		{
+			name:      "unparsable strictTLS",
+			configMap: operatorConfigMapWith("engine:\n  strictTLS: sometimes\n"),
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var configMaps []*corev1.ConfigMap
+			if tc.configMap != nil {
+				configMaps = append(configMaps, tc.configMap)
+			}
+			strictTLS, err := newStrictTLSGetter(newConfigMapLister(t, configMaps...))()
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error %t, got %v", tc.expectErr, err)
+			}
+			if strictTLS != tc.expect {
+				t.Errorf("expected strictTLS %t, got %t", tc.expect, strictTLS)
+			}
+		})
+	}
+}
+
+func TestConnectionSettingsGetterEngineCA(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: defaultNamespace},
+		Data:       map[string][]byte{engineCABundleKey: []byte("first CA")},
+	}
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	getSettings := newConnectionSettingsGetter(
+		configlisters.NewProxyLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		configlisters.NewAPIServerLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		newConfigMapLister(t),
+		corelisters.NewSecretLister(secrets),
+		defaultNamespace,
+		func() (bool, error) { return false, nil },
+	)
+	expectEngineCA := func(expected string) {
+		t.Helper()
+		settings, err := getSettings()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(settings.EngineCABundle) != expected {
+			t.Errorf("expected engine CA %q, got %q", expected, settings.EngineCABundle)
+		}
+	}
+
+	// Without the Secret the client falls back to ovirt_cafile
+	expectEngineCA("")
+	if err := secrets.Add(secret); err != nil {
+		t.Fatal(err)
+	}
+	expectEngineCA("first CA")
+
+	// A rotated CA is used without restarting the operator
+	rotated := secret.DeepCopy()
+	rotated.Data[engineCABundleKey] = []byte("second CA")
+	if err := secrets.Update(rotated); err != nil {
+		t.Fatal(err)
+	}
+	expectEngineCA("second CA")
+}
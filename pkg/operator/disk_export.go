@@ -0,0 +1,267 @@
+package operator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+const (
+	// imageReadyPath is the readiness probe of receive-image
+	imageReadyPath = "ready"
+
+	// checksumSuffix is appended to the image file name for its checksum file
+	checksumSuffix = ".sha256"
+)
+
+// exportFormats are the image formats of an export by their name.
+var exportFormats = map[string]ovirtclient.ImageFormat{
+	"raw":   ovirtclient.ImageFormatRaw,
+	"qcow2": ovirtclient.ImageFormatCow,
+}
+
+// transferProgress counts the bytes of a running download.
+type transferProgress struct {
+	done  atomic.Int64
+	total atomic.Int64
+}
+
+func (p *transferProgress) Write(b []byte) (int, error) {
+	p.done.Add(int64(len(b)))
+	return len(b), nil
+}
+
+// percent returns the progress in percent, empty while the size is unknown.
+func (p *transferProgress) percent() string {
+	total := p.total.Load()
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d%%", p.done.Load()*100/total)
+}
+
+// checkDiskExportable refuses a disk attached read-write to a VM which is not down, its image
+// would not be consistent. The attachments are read through the SDK, the disk is refused when
+// they cannot be read.
+func checkDiskExportable(ovirtClient ovirtclient.Client, diskID ovirtclient.DiskID) error {
+	vms, err := diskAttachedVMs(ovirtClient, diskID)
+	if err != nil {
+		return err
+	}
+	legacyClient, ok := ovirtClient.(ovirtclient.ClientWithLegacySupport)
+	if !ok {
+		return errDiskAttachmentsUnknown
+	}
+	for _, vmID := range vms {
+		vm, err := ovirtClient.GetVM(ovirtclient.VMID(vmID))
+		if err != nil {
+			return fmt.Errorf("failed to get VM %s: %w", vmID, err)
+		}
+		if vm.Status() == ovirtclient.VMStatusDown {
+			continue
+		}
+		response, err := legacyClient.GetSDKClient().SystemService().VmsService().VmService(vmID).
+			DiskAttachmentsService().AttachmentService(string(diskID)).Get().Send()
+		if err != nil {
+			return fmt.Errorf("failed to get the attachment of disk %s to VM %s: %w", diskID, vm.Name(), err)
+		}
+		attachment, _ := response.Attachment()
+		if readOnly, ok := attachment.ReadOnly(); !ok || !readOnly {
+			return fmt.Errorf("disk %s is attached read-write to VM %s, which is %s", diskID, vm.Name(), vm.Status())
+		}
+	}
+	return nil
+}
+
+// downloadDisk writes the image of the disk in the format to w and returns its SHA-256
+// checksum. The disk is locked in the engine until the download is closed.
+func downloadDisk(ctx context.Context, ovirtClient ovirtclient.Client, diskID ovirtclient.DiskID, format ovirtclient.ImageFormat, w io.Writer, progress *transferProgress) (string, error) {
+	download, err := ovirtClient.StartDownloadDisk(diskID, format, ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to start the download of disk %s: %w", diskID, err)
+	}
+	defer download.Close()
+	select {
+	case <-download.Initialized():
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if err := download.Err(); err != nil {
+		return "", fmt.Errorf("failed to start the download of disk %s: %w", diskID, err)
+	}
+	progress.total.Store(int64(download.Size()))
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash, progress), download); err != nil {
+		return "", fmt.Errorf("failed to download disk %s: %w", diskID, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeImageFile writes the image to a temporary file next to the file, reads it back to
+// compute its SHA-256 checksum, and renames it to the file once complete. The checksum is
+// written to the checksum file in the format of sha256sum.
+func writeImageFile(file string, r io.Reader) (string, error) {
+	part := file + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(part)
+		return "", fmt.Errorf("failed to write %s: %w", part, err)
+	}
+
+	checksum, err := fileChecksum(part)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(part, file); err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(file))
+	if err := os.WriteFile(file+checksumSuffix, []byte(line), 0644); err != nil {
+		return "", err
+	}
+	return checksum, nil
+}
+
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ExportDiskOptions are the options of the export-disk command.
+type ExportDiskOptions struct {
+	// DiskID or PersistentVolumeName select the disk, the PV is read with the Kubeconfig.
+	DiskID               string
+	PersistentVolumeName string
+	Kubeconfig           string
+	// Format is raw or qcow2.
+	Format string
+	Output string
+}
+
+// ExportDisk downloads the disk to a local image file, verifies it against the checksum of
+// the download and writes the checksum next to it. The oVirt connection is configured as for
+// the driver, e.g. with OVIRT_CONFIG.
+func ExportDisk(ctx context.Context, opts ExportDiskOptions) error {
+	format, ok := exportFormats[opts.Format]
+	if !ok {
+		return fmt.Errorf("unsupported format %q, use raw or qcow2", opts.Format)
+	}
+	diskID := opts.DiskID
+	if (diskID == "") == (opts.PersistentVolumeName == "") {
+		return fmt.Errorf("exactly one of the disk ID and the PV must be set")
+	}
+	if opts.PersistentVolumeName != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", opts.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		kubeClient, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, opts.PersistentVolumeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != instanceName {
+			return fmt.Errorf("PV %s is not a volume of %s", pv.Name, instanceName)
+		}
+		diskID = pv.Spec.CSI.VolumeHandle
+	}
+
+	ovirtClient, err := ovirt.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	if err := checkDiskExportable(ovirtClient, ovirtclient.DiskID(diskID)); err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	var downloadChecksum string
+	go func() {
+		var err error
+		downloadChecksum, err = downloadDisk(ctx, ovirtClient, ovirtclient.DiskID(diskID), format, writer, &transferProgress{})
+		writer.CloseWithError(err)
+	}()
+	checksum, err := writeImageFile(opts.Output, reader)
+	if err != nil {
+		return err
+	}
+	if checksum != downloadChecksum {
+		return fmt.Errorf("checksum of %s is %s, the download has %s", opts.Output, checksum, downloadChecksum)
+	}
+	klog.Infof("Exported disk %s to %s, SHA-256 %s", diskID, opts.Output, checksum)
+	return nil
+}
+
+// ReceiveImage writes the image PUT to it to the file and responds with its SHA-256 checksum,
+// until the context is done. It runs in the target pod of an OvirtDiskExport.
+func ReceiveImage(ctx context.Context, file string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+imageReadyPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/"+imageSourcePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "only PUT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		checksum, err := writeImageFile(file, r.Body)
+		if err != nil {
+			klog.Errorf("Failed to receive the image: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		klog.Infof("Received %s, SHA-256 %s", file, checksum)
+		fmt.Fprintln(w, checksum)
+	})
+	server := &http.Server{Addr: fmt.Sprintf(":%d", imageSourcePort), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// parseChecksum returns the checksum in the response of receive-image.
+func parseChecksum(raw []byte) string {
+	return strings.TrimSpace(string(raw))
+}
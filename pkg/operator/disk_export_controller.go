@@ -0,0 +1,416 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+const (
+	// diskExportLabel on the target pods names their OvirtDiskExport
+	diskExportLabel = "csi.ovirt.org/disk-export"
+
+	// diskExportRetryInterval is how often pending exports are checked again, e.g. until their
+	// disk is no longer attached read-write
+	diskExportRetryInterval = time.Minute
+)
+
+// diskExport is a running export. It runs in the background and outlives syncs.
+type diskExport struct {
+	cancel   context.CancelFunc
+	diskID   ovirtclient.DiskID
+	progress transferProgress
+	done     chan struct{}
+	// checksum and err are set once done
+	checksum string
+	err      error
+	// recorded is set once the result is in the status, the export is not synced again even
+	// if the informer has not seen the status yet
+	recorded bool
+}
+
+// OvirtDiskExportController reconciles the OvirtDiskExport resources. It downloads the disk of
+// the PV bound to the source PVC and streams it through the API server proxy to a pod running
+// receive-image with the target PVC mounted. The pod writes the image file, reads it back and
+// returns its checksum, which must match the checksum of the download. Disks attached
+// read-write to a running VM are not exported, the export stays Pending until they are
+// detached, the VM is down or the attachment is read-only. Finished exports are not run again.
+type OvirtDiskExportController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	dynamicClient      dynamic.Interface
+	exportLister       cache.GenericLister
+	pvcLister          corelisters.PersistentVolumeClaimLister
+	pvLister           corelisters.PersistentVolumeLister
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	// writeImage streams the image to the target pod and returns the response of receive-image
+	writeImage    func(ctx context.Context, namespace, pod string, image io.Reader) ([]byte, error)
+	operatorImage string
+	eventRecorder events.Recorder
+
+	lock    sync.Mutex
+	exports map[types.UID]*diskExport
+}
+
+func NewOvirtDiskExportController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	exportInformer informers.GenericInformer,
+	pvcInformer corev1informers.PersistentVolumeClaimInformer,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtDiskExportController{
+		name:               "OvirtDiskExportController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		dynamicClient:      dynamicClient,
+		exportLister:       exportInformer.Lister(),
+		pvcLister:          pvcInformer.Lister(),
+		pvLister:           pvInformer.Lister(),
+		ovirtClientFactory: ovirtClientFactory,
+		writeImage: func(ctx context.Context, namespace, pod string, image io.Reader) ([]byte, error) {
+			return kubeClient.CoreV1().RESTClient().Put().
+				Namespace(namespace).
+				Resource("pods").
+				Name(fmt.Sprintf("%s:%d", pod, imageSourcePort)).
+				SubResource("proxy").
+				Suffix(imageSourcePath).
+				Body(image).
+				DoRaw(ctx)
+		},
+		operatorImage: os.Getenv(operatorImageEnv),
+		eventRecorder: eventRecorder,
+		exports:       map[types.UID]*diskExport{},
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		exportInformer.Informer(),
+		pvcInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtDiskExportController) sync(ctx context.Context, syncCtx factory.SyncContext) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	objs, err := c.exportLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	active := map[types.UID]bool{}
+	requeue := diskExportRetryInterval
+	var errs []error
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		export := &v1alpha1.OvirtDiskExport{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, export); err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode OvirtDiskExport %s/%s: %w", u.GetNamespace(), u.GetName(), err))
+			continue
+		}
+		if export.Status.Phase == v1alpha1.OvirtDiskExportSucceeded || export.Status.Phase == v1alpha1.OvirtDiskExportFailed {
+			continue
+		}
+		active[export.UID] = true
+		if running, ok := c.exports[export.UID]; ok && running.recorded {
+			continue
+		}
+
+		status := export.Status
+		err := c.syncExport(ctx, export, &status)
+		if status.Phase == v1alpha1.OvirtDiskExportRunning {
+			requeue = populatorProgressInterval
+		}
+		if err == nil {
+			err = c.updateStatus(ctx, export, &status)
+		}
+		if err == nil && (status.Phase == v1alpha1.OvirtDiskExportSucceeded || status.Phase == v1alpha1.OvirtDiskExportFailed) {
+			// Kept until the informer has the result, removed by the cleanup below
+			c.exports[export.UID].recorded = true
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OvirtDiskExport %s/%s: %w", export.Namespace, export.Name, err))
+		}
+	}
+
+	// Cancel the exports which were deleted meanwhile, and drop the finished ones
+	for uid, export := range c.exports {
+		if !active[uid] {
+			export.cancel()
+			delete(c.exports, uid)
+		}
+	}
+	if len(active) > 0 {
+		syncCtx.Queue().AddAfter(syncCtx.QueueKey(), requeue)
+	}
+	return v1helpers.NewMultiLineAggregate(errs)
+}
+
+// syncExport advances the export and records its state in the status.
+func (c *OvirtDiskExportController) syncExport(ctx context.Context, export *v1alpha1.OvirtDiskExport, status *v1alpha1.OvirtDiskExportStatus) error {
+	running, ok := c.exports[export.UID]
+	if !ok {
+		// Not started yet, or the operator restarted during the export
+		started, message, err := c.startExport(ctx, export)
+		if err != nil {
+			return err
+		}
+		if started == nil {
+			if message != export.Status.Message {
+				klog.Infof("OvirtDiskExport %s/%s is pending: %s", export.Namespace, export.Name, message)
+			}
+			status.Phase, status.Message = v1alpha1.OvirtDiskExportPending, message
+			return nil
+		}
+		running = started
+		c.exports[export.UID] = running
+		klog.Infof("Started the export of disk %s for OvirtDiskExport %s/%s", running.diskID, export.Namespace, export.Name)
+	}
+
+	status.Phase, status.Message = v1alpha1.OvirtDiskExportRunning, ""
+	status.DiskID = string(running.diskID)
+	status.DownloadedBytes, status.TotalBytes = running.progress.done.Load(), running.progress.total.Load()
+	status.Progress = running.progress.percent()
+	select {
+	case <-running.done:
+	default:
+		return nil
+	}
+
+	now := metav1.Now()
+	status.CompletionTime = &now
+	if running.err != nil {
+		status.Phase, status.Message = v1alpha1.OvirtDiskExportFailed, running.err.Error()
+		c.eventRecorder.Warningf("DiskExportFailed", "Export of disk %s for OvirtDiskExport %s/%s failed: %v", running.diskID, export.Namespace, export.Name, running.err)
+	} else {
+		status.Phase, status.Checksum, status.Progress = v1alpha1.OvirtDiskExportSucceeded, running.checksum, "100%"
+		c.eventRecorder.Eventf("DiskExported", "Exported disk %s for OvirtDiskExport %s/%s to %s, SHA-256 %s", running.diskID, export.Namespace, export.Name, export.Spec.Target.Path, running.checksum)
+	}
+	err := c.kubeClient.CoreV1().Pods(export.Namespace).Delete(ctx, diskExportPodName(export), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Warningf("Failed to delete the target pod of OvirtDiskExport %s/%s: %v", export.Namespace, export.Name, err)
+	}
+	return nil
+}
+
+// startExport starts the export once the disk can be exported and the target pod is ready,
+// otherwise it returns why the export is pending.
+func (c *OvirtDiskExportController) startExport(ctx context.Context, export *v1alpha1.OvirtDiskExport) (*diskExport, string, error) {
+	spec := export.Spec
+	formatName := spec.Format
+	if formatName == "" {
+		formatName = "raw"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		return nil, fmt.Sprintf("Unsupported format %q, use raw or qcow2", spec.Format), nil
+	}
+	if err := validateVolumePath(spec.Target.Path); err != nil {
+		return nil, fmt.Sprintf("Invalid target: %v", err), nil
+	}
+
+	// Only the PVCs in the namespace of the export can be exported
+	pvc, err := c.pvcLister.PersistentVolumeClaims(export.Namespace).Get(spec.ClaimName)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Sprintf("PVC %s does not exist", spec.ClaimName), nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != instanceName {
+		return nil, fmt.Sprintf("PVC %s is not bound to a volume of %s", spec.ClaimName, instanceName), nil
+	}
+	diskID := ovirtclient.DiskID(pv.Spec.CSI.VolumeHandle)
+
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	if err := checkDiskExportable(ovirtClient, diskID); err != nil {
+		return nil, fmt.Sprintf("Not exported: %v", err), nil
+	}
+	pod, message, err := c.ensureTargetPod(ctx, export)
+	if pod == "" || err != nil {
+		return nil, message, err
+	}
+
+	exportCtx, cancel := context.WithCancel(context.Background())
+	running := &diskExport{cancel: cancel, diskID: diskID, done: make(chan struct{})}
+	go func() {
+		defer close(running.done)
+		running.checksum, running.err = c.runExport(exportCtx, ovirtClient, export.Namespace, pod, diskID, format, &running.progress)
+	}()
+	return running, "", nil
+}
+
+// runExport streams the download of the disk to the target pod and verifies the checksum of
+// the written file.
+func (c *OvirtDiskExportController) runExport(ctx context.Context, ovirtClient ovirtclient.Client, namespace, pod string, diskID ovirtclient.DiskID, format ovirtclient.ImageFormat, progress *transferProgress) (string, error) {
+	reader, writer := io.Pipe()
+	var downloadChecksum string
+	var downloadErr error
+	downloaded := make(chan struct{})
+	go func() {
+		defer close(downloaded)
+		downloadChecksum, downloadErr = downloadDisk(ctx, ovirtClient, diskID, format, writer, progress)
+		writer.CloseWithError(downloadErr)
+	}()
+
+	raw, err := c.writeImage(ctx, namespace, pod, reader)
+	// Unblock the download when the pod failed early
+	_ = reader.CloseWithError(io.ErrClosedPipe)
+	<-downloaded
+	if downloadErr != nil {
+		return "", downloadErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write the image in pod %s/%s: %w", namespace, pod, err)
+	}
+	if checksum := parseChecksum(raw); checksum != downloadChecksum {
+		return "", fmt.Errorf("checksum of the written image is %s, the download has %s", checksum, downloadChecksum)
+	}
+	return downloadChecksum, nil
+}
+
+// ensureTargetPod creates the pod writing the image to the target PVC and returns its name
+// once it is ready. The pod is owned by the export.
+func (c *OvirtDiskExportController) ensureTargetPod(ctx context.Context, export *v1alpha1.OvirtDiskExport) (string, string, error) {
+	name := diskExportPodName(export)
+	pod, err := c.kubeClient.CoreV1().Pods(export.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if c.operatorImage == "" {
+			return "", fmt.Sprintf("%s is not set, disks cannot be exported", operatorImageEnv), nil
+		}
+		if _, err := c.kubeClient.CoreV1().Pods(export.Namespace).Create(ctx, diskExportPod(name, c.operatorImage, export), metav1.CreateOptions{}); err != nil {
+			return "", "", fmt.Errorf("failed to create pod %s/%s: %w", export.Namespace, name, err)
+		}
+		return "", fmt.Sprintf("Waiting for the target pod %s", name), nil
+	case err != nil:
+		return "", "", err
+	case pod.Status.Phase == corev1.PodFailed:
+		// Deleted so that the next attempt starts a new one
+		if err := c.kubeClient.CoreV1().Pods(export.Namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return "", "", err
+		}
+		return "", fmt.Sprintf("Target pod %s failed", name), nil
+	case pod.Status.Phase != corev1.PodRunning || !isPodReady(pod):
+		return "", fmt.Sprintf("Waiting for the target pod %s", name), nil
+	}
+	return name, "", nil
+}
+
+func (c *OvirtDiskExportController) updateStatus(ctx context.Context, export *v1alpha1.OvirtDiskExport, status *v1alpha1.OvirtDiskExportStatus) error {
+	if equality.Semantic.DeepEqual(&export.Status, status) {
+		return nil
+	}
+	updated := export.DeepCopy()
+	updated.Status = *status
+	updated.APIVersion, updated.Kind = v1alpha1.GroupVersion.String(), v1alpha1.OvirtDiskExportKind
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updated)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(v1alpha1.OvirtDiskExportResource).Namespace(export.Namespace).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+// diskExportPod writes the image to the target PVC with receive-image.
+func diskExportPod(name, image string, export *v1alpha1.OvirtDiskExport) *corev1.Pod {
+	target := export.Spec.Target
+	allowPrivilegeEscalation, runAsNonRoot := false, true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: export.Namespace,
+			Labels:    map[string]string{diskExportLabel: export.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: v1alpha1.GroupVersion.String(),
+				Kind:       v1alpha1.OvirtDiskExportKind,
+				Name:       export.Name,
+				UID:        export.UID,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:  "receive-image",
+				Image: image,
+				Args:  []string{"receive-image", "--file", path.Join("/target", target.Path)},
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: imageSourcePort}},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/" + imageReadyPath, Port: intstr.FromInt(imageSourcePort)},
+					},
+				},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("50Mi"),
+					},
+				},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					RunAsNonRoot:             &runAsNonRoot,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+					SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				},
+				TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+				VolumeMounts:             []corev1.VolumeMount{{Name: "target", MountPath: "/target"}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "target",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: target.ClaimName},
+				},
+			}},
+		},
+	}
+}
+
+func diskExportPodName(export *v1alpha1.OvirtDiskExport) string {
+	return "ovirt-disk-export-" + string(export.UID)
+}
@@ -0,0 +1,232 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+func TestDiskExportSync(t *testing.T) {
+	const (
+		storageDomainID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1"
+		vmID            = "3f9e7c52-1d4b-4e8a-9b0c-6a2d5f8e1c47"
+		diskID          = "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f"
+	)
+	export := &v1alpha1.OvirtDiskExport{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-backup", Namespace: "app", UID: "7c1e5a2b-3d4f-4a6b-8c9d-0e1f2a3b4c5d"},
+		Spec: v1alpha1.OvirtDiskExportSpec{
+			ClaimName: "db",
+			Format:    "qcow2",
+			Target:    v1alpha1.OvirtDiskExportTarget{ClaimName: "backups", Path: "db.qcow2"},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: export.Namespace},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pv-db"},
+	}
+	pv := newDriverPV("pv-db", diskID, time.Now(), nil)
+	newTargetPod := func(phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: diskExportPodName(export), Namespace: export.Namespace},
+			Status: corev1.PodStatus{
+				Phase:      phase,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	newRunningExport := func(done bool, checksum string, err error) *diskExport {
+		running := &diskExport{cancel: func() {}, diskID: diskID, done: make(chan struct{}), checksum: checksum, err: err}
+		running.progress.total.Store(4 << 20)
+		running.progress.done.Store(1 << 20)
+		if done {
+			close(running.done)
+		}
+		return running
+	}
+
+	testCases := []struct {
+		name string
+		// spec modifies the spec of the export
+		spec    func(spec *v1alpha1.OvirtDiskExportSpec)
+		running *diskExport
+		// attached attaches the disk read-write to a running VM
+		attached      bool
+		objects       []runtime.Object
+		expectPhase   v1alpha1.OvirtDiskExportPhase
+		expectMessage string
+		expectPod     bool
+		expectStarted bool
+	}{
+		{
+			name:          "missing PVC",
+			objects:       []runtime.Object{pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "PVC db does not exist",
+		},
+		{
+			name:          "unsupported format",
+			spec:          func(spec *v1alpha1.OvirtDiskExportSpec) { spec.Format = "vmdk" },
+			objects:       []runtime.Object{pvc, pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "Unsupported format",
+		},
+		{
+			name:          "target path outside the volume",
+			spec:          func(spec *v1alpha1.OvirtDiskExportSpec) { spec.Target.Path = "../../etc/cron.d/backup" },
+			objects:       []runtime.Object{pvc, pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "Invalid target",
+		},
+		{
+			name:          "absolute target path",
+			spec:          func(spec *v1alpha1.OvirtDiskExportSpec) { spec.Target.Path = "/etc/passwd" },
+			objects:       []runtime.Object{pvc, pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "Invalid target",
+		},
+		{
+			name:          "disk attached read-write to a running VM",
+			attached:      true,
+			objects:       []runtime.Object{pvc, pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "attached read-write",
+		},
+		{
+			name:          "target pod is created first",
+			objects:       []runtime.Object{pvc, pv},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "Waiting for the target pod",
+			expectPod:     true,
+		},
+		{
+			name:          "failed target pod is replaced",
+			objects:       []runtime.Object{pvc, pv, newTargetPod(corev1.PodFailed)},
+			expectPhase:   v1alpha1.OvirtDiskExportPending,
+			expectMessage: "failed",
+		},
+		{
+			name:          "export starts once the target pod is ready",
+			objects:       []runtime.Object{pvc, pv, newTargetPod(corev1.PodRunning)},
+			expectPhase:   v1alpha1.OvirtDiskExportRunning,
+			expectPod:     true,
+			expectStarted: true,
+		},
+		{
+			name:        "running export reports its progress",
+			running:     newRunningExport(false, "", nil),
+			objects:     []runtime.Object{pvc, pv, newTargetPod(corev1.PodRunning)},
+			expectPhase: v1alpha1.OvirtDiskExportRunning,
+			expectPod:   true,
+		},
+		{
+			name:        "finished export",
+			running:     newRunningExport(true, "5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef", nil),
+			objects:     []runtime.Object{pvc, pv, newTargetPod(corev1.PodRunning)},
+			expectPhase: v1alpha1.OvirtDiskExportSucceeded,
+		},
+		{
+			name:          "failed export",
+			running:       newRunningExport(true, "", errors.New("checksum of the written image is 0, the download has 1")),
+			objects:       []runtime.Object{pvc, pv, newTargetPod(corev1.PodRunning)},
+			expectPhase:   v1alpha1.OvirtDiskExportFailed,
+			expectMessage: "checksum",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startFakeEngine(t)
+			engine.AddStorageDomain(storageDomainID, "nfs", 80<<30, 20<<30)
+			engine.AddVM(vmID, "worker-0")
+			if err := engine.AddDisk(diskID, "pvc-db", storageDomainID, 5<<30); err != nil {
+				t.Fatal(err)
+			}
+			if tc.attached {
+				if err := engine.AttachDisk(vmID, diskID, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ovirtClient := newFakeEngineClient(t)
+
+			export := export.DeepCopy()
+			if tc.spec != nil {
+				tc.spec(&export.Spec)
+			}
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			c := &OvirtDiskExportController{
+				name:               "OvirtDiskExportController",
+				kubeClient:         kubeClient,
+				pvcLister:          kubeInformers.Core().V1().PersistentVolumeClaims().Lister(),
+				pvLister:           kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				ovirtClientFactory: func() (ovirtclient.Client, error) { return ovirtClient, nil },
+				writeImage: func(_ context.Context, _, _ string, image io.Reader) ([]byte, error) {
+					_, err := io.Copy(io.Discard, image)
+					return nil, err
+				},
+				operatorImage: "quay.io/ovirt/csi-driver-operator:latest",
+				eventRecorder: events.NewInMemoryRecorder("test"),
+				exports:       map[types.UID]*diskExport{},
+			}
+			if tc.running != nil {
+				c.exports[export.UID] = tc.running
+			}
+			defer func() {
+				for _, running := range c.exports {
+					if running != tc.running {
+						running.cancel()
+						<-running.done
+					}
+				}
+			}()
+
+			status := export.Status
+			if err := c.syncExport(context.Background(), export, &status); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status.Phase != tc.expectPhase {
+				t.Errorf("expected phase %s, got %s: %s", tc.expectPhase, status.Phase, status.Message)
+			}
+			if !strings.Contains(status.Message, tc.expectMessage) {
+				t.Errorf("expected the message to contain %q, got %q", tc.expectMessage, status.Message)
+			}
+			running, started := c.exports[export.UID]
+			if started = started && running != tc.running; started != tc.expectStarted {
+				t.Errorf("expected the export started %t, got %t", tc.expectStarted, started)
+			}
+
+			switch status.Phase {
+			case v1alpha1.OvirtDiskExportRunning:
+				if status.DiskID != diskID {
+					t.Errorf("expected disk %s in the status, got %q", diskID, status.DiskID)
+				}
+				if tc.running != nil && status.Progress != "25%" {
+					t.Errorf("expected progress 25%%, got %q", status.Progress)
+				}
+			case v1alpha1.OvirtDiskExportSucceeded:
+				if status.Checksum != tc.running.checksum || status.Progress != "100%" || status.CompletionTime == nil {
+					t.Errorf("expected the checksum and completion in the status, got %+v", status)
+				}
+			case v1alpha1.OvirtDiskExportFailed:
+				if status.CompletionTime == nil {
+					t.Errorf("expected the completion time in the status")
+				}
+			}
+
+			_, err := kubeClient.CoreV1().Pods(export.Namespace).Get(context.Background(), diskExportPodName(export), metav1.GetOptions{})
+			if exists := err == nil; exists != tc.expectPod {
+				t.Errorf("expected the target pod %t, got %v", tc.expectPod, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+const (
+	// diskImportAnnotation on the PV and PVC names the OvirtDiskImport they were created for
+	diskImportAnnotation = "csi.ovirt.org/disk-import"
+
+	// provisionedByAnnotation lets the provisioner delete the disk of a PV with the Delete
+	// reclaim policy, as for the PVs it provisioned itself
+	provisionedByAnnotation = "pv.kubernetes.io/provisioned-by"
+
+	// diskImportResync retries the imports blocked by the state of their disk, which
+	// changes without any event in the cluster
+	diskImportResync = time.Minute
+)
+
+// diskImportError is a problem with an import which is reported in its Ready condition and
+// retried, but does not degrade the operator.
+type diskImportError struct {
+	reason  string
+	message string
+}
+
+func (e *diskImportError) Error() string {
+	return e.message
+}
+
+func newDiskImportError(reason, format string, args ...interface{}) error {
+	return &diskImportError{reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+// OvirtDiskImportController reconciles the OvirtDiskImport resources. It resolves the disk
+// by ID or alias, checks that it is ok and not attached to any VM, and creates a PV of the
+// driver with the provisioned size of the disk and, when requested, a PVC pre-bound to it.
+// Imported disks are not checked again and deleting an import keeps the PV and PVC.
+type OvirtDiskImportController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	dynamicClient      dynamic.Interface
+	importLister       cache.GenericLister
+	pvLister           corelisters.PersistentVolumeLister
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	eventRecorder      events.Recorder
+}
+
+func NewOvirtDiskImportController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	importInformer informers.GenericInformer,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtDiskImportController{
+		name:               "OvirtDiskImportController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		dynamicClient:      dynamicClient,
+		importLister:       importInformer.Lister(),
+		pvLister:           pvInformer.Lister(),
+		ovirtClientFactory: ovirtClientFactory,
+		eventRecorder:      eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		importInformer.Informer(),
+		pvInformer.Informer(),
+	).ResyncEvery(diskImportResync).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtDiskImportController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	objs, err := c.importLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		diskImport := &v1alpha1.OvirtDiskImport{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, diskImport); err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode OvirtDiskImport %s: %w", u.GetName(), err))
+			continue
+		}
+		if err := c.syncImport(ctx, diskImport); err != nil {
+			errs = append(errs, fmt.Errorf("OvirtDiskImport %s: %w", diskImport.Name, err))
+		}
+	}
+	return v1helpers.NewMultiLineAggregate(errs)
+}
+
+func (c *OvirtDiskImportController) syncImport(ctx context.Context, diskImport *v1alpha1.OvirtDiskImport) error {
+	if meta.IsStatusConditionTrue(diskImport.Status.Conditions, v1alpha1.OvirtDiskImportConditionReady) {
+		return nil
+	}
+
+	status := diskImport.Status.DeepCopy()
+	err := c.importDisk(ctx, diskImport, status)
+	condition := metav1.Condition{
+		Type:               v1alpha1.OvirtDiskImportConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Imported",
+		Message:            fmt.Sprintf("Disk %s is imported as PV %s", status.DiskID, status.PersistentVolumeName),
+		ObservedGeneration: diskImport.Generation,
+	}
+	if importErr, ok := err.(*diskImportError); ok {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, importErr.reason, importErr.message
+		err = nil
+	} else if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, "ImportFailed", err.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	if condition.Status == metav1.ConditionTrue {
+		klog.Infof("OvirtDiskImport %s: %s", diskImport.Name, condition.Message)
+		c.eventRecorder.Eventf("OvirtDiskImported", "OvirtDiskImport %s: %s", diskImport.Name, condition.Message)
+	}
+	if updateErr := c.updateStatus(ctx, diskImport, status); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+// importDisk creates the PV and PVC of the import and records them in the status.
+func (c *OvirtDiskImportController) importDisk(ctx context.Context, diskImport *v1alpha1.OvirtDiskImport, status *v1alpha1.OvirtDiskImportStatus) error {
+	spec := diskImport.Spec
+	if err := validateDiskImportSpec(spec); err != nil {
+		return err
+	}
+	pvName := spec.PersistentVolumeName
+	if pvName == "" {
+		pvName = diskImport.Name
+	}
+
+	pv, err := c.pvLister.Get(pvName)
+	switch {
+	case apierrors.IsNotFound(err):
+		disk, err := c.resolveDisk(ctx, spec)
+		if err != nil {
+			return err
+		}
+		if pv, err = c.createPersistentVolume(ctx, diskImport, pvName, disk); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case pv.Annotations[diskImportAnnotation] != diskImport.Name:
+		return newDiskImportError("PersistentVolumeExists", "PV %s exists already", pvName)
+	}
+	// The PV may be left from an earlier attempt whose status update failed
+	status.DiskID = pv.Spec.CSI.VolumeHandle
+	status.PersistentVolumeName = pv.Name
+	capacity := pv.Spec.Capacity[corev1.ResourceStorage]
+	status.Capacity = capacity.String()
+
+	if spec.Claim != nil {
+		return c.ensureClaim(ctx, diskImport, pv)
+	}
+	return nil
+}
+
+// resolveDisk returns the disk of the import, provided it can be imported.
+func (c *OvirtDiskImportController) resolveDisk(ctx context.Context, spec v1alpha1.OvirtDiskImportSpec) (ovirtclient.Disk, error) {
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+
+	var disk ovirtclient.Disk
+	if spec.DiskID != "" {
+		disk, err = ovirtClient.GetDisk(ovirtclient.DiskID(spec.DiskID), ovirtclient.ContextStrategy(ctx))
+		if err != nil {
+			if ovirtclient.HasErrorCode(err, ovirtclient.ENotFound) {
+				return nil, newDiskImportError("DiskNotFound", "Disk %s does not exist", spec.DiskID)
+			}
+			return nil, fmt.Errorf("failed to get disk %s: %w", spec.DiskID, err)
+		}
+	} else {
+		found, err := ovirtClient.ListDisksByAlias(spec.DiskAlias, ovirtclient.ContextStrategy(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list disks with alias %s: %w", spec.DiskAlias, err)
+		}
+		// The engine search matches wildcards in the alias
+		var disks []ovirtclient.Disk
+		for _, d := range found {
+			if d.Alias() == spec.DiskAlias {
+				disks = append(disks, d)
+			}
+		}
+		switch len(disks) {
+		case 0:
+			return nil, newDiskImportError("DiskNotFound", "No disk has the alias %s", spec.DiskAlias)
+		case 1:
+			disk = disks[0]
+		default:
+			return nil, newDiskImportError("DiskAliasAmbiguous", "%d disks have the alias %s, import the disk by its ID", len(disks), spec.DiskAlias)
+		}
+	}
+
+	if disk.Status() != ovirtclient.DiskStatusOK {
+		return nil, newDiskImportError("DiskNotReady", "Disk %s is %s", disk.ID(), disk.Status())
+	}
+	vms, err := diskAttachedVMs(ovirtClient, disk.ID())
+	if errors.Is(err, errDiskAttachmentsUnknown) {
+		return nil, newDiskImportError("DiskAttachmentsUnknown", "Disk %s is not imported: %v", disk.ID(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(vms) > 0 {
+		return nil, newDiskImportError("DiskAttached", "Disk %s is attached to VMs %s, detach it first", disk.ID(), strings.Join(vms, ", "))
+	}
+	pvs, err := driverPersistentVolumes(c.pvLister)
+	if err != nil {
+		return nil, err
+	}
+	for _, pv := range pvs {
+		if pv.Spec.CSI.VolumeHandle == string(disk.ID()) {
+			return nil, newDiskImportError("DiskInUse", "Disk %s is used by PV %s already", disk.ID(), pv.Name)
+		}
+	}
+	return disk, nil
+}
+
+func (c *OvirtDiskImportController) createPersistentVolume(ctx context.Context, diskImport *v1alpha1.OvirtDiskImport, name string, disk ovirtclient.Disk) (*corev1.PersistentVolume, error) {
+	spec := diskImport.Spec
+	volumeMode := diskImportVolumeMode(spec)
+	reclaimPolicy := spec.ReclaimPolicy
+	if reclaimPolicy == "" {
+		reclaimPolicy = corev1.PersistentVolumeReclaimRetain
+	}
+	csiSource := &corev1.CSIPersistentVolumeSource{
+		Driver:       instanceName,
+		VolumeHandle: string(disk.ID()),
+	}
+	if volumeMode == corev1.PersistentVolumeFilesystem {
+		csiSource.FSType = spec.FSType
+		if csiSource.FSType == "" {
+			csiSource.FSType = "ext4"
+		}
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				diskImportAnnotation:    diskImport.Name,
+				provisionedByAnnotation: instanceName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: *resource.NewQuantity(int64(disk.ProvisionedSize()), resource.BinarySI),
+			},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              spec.StorageClassName,
+			VolumeMode:                    &volumeMode,
+			PersistentVolumeSource:        corev1.PersistentVolumeSource{CSI: csiSource},
+		},
+	}
+	if claim := spec.Claim; claim != nil {
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  claim.Namespace,
+			Name:       claim.Name,
+		}
+	}
+	created, err := c.kubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PV %s: %w", name, err)
+	}
+	klog.Infof("Created PV %s for disk %s of OvirtDiskImport %s", name, disk.ID(), diskImport.Name)
+	return created, nil
+}
+
+// ensureClaim creates the PVC pre-bound to the PV, unless it exists already.
+func (c *OvirtDiskImportController) ensureClaim(ctx context.Context, diskImport *v1alpha1.OvirtDiskImport, pv *corev1.PersistentVolume) error {
+	claim := diskImport.Spec.Claim
+	existing, err := c.kubeClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Get(ctx, claim.Name, metav1.GetOptions{})
+	if err == nil {
+		if existing.Spec.VolumeName != pv.Name {
+			return newDiskImportError("ClaimExists", "PVC %s/%s exists already and is not bound to PV %s", claim.Namespace, claim.Name, pv.Name)
+		}
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get PVC %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+
+	storageClassName := pv.Spec.StorageClassName
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   claim.Namespace,
+			Name:        claim.Name,
+			Annotations: map[string]string{diskImportAnnotation: diskImport.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: pv.Spec.AccessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage]},
+			},
+			VolumeName: pv.Name,
+			// An empty storage class keeps the default StorageClass from being assigned
+			StorageClassName: &storageClassName,
+			VolumeMode:       pv.Spec.VolumeMode,
+		},
+	}
+	if _, err := c.kubeClient.CoreV1().PersistentVolumeClaims(claim.Namespace).Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create PVC %s/%s: %w", claim.Namespace, claim.Name, err)
+	}
+	klog.Infof("Created PVC %s/%s for PV %s of OvirtDiskImport %s", claim.Namespace, claim.Name, pv.Name, diskImport.Name)
+	return nil
+}
+
+func (c *OvirtDiskImportController) updateStatus(ctx context.Context, diskImport *v1alpha1.OvirtDiskImport, status *v1alpha1.OvirtDiskImportStatus) error {
+	if equality.Semantic.DeepEqual(&diskImport.Status, status) {
+		return nil
+	}
+	updated := diskImport.DeepCopy()
+	updated.Status = *status
+	updated.APIVersion, updated.Kind = v1alpha1.GroupVersion.String(), "OvirtDiskImport"
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updated)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(v1alpha1.OvirtDiskImportResource).UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+func validateDiskImportSpec(spec v1alpha1.OvirtDiskImportSpec) error {
+	var errs []string
+	if (spec.DiskID == "") == (spec.DiskAlias == "") {
+		errs = append(errs, "exactly one of diskID and diskAlias must be set")
+	}
+	switch spec.VolumeMode {
+	case "", corev1.PersistentVolumeFilesystem, corev1.PersistentVolumeBlock:
+	default:
+		errs = append(errs, fmt.Sprintf("unsupported volumeMode %q", spec.VolumeMode))
+	}
+	switch spec.ReclaimPolicy {
+	case "", corev1.PersistentVolumeReclaimRetain, corev1.PersistentVolumeReclaimDelete:
+	default:
+		errs = append(errs, fmt.Sprintf("unsupported reclaimPolicy %q", spec.ReclaimPolicy))
+	}
+	if spec.FSType != "" && !supportedFSTypes.Has(spec.FSType) {
+		errs = append(errs, fmt.Sprintf("fsType must be one of %s", strings.Join(supportedFSTypes.List(), ", ")))
+	}
+	if claim := spec.Claim; claim != nil && (claim.Namespace == "" || claim.Name == "") {
+		errs = append(errs, "claim.namespace and claim.name must be set")
+	}
+	if len(errs) > 0 {
+		return newDiskImportError("InvalidSpec", "%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func diskImportVolumeMode(spec v1alpha1.OvirtDiskImportSpec) corev1.PersistentVolumeMode {
+	if spec.VolumeMode == "" {
+		return corev1.PersistentVolumeFilesystem
+	}
+	return spec.VolumeMode
+}
+
+// errDiskAttachmentsUnknown is returned by diskAttachedVMs when the oVirt client gives no
+// access to the SDK.
+var errDiskAttachmentsUnknown = errors.New("the VMs disks are attached to cannot be read through the oVirt client")
+
+// diskAttachedVMs returns the IDs of the VMs the disk is attached to. They are not available
+// through the oVirt client, they are read through the SDK, and errDiskAttachmentsUnknown is
+// returned without it.
+func diskAttachedVMs(ovirtClient ovirtclient.Client, id ovirtclient.DiskID) ([]string, error) {
+	legacyClient, ok := ovirtClient.(ovirtclient.ClientWithLegacySupport)
+	if !ok {
+		return nil, errDiskAttachmentsUnknown
+	}
+	response, err := legacyClient.GetSDKClient().SystemService().DisksService().DiskService(string(id)).Get().Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk %s: %w", id, err)
+	}
+	var ids []string
+	if disk, ok := response.Disk(); ok {
+		if vms, ok := disk.Vms(); ok {
+			for _, vm := range vms.Slice() {
+				vmID, _ := vm.Id()
+				ids = append(ids, vmID)
+			}
+		}
+	}
+	return ids, nil
+}
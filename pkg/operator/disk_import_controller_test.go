@@ -0,0 +1,215 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+func TestDiskImportSync(t *testing.T) {
+	const (
+		storageDomainID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1"
+		vmID            = "3f9e7c52-1d4b-4e8a-9b0c-6a2d5f8e1c47"
+		freeDiskID      = "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f"
+		attachedDiskID  = "c1d2e3f4-0516-4728-993a-4b5c6d7e8f90"
+		lockedDiskID    = "d2e3f405-1627-4839-8a4b-5c6d7e8f9001"
+		twinDiskID      = "e3f40516-2738-494a-9b5c-6d7e8f900112"
+	)
+	testCases := []struct {
+		name         string
+		spec         v1alpha1.OvirtDiskImportSpec
+		objects      []runtime.Object
+		expectReason string
+		// expectPV is the disk of the PV created for the import, none when empty
+		expectPV    string
+		expectClaim bool
+	}{
+		{
+			name:         "import by ID",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: freeDiskID},
+			expectReason: "Imported",
+			expectPV:     freeDiskID,
+		},
+		{
+			name: "import by alias with a claim",
+			spec: v1alpha1.OvirtDiskImportSpec{
+				DiskAlias: "data",
+				Claim:     &v1alpha1.OvirtDiskImportClaim{Namespace: "app", Name: "data"},
+			},
+			expectReason: "Imported",
+			expectPV:     freeDiskID,
+			expectClaim:  true,
+		},
+		{
+			name:         "invalid spec",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: freeDiskID, DiskAlias: "data"},
+			expectReason: "InvalidSpec",
+		},
+		{
+			name:         "unknown disk",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: "f4051627-3849-4a5b-8c6d-7e8f90011223"},
+			expectReason: "DiskNotFound",
+		},
+		{
+			name:         "ambiguous alias",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskAlias: "twin"},
+			expectReason: "DiskAliasAmbiguous",
+		},
+		{
+			name:         "locked disk",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: lockedDiskID},
+			expectReason: "DiskNotReady",
+		},
+		{
+			name:         "attached disk",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: attachedDiskID},
+			expectReason: "DiskAttached",
+		},
+		{
+			name:         "disk of an existing PV",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: freeDiskID},
+			objects:      []runtime.Object{newDriverPV("pv-a", freeDiskID, metav1.Now().Time, nil)},
+			expectReason: "DiskInUse",
+		},
+		{
+			name:         "PV name taken",
+			spec:         v1alpha1.OvirtDiskImportSpec{DiskID: freeDiskID, PersistentVolumeName: "pv-a"},
+			objects:      []runtime.Object{newDriverPV("pv-a", attachedDiskID, metav1.Now().Time, nil)},
+			expectReason: "PersistentVolumeExists",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startFakeEngine(t)
+			engine.AddStorageDomain(storageDomainID, "nfs", 80<<30, 20<<30)
+			engine.AddVM(vmID, "worker-0")
+			for id, alias := range map[string]string{freeDiskID: "data", attachedDiskID: "attached", lockedDiskID: "locked", twinDiskID: "twin"} {
+				if err := engine.AddDisk(id, alias, storageDomainID, 5<<30); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := engine.AddDisk("f5061728-4950-4b6c-9d7e-8f9001122334", "twin", storageDomainID, 5<<30); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.AttachDisk(vmID, attachedDiskID, false); err != nil {
+				t.Fatal(err)
+			}
+			if err := engine.SetDiskStatus(lockedDiskID, ovirtsdk.DISKSTATUS_LOCKED); err != nil {
+				t.Fatal(err)
+			}
+			ovirtClient := newFakeEngineClient(t)
+
+			diskImport := &v1alpha1.OvirtDiskImport{
+				TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "OvirtDiskImport"},
+				ObjectMeta: metav1.ObjectMeta{Name: "import"},
+				Spec:       tc.spec,
+			}
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			dynamicClient := newFakeDynamicClient(t, diskImport)
+			c := &OvirtDiskImportController{
+				name:               "OvirtDiskImportController",
+				operatorClient:     v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{}, nil),
+				kubeClient:         kubeClient,
+				dynamicClient:      dynamicClient,
+				pvLister:           kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				ovirtClientFactory: func() (ovirtclient.Client, error) { return ovirtClient, nil },
+				eventRecorder:      events.NewInMemoryRecorder("test"),
+			}
+			if err := c.syncImport(context.Background(), diskImport); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated := &v1alpha1.OvirtDiskImport{}
+			getFakeDynamicObject(t, dynamicClient, v1alpha1.OvirtDiskImportResource, "", diskImport.Name, updated)
+			condition := meta.FindStatusCondition(updated.Status.Conditions, v1alpha1.OvirtDiskImportConditionReady)
+			if condition == nil || condition.Reason != tc.expectReason {
+				t.Fatalf("expected the Ready condition with reason %s, got %+v", tc.expectReason, condition)
+			}
+
+			pvs, err := kubeClient.CoreV1().PersistentVolumes().List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var imported *corev1.PersistentVolume
+			for i := range pvs.Items {
+				if pvs.Items[i].Annotations[diskImportAnnotation] == diskImport.Name {
+					imported = &pvs.Items[i]
+				}
+			}
+			switch {
+			case tc.expectPV == "" && imported != nil:
+				t.Errorf("expected no PV, got %s", imported.Name)
+			case tc.expectPV != "" && imported == nil:
+				t.Errorf("expected a PV of disk %s", tc.expectPV)
+			case tc.expectPV != "":
+				if imported.Spec.CSI.VolumeHandle != tc.expectPV || updated.Status.DiskID != tc.expectPV {
+					t.Errorf("expected the PV of disk %s, got %s and status %s", tc.expectPV, imported.Spec.CSI.VolumeHandle, updated.Status.DiskID)
+				}
+				if capacity := imported.Spec.Capacity[corev1.ResourceStorage]; capacity.Value() != 5<<30 {
+					t.Errorf("expected the provisioned size of the disk as capacity, got %s", capacity.String())
+				}
+				if imported.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+					t.Errorf("expected the Retain reclaim policy, got %s", imported.Spec.PersistentVolumeReclaimPolicy)
+				}
+			}
+
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims("app").Get(context.Background(), "data", metav1.GetOptions{})
+			if tc.expectClaim {
+				if err != nil {
+					t.Fatalf("expected the PVC: %v", err)
+				}
+				if imported != nil && pvc.Spec.VolumeName != imported.Name {
+					t.Errorf("expected the PVC pre-bound to PV %s, got %q", imported.Name, pvc.Spec.VolumeName)
+				}
+			} else if err == nil {
+				t.Errorf("expected no PVC")
+			}
+		})
+	}
+}
+
+// newFakeDynamicClient returns a fake dynamic client serving the resources of the
+// csi.ovirt.org API group, holding the objects of its types.
+func newFakeDynamicClient(t *testing.T, objects ...interface{}) *dynamicfake.FakeDynamicClient {
+	t.Helper()
+	var unstructuredObjects []runtime.Object
+	for _, obj := range objects {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unstructuredObjects = append(unstructuredObjects, &unstructured.Unstructured{Object: u})
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		v1alpha1.OvirtDiskImportResource:     "OvirtDiskImportList",
+		v1alpha1.OvirtImagePopulatorResource: "OvirtImagePopulatorList",
+		v1alpha1.OvirtDiskExportResource:     "OvirtDiskExportList",
+		v1alpha1.VolumeMigrationResource:     "VolumeMigrationList",
+	}, unstructuredObjects...)
+}
+
+// getFakeDynamicObject reads the object from the fake dynamic client into obj.
+func getFakeDynamicObject(t *testing.T, dynamicClient *dynamicfake.FakeDynamicClient, resource schema.GroupVersionResource, namespace, name string, obj interface{}) {
+	t.Helper()
+	u, err := dynamicClient.Resource(resource).Namespace(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj); err != nil {
+		t.Fatal(err)
+	}
+}
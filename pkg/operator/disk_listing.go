@@ -0,0 +1,62 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	"k8s.io/klog/v2"
+)
+
+// diskListingMaxAge is how long a listing of all engine disks is shared by the controllers
+// before the engine is asked again. It matches the most frequent of them, the disk status
+// check.
+const diskListingMaxAge = diskStatusResync
+
+// diskListing holds all disks of the engine as listed at a point in time.
+type diskListing struct {
+	disks map[ovirtclient.DiskID]ovirtclient.Disk
+	// listed is when the request was sent, disks created later are missing
+	listed time.Time
+}
+
+// newDiskLister returns a function which lists all disks of the engine. Listing them is
+// one of the most expensive engine requests, so the listing is shared by all callers until
+// it is older than maxAge. Failures are not cached, the next call asks the engine again.
+func newDiskLister(
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	maxAge time.Duration,
+	now func() time.Time,
+) func(ctx context.Context) (*diskListing, error) {
+	var lock sync.Mutex
+	var last *diskListing
+	return func(ctx context.Context) (*diskListing, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		started := now()
+		if last != nil && started.Sub(last.listed) < maxAge {
+			return last, nil
+		}
+		ovirtClient, err := ovirtClientFactory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create oVirt client (%w)", err)
+		}
+		disks, err := ovirtClient.ListDisks(ovirtclient.ContextStrategy(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list disks: %w", err)
+		}
+		listing := &diskListing{
+			disks:  make(map[ovirtclient.DiskID]ovirtclient.Disk, len(disks)),
+			listed: started,
+		}
+		for _, disk := range disks {
+			listing.disks[disk.ID()] = disk
+		}
+		klog.V(4).Infof("Listed %d disks", len(disks))
+		last = listing
+		return listing, nil
+	}
+}
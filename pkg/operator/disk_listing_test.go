@@ -0,0 +1,74 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+)
+
+func TestDiskLister(t *testing.T) {
+	ovirtClient := ovirtclient.NewMock()
+	first := newMockDisk(t, ovirtClient, 1<<30)
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var factoryErr error
+	requests := 0
+	listDisks := newDiskLister(func() (ovirtclient.Client, error) {
+		requests++
+		return ovirtClient, factoryErr
+	}, time.Minute, func() time.Time { return now })
+
+	expectDisks := func(expectRequests int, expectIDs ...ovirtclient.DiskID) {
+		t.Helper()
+		listing, err := listDisks(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if requests != expectRequests {
+			t.Errorf("expected %d engine requests, got %d", expectRequests, requests)
+		}
+		if len(listing.disks) != len(expectIDs) {
+			t.Errorf("expected %d disks, got %d", len(expectIDs), len(listing.disks))
+		}
+		for _, id := range expectIDs {
+			if _, ok := listing.disks[id]; !ok {
+				t.Errorf("expected disk %s in the listing", id)
+			}
+		}
+	}
+
+	expectDisks(1, first)
+
+	// A disk created meanwhile is missing until the listing expires
+	second := newMockDisk(t, ovirtClient, 2<<30)
+	now = now.Add(59 * time.Second)
+	expectDisks(1, first)
+	now = now.Add(time.Second)
+	expectDisks(2, first, second)
+
+	// Failures are not shared, the next caller asks the engine again
+	now = now.Add(time.Minute)
+	factoryErr = errors.New("engine unreachable")
+	if _, err := listDisks(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	factoryErr = nil
+	expectDisks(4, first, second)
+}
+
+// newMockDisk creates a disk in the first storage domain of the mock engine and returns its ID.
+func newMockDisk(t *testing.T, ovirtClient ovirtclient.MockClient, size uint64) ovirtclient.DiskID {
+	t.Helper()
+	storageDomains, err := ovirtClient.ListStorageDomains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := ovirtClient.CreateDisk(storageDomains[0].ID(), ovirtclient.ImageFormatRaw, size, nil)
+	if err != nil {
+		t.Fatalf("failed to create disk: %v", err)
+	}
+	return disk.ID()
+}
@@ -0,0 +1,276 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PV annotations with the engine-side status of the disk backing the volume and the
+	// time it was first seen in that status
+	diskStatusAnnotation      = "csi.ovirt.org/disk-status"
+	diskStatusSinceAnnotation = "csi.ovirt.org/disk-status-since"
+
+	// diskStatusNotFound is the status of a PV whose disk does not exist in the engine
+	diskStatusNotFound ovirtclient.DiskStatus = "notfound"
+
+	// diskStatusResync is the interval the disks are checked in
+	diskStatusResync = time.Minute
+
+	// maxReportedVolumes limits the PV names listed in condition messages
+	maxReportedVolumes = 10
+)
+
+// diskState is the status of the disk behind a PV as recorded in its annotations.
+type diskState struct {
+	status ovirtclient.DiskStatus
+	since  time.Time
+}
+
+// OvirtDiskStatusController checks the status of the disks behind the PVs of the driver in
+// the shared listing of all engine disks. Upgrading the driver while a disk is locked, i.e. in
+// the middle of an engine operation, or illegal risks corrupting the volume, so upgrades are
+// blocked while any disk stays in such a state for longer than the configured threshold. The
+// status is recorded in PV annotations and changes to locked or illegal disks are reported as
+// events on the PV and its PVC, so that they are seen before I/O fails. The annotations and
+// events are handled for a batch of PVs per resync to spare the API server.
+type OvirtDiskStatusController struct {
+	name           string
+	operatorClient v1helpers.OperatorClient
+	kubeClient     kubernetes.Interface
+	pvLister       corelisters.PersistentVolumeLister
+	getConfig      func() (*OperatorConfig, error)
+	listDisks      func(ctx context.Context) (*diskListing, error)
+	eventRecorder  events.Recorder
+
+	// states holds the latest state of the disk of each PV, ahead of the annotations of the
+	// PVs not recorded yet
+	states map[string]diskState
+	// lastChecked is the name of the last PV recorded, the next batch starts after it
+	lastChecked string
+	// lastBatch is when the last batch was recorded, PV events do not trigger extra batches
+	lastBatch time.Time
+	// lockedReported holds the PVs whose locked disk has been reported already
+	lockedReported sets.String
+}
+
+func NewOvirtDiskStatusController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	listDisks func(ctx context.Context) (*diskListing, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtDiskStatusController{
+		name:           "OvirtDiskStatusController",
+		operatorClient: operatorClient,
+		kubeClient:     kubeClient,
+		pvLister:       pvInformer.Lister(),
+		getConfig:      getConfig,
+		listDisks:      listDisks,
+		eventRecorder:  eventRecorder,
+		states:         map[string]diskState{},
+		lockedReported: sets.NewString(),
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		pvInformer.Informer(),
+		configMapInformer.Informer(),
+	).ResyncEvery(diskStatusResync).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtDiskStatusController) sync(ctx context.Context, _ factory.SyncContext) error {
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping disk status check: %v", err)
+		return nil
+	}
+	threshold := config.DiskStatus.Threshold.Duration
+
+	pvs, err := driverPersistentVolumes(c.pvLister)
+	if err != nil {
+		return err
+	}
+	sort.Slice(pvs, func(i, j int) bool { return pvs[i].Name < pvs[j].Name })
+
+	listing, err := c.listDisks(ctx)
+	if err != nil {
+		return err
+	}
+	states := make(map[string]diskState, len(pvs))
+	for _, pv := range pvs {
+		state, ok := c.states[pv.Name]
+		if !ok {
+			state = persistentVolumeDiskState(pv)
+		}
+		states[pv.Name] = observeDiskState(state, listing, pv)
+	}
+	c.states = states
+
+	now := time.Now()
+	if now.Sub(c.lastBatch) >= diskStatusResync {
+		for _, pv := range c.nextBatch(pvs, *config.DiskStatus.BatchSize) {
+			if err := c.record(ctx, pv, persistentVolumeDiskState(pv), states[pv.Name], threshold, now); err != nil {
+				return err
+			}
+			c.lastChecked = pv.Name
+		}
+		c.lastBatch = now
+	}
+	return c.updateCondition(ctx, blockingVolumes(pvs, states, threshold, now), threshold)
+}
+
+// observeDiskState returns the state of the disk of the PV in the listing, starting a new
+// state when the status differs from the previous one. PVs created after the listing keep
+// the previous state, as their disk may be missing from it.
+func observeDiskState(previous diskState, listing *diskListing, pv *corev1.PersistentVolume) diskState {
+	// The annotations hold whole seconds
+	listed := listing.listed.Truncate(time.Second)
+	status := diskStatusNotFound
+	if disk, ok := listing.disks[ovirtclient.DiskID(pv.Spec.CSI.VolumeHandle)]; ok {
+		status = disk.Status()
+	} else if !pv.CreationTimestamp.Time.Before(listed) {
+		return previous
+	}
+	if status != previous.status || previous.since.IsZero() {
+		return diskState{status: status, since: listed}
+	}
+	return previous
+}
+
+// blockingVolumes lists the PVs whose disk is locked or illegal for longer than the threshold.
+func blockingVolumes(pvs []*corev1.PersistentVolume, states map[string]diskState, threshold time.Duration, now time.Time) []string {
+	var blocking []string
+	for _, pv := range pvs {
+		state := states[pv.Name]
+		if isDiskUnhealthy(state.status) && now.Sub(state.since) >= threshold {
+			blocking = append(blocking, fmt.Sprintf("%s (%s)", pv.Name, state.status))
+		}
+	}
+	return blocking
+}
+
+// nextBatch returns up to size PVs following the last recorded one, wrapping around.
+func (c *OvirtDiskStatusController) nextBatch(pvs []*corev1.PersistentVolume, size int) []*corev1.PersistentVolume {
+	start := sort.Search(len(pvs), func(i int) bool { return pvs[i].Name > c.lastChecked })
+	var batch []*corev1.PersistentVolume
+	for i := 0; i < len(pvs) && i < size; i++ {
+		batch = append(batch, pvs[(start+i)%len(pvs)])
+	}
+	return batch
+}
+
+// record stores the state of the disk in the PV annotations and reports the changes from the
+// recorded state worth the attention of the users of the volume.
+func (c *OvirtDiskStatusController) record(ctx context.Context, pv *corev1.PersistentVolume, old, state diskState, threshold time.Duration, now time.Time) error {
+	status := state.status
+	if status != old.status {
+		klog.Infof("Disk %s of PV %s is %s, was %s", pv.Spec.CSI.VolumeHandle, pv.Name, status, old.status)
+	}
+	if status != ovirtclient.DiskStatusLocked {
+		c.lockedReported.Delete(pv.Name)
+	}
+
+	disk := pv.Spec.CSI.VolumeHandle
+	switch {
+	case status == ovirtclient.DiskStatusLocked:
+		if now.Sub(state.since) >= threshold && !c.lockedReported.Has(pv.Name) {
+			c.lockedReported.Insert(pv.Name)
+			c.warn(pv, "OvirtDiskLocked", fmt.Sprintf("Disk %s is locked by an oVirt engine operation since %s, the volume may be unavailable", disk, state.since.UTC().Format(time.RFC3339)))
+		}
+	case status == old.status:
+	case status == ovirtclient.DiskStatusIllegal:
+		c.warn(pv, "OvirtDiskIllegal", fmt.Sprintf("Disk %s is illegal in the oVirt engine, the data of the volume may be inconsistent", disk))
+	case status == diskStatusNotFound:
+		c.warn(pv, "OvirtDiskNotFound", fmt.Sprintf("Disk %s does not exist in the oVirt engine", disk))
+	case status == ovirtclient.DiskStatusOK && (old.status == ovirtclient.DiskStatusIllegal || old.status == diskStatusNotFound ||
+		old.status == ovirtclient.DiskStatusLocked && now.Sub(old.since) >= threshold):
+		c.event(pv, corev1.EventTypeNormal, "OvirtDiskRecovered", fmt.Sprintf("Disk %s is %s again in the oVirt engine", disk, status))
+	}
+
+	if status == old.status && state.since.Equal(old.since) {
+		return nil
+	}
+	return annotatePersistentVolume(ctx, c.kubeClient, pv, map[string]string{
+		diskStatusAnnotation:      string(state.status),
+		diskStatusSinceAnnotation: state.since.UTC().Format(time.RFC3339),
+	})
+}
+
+func (c *OvirtDiskStatusController) warn(pv *corev1.PersistentVolume, reason, message string) {
+	c.event(pv, corev1.EventTypeWarning, reason, message)
+}
+
+// event records the event on the PV and, when bound, its PVC.
+func (c *OvirtDiskStatusController) event(pv *corev1.PersistentVolume, eventType, reason, message string) {
+	refs := map[string]*corev1.ObjectReference{
+		metav1.NamespaceDefault: {Kind: "PersistentVolume", APIVersion: "v1", Name: pv.Name, UID: pv.UID},
+	}
+	if claim := pv.Spec.ClaimRef; claim != nil {
+		refs[claim.Namespace] = &corev1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Namespace: claim.Namespace, Name: claim.Name, UID: claim.UID}
+	}
+	for namespace, ref := range refs {
+		recorder := events.NewRecorder(c.kubeClient.CoreV1().Events(namespace), c.eventRecorder.ComponentName(), ref)
+		if eventType == corev1.EventTypeWarning {
+			recorder.Warning(reason, message)
+		} else {
+			recorder.Event(reason, message)
+		}
+	}
+}
+
+func (c *OvirtDiskStatusController) updateCondition(ctx context.Context, blocking []string, threshold time.Duration) error {
+	condition := operatorapi.OperatorCondition{
+		Type:    c.name + operatorapi.OperatorStatusTypeUpgradeable,
+		Status:  operatorapi.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: "No disk of a PV is locked or illegal",
+	}
+	if len(blocking) > 0 {
+		names := blocking
+		if len(names) > maxReportedVolumes {
+			names = append(names[:maxReportedVolumes:maxReportedVolumes], "...")
+		}
+		condition.Status = operatorapi.ConditionFalse
+		condition.Reason = "DisksLockedOrIllegal"
+		condition.Message = fmt.Sprintf("The disks of %d PVs are locked or illegal for more than %s: %s", len(blocking), threshold, strings.Join(names, ", "))
+	}
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// persistentVolumeDiskState returns the state recorded in the PV annotations, PVs not
+// checked yet are assumed to be fine.
+func persistentVolumeDiskState(pv *corev1.PersistentVolume) diskState {
+	state := diskState{status: ovirtclient.DiskStatusOK}
+	if status := pv.Annotations[diskStatusAnnotation]; status != "" {
+		state.status = ovirtclient.DiskStatus(status)
+	}
+	if since, err := time.Parse(time.RFC3339, pv.Annotations[diskStatusSinceAnnotation]); err == nil {
+		state.since = since
+	}
+	return state
+}
+
+func isDiskUnhealthy(status ovirtclient.DiskStatus) bool {
+	return status == ovirtclient.DiskStatusLocked || status == ovirtclient.DiskStatusIllegal
+}
@@ -0,0 +1,195 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// listedDisk is a disk of a listing, the disk status controller only reads its ID and status.
+type listedDisk struct {
+	ovirtclient.Disk
+	id     ovirtclient.DiskID
+	status ovirtclient.DiskStatus
+}
+
+func (d listedDisk) ID() ovirtclient.DiskID         { return d.id }
+func (d listedDisk) Status() ovirtclient.DiskStatus { return d.status }
+
+func TestDiskStatusSync(t *testing.T) {
+	listed := time.Now().Truncate(time.Second)
+	longAgo := listed.Add(-time.Hour)
+
+	testCases := []struct {
+		name              string
+		pvs               []*corev1.PersistentVolume
+		disks             map[string]ovirtclient.DiskStatus
+		expectUpgradeable opv1.ConditionStatus
+		// expectAnnotations are the disk status annotations by PV after the sync
+		expectAnnotations map[string]string
+		expectEvents      []string
+	}{
+		{
+			name: "healthy disks",
+			pvs:  []*corev1.PersistentVolume{newDriverPV("pv-a", "disk-a", longAgo, nil)},
+			disks: map[string]ovirtclient.DiskStatus{
+				"disk-a": ovirtclient.DiskStatusOK,
+			},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": "ok"},
+		},
+		{
+			name: "a disk locked for long blocks upgrades outside the batch",
+			pvs: []*corev1.PersistentVolume{
+				newDriverPV("pv-a", "disk-a", longAgo, nil),
+				newDriverPV("pv-b", "disk-b", longAgo, &diskState{status: ovirtclient.DiskStatusLocked, since: longAgo}),
+			},
+			disks: map[string]ovirtclient.DiskStatus{
+				"disk-a": ovirtclient.DiskStatusOK,
+				"disk-b": ovirtclient.DiskStatusLocked,
+			},
+			expectUpgradeable: opv1.ConditionFalse,
+			expectAnnotations: map[string]string{"pv-a": "ok", "pv-b": "locked"},
+			expectEvents:      []string{"OvirtDiskLocked"},
+		},
+		{
+			name: "a disk just locked does not block upgrades",
+			pvs:  []*corev1.PersistentVolume{newDriverPV("pv-a", "disk-a", longAgo, nil)},
+			disks: map[string]ovirtclient.DiskStatus{
+				"disk-a": ovirtclient.DiskStatusLocked,
+			},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": "locked"},
+		},
+		{
+			name: "an illegal disk outside the batch is recorded on its turn",
+			pvs: []*corev1.PersistentVolume{
+				newDriverPV("pv-a", "disk-a", longAgo, nil),
+				newDriverPV("pv-b", "disk-b", longAgo, nil),
+			},
+			disks: map[string]ovirtclient.DiskStatus{
+				"disk-a": ovirtclient.DiskStatusOK,
+				"disk-b": ovirtclient.DiskStatusIllegal,
+			},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": "ok", "pv-b": "illegal"},
+			expectEvents:      []string{"OvirtDiskIllegal"},
+		},
+		{
+			name:              "a missing disk is reported",
+			pvs:               []*corev1.PersistentVolume{newDriverPV("pv-a", "disk-a", longAgo, nil)},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": "notfound"},
+			expectEvents:      []string{"OvirtDiskNotFound"},
+		},
+		{
+			name:              "the disk of a PV created after the listing is not missing",
+			pvs:               []*corev1.PersistentVolume{newDriverPV("pv-a", "disk-a", listed, nil)},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": ""},
+		},
+		{
+			name: "a recovered disk is reported",
+			pvs:  []*corev1.PersistentVolume{newDriverPV("pv-a", "disk-a", longAgo, &diskState{status: ovirtclient.DiskStatusIllegal, since: longAgo})},
+			disks: map[string]ovirtclient.DiskStatus{
+				"disk-a": ovirtclient.DiskStatusOK,
+			},
+			expectUpgradeable: opv1.ConditionTrue,
+			expectAnnotations: map[string]string{"pv-a": "ok"},
+			expectEvents:      []string{"OvirtDiskRecovered"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, pv := range tc.pvs {
+				objects = append(objects, pv)
+			}
+			kubeClient, kubeInformers := newFakeKubeInformers(t, objects...)
+			operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{}, nil)
+			batchSize := 1
+			config := &OperatorConfig{DiskStatus: DiskStatusConfig{
+				Threshold: &metav1.Duration{Duration: 15 * time.Minute},
+				BatchSize: &batchSize,
+			}}
+			listing := &diskListing{disks: map[ovirtclient.DiskID]ovirtclient.Disk{}, listed: listed}
+			for id, status := range tc.disks {
+				listing.disks[ovirtclient.DiskID(id)] = listedDisk{id: ovirtclient.DiskID(id), status: status}
+			}
+			c := &OvirtDiskStatusController{
+				name:           "OvirtDiskStatusController",
+				operatorClient: operatorClient,
+				kubeClient:     kubeClient,
+				pvLister:       kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				getConfig:      func() (*OperatorConfig, error) { return config, nil },
+				listDisks:      func(context.Context) (*diskListing, error) { return listing, nil },
+				eventRecorder:  events.NewInMemoryRecorder("test"),
+				states:         map[string]diskState{},
+				lockedReported: sets.NewString(),
+			}
+
+			// The first sync records pv-a, the second one, a resync later, pv-b
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			reason := "AsExpected"
+			if tc.expectUpgradeable == opv1.ConditionFalse {
+				reason = "DisksLockedOrIllegal"
+			}
+			expectOperatorCondition(t, operatorClient, "OvirtDiskStatusControllerUpgradeable", tc.expectUpgradeable, reason)
+			c.lastBatch = c.lastBatch.Add(-diskStatusResync)
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for name, expected := range tc.expectAnnotations {
+				pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status := pv.Annotations[diskStatusAnnotation]; status != expected {
+					t.Errorf("expected PV %s annotated %q, got %q", name, expected, status)
+				}
+			}
+			eventList, err := kubeClient.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reasons := sets.NewString()
+			for _, event := range eventList.Items {
+				reasons.Insert(event.Reason)
+			}
+			if !reasons.Equal(sets.NewString(tc.expectEvents...)) {
+				t.Errorf("expected events %v, got %v", tc.expectEvents, reasons.List())
+			}
+		})
+	}
+}
+
+// newDriverPV returns a PV of the driver with the disk state recorded in its annotations.
+func newDriverPV(name, diskID string, created time.Time, state *diskState) *corev1.PersistentVolume {
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: instanceName, VolumeHandle: diskID},
+			},
+		},
+	}
+	if state != nil {
+		pv.Annotations = map[string]string{
+			diskStatusAnnotation:      string(state.status),
+			diskStatusSinceAnnotation: state.since.UTC().Format(time.RFC3339),
+		}
+	}
+	return pv
+}
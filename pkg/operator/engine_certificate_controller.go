@@ -0,0 +1,168 @@
+package operator
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+const (
+	engineCertificateConditionType = "OvirtEngineCertificatesExpiring"
+
+	// engineCertificateCheckInterval is the interval the engine certificates are inspected in
+	engineCertificateCheckInterval = time.Hour
+)
+
+// OvirtEngineCertificateController inspects the certificate chain presented by the engine and
+// the engine CA bundle, the ovirt_ca_bundle of the credentials Secret. It exports their expiry, reports the certificates expiring
+// within engine.certificateExpiryWarning in the OvirtEngineCertificatesExpiring condition, and
+// sets <name>Degraded when the presented chain no longer validates against the CA bundle, as
+// every CSI component then fails to connect to the engine.
+type OvirtEngineCertificateController struct {
+	name                  string
+	operatorClient        v1helpers.OperatorClient
+	getConfig             func() (*OperatorConfig, error)
+	getConnectionSettings func() (*ovirt.ConnectionSettings, error)
+	eventRecorder         events.Recorder
+}
+
+func NewOvirtEngineCertificateController(
+	operatorClient v1helpers.OperatorClient,
+	configInformers configinformers.SharedInformerFactory,
+	trustedCAConfigMapInformer corev1informers.ConfigMapInformer,
+	credentialsSecretInformer corev1informers.SecretInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	getConnectionSettings func() (*ovirt.ConnectionSettings, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtEngineCertificateController{
+		name:                  "OvirtEngineCertificateController",
+		operatorClient:        operatorClient,
+		getConfig:             getConfig,
+		getConnectionSettings: getConnectionSettings,
+		eventRecorder:         eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithInformers(
+		operatorClient.Informer(),
+		configInformers.Config().V1().APIServers().Informer(),
+		configInformers.Config().V1().Proxies().Informer(),
+		trustedCAConfigMapInformer.Informer(),
+		credentialsSecretInformer.Informer(),
+		configMapInformer.Informer(),
+	).ResyncEvery(engineCertificateCheckInterval).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtEngineCertificateController) sync(ctx context.Context, _ factory.SyncContext) error {
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping engine certificate check: %v", err)
+		return nil
+	}
+	settings, err := c.getConnectionSettings()
+	if err != nil {
+		return err
+	}
+	certificates, inspectErr := ovirt.InspectEngineCertificates(ctx, settings)
+	if certificates == nil {
+		return inspectErr
+	}
+
+	engineCertificateExpiry.Reset()
+	for _, cert := range certificates.Chain {
+		engineCertificateExpiry.WithLabelValues("engine", cert.Subject.String(), cert.SerialNumber.String()).Set(float64(cert.NotAfter.Unix()))
+	}
+	for _, cert := range certificates.CABundle {
+		engineCertificateExpiry.WithLabelValues("ca_bundle", cert.Subject.String(), cert.SerialNumber.String()).Set(float64(cert.NotAfter.Unix()))
+	}
+	if inspectErr != nil {
+		// the presented chain is unknown, keep the conditions of the last inspection
+		return fmt.Errorf("failed to inspect the engine certificates: %w", inspectErr)
+	}
+	if err := c.updateExpiryCondition(ctx, certificates, config.Engine.CertificateExpiryWarning.Duration); err != nil {
+		return err
+	}
+	if len(certificates.Chain) == 0 {
+		// plain HTTP engine URL
+		return c.updateDegradedCondition(ctx, nil)
+	}
+	return c.updateDegradedCondition(ctx, certificates.VerifyError)
+}
+
+// updateExpiryCondition reports the certificates which expire within window.
+func (c *OvirtEngineCertificateController) updateExpiryCondition(ctx context.Context, certificates *ovirt.EngineCertificates, window time.Duration) error {
+	now := time.Now()
+	var expired, expiring []string
+	check := func(source string, certs []*x509.Certificate) {
+		for _, cert := range certs {
+			switch {
+			case now.After(cert.NotAfter):
+				expired = append(expired, fmt.Sprintf("%s certificate %q expired at %s", source, cert.Subject.String(), cert.NotAfter.UTC().Format(time.RFC3339)))
+			case cert.NotAfter.Sub(now) < window:
+				expiring = append(expiring, fmt.Sprintf("%s certificate %q expires at %s", source, cert.Subject.String(), cert.NotAfter.UTC().Format(time.RFC3339)))
+			}
+		}
+	}
+	check("engine", certificates.Chain)
+	check(engineCABundleKey, certificates.CABundle)
+
+	condition := operatorapi.OperatorCondition{
+		Type:    engineCertificateConditionType,
+		Status:  operatorapi.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: fmt.Sprintf("No engine certificate expires within %s", window),
+	}
+	switch {
+	case len(expired) > 0:
+		condition.Status, condition.Reason = operatorapi.ConditionTrue, "CertificatesExpired"
+		condition.Message = strings.Join(append(expired, expiring...), "; ")
+	case len(expiring) > 0:
+		condition.Status, condition.Reason = operatorapi.ConditionTrue, "CertificatesExpiring"
+		condition.Message = strings.Join(expiring, "; ")
+	}
+
+	_, oldStatus, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	if previous := v1helpers.FindOperatorCondition(oldStatus.Conditions, condition.Type); condition.Status == operatorapi.ConditionTrue &&
+		(previous == nil || previous.Status != condition.Status || previous.Reason != condition.Reason) {
+		c.eventRecorder.Warning("EngineCertificateExpiring", condition.Message)
+	}
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// updateDegradedCondition reports a presented chain which does not validate against the CA
+// bundle.
+func (c *OvirtEngineCertificateController) updateDegradedCondition(ctx context.Context, verifyErr error) error {
+	condition := operatorapi.OperatorCondition{
+		Type:   c.name + operatorapi.OperatorStatusTypeDegraded,
+		Status: operatorapi.ConditionFalse,
+	}
+	engineCertificateChainValid.Set(1)
+	if verifyErr != nil {
+		engineCertificateChainValid.Set(0)
+		condition.Status = operatorapi.ConditionTrue
+		condition.Reason = "EngineCertificateUntrusted"
+		condition.Message = fmt.Sprintf("The certificate chain presented by the oVirt engine does not validate against %s: %v", engineCABundleKey, verifyErr)
+	}
+	_, updated, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	if updated && verifyErr != nil {
+		c.eventRecorder.Warning("EngineCertificateUntrusted", condition.Message)
+	}
+	return err
+}
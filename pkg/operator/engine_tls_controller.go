@@ -0,0 +1,83 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+)
+
+const engineTLSCheckInterval = 10 * time.Minute
+
+// OvirtEngineTLSController checks that the engine negotiates a TLS handshake compliant
+// with the cluster TLS security profile and reports the result in <name>Degraded. Other
+// failures, e.g. an unreachable engine, leave the condition as it is.
+type OvirtEngineTLSController struct {
+	name                  string
+	operatorClient        v1helpers.OperatorClient
+	getConnectionSettings func() (*ovirt.ConnectionSettings, error)
+	eventRecorder         events.Recorder
+}
+
+func NewOvirtEngineTLSController(
+	operatorClient v1helpers.OperatorClient,
+	configInformers configinformers.SharedInformerFactory,
+	trustedCAConfigMapInformer corev1informers.ConfigMapInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConnectionSettings func() (*ovirt.ConnectionSettings, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtEngineTLSController{
+		name:                  "OvirtEngineTLSController",
+		operatorClient:        operatorClient,
+		getConnectionSettings: getConnectionSettings,
+		eventRecorder:         eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithInformers(
+		operatorClient.Informer(),
+		configInformers.Config().V1().APIServers().Informer(),
+		configInformers.Config().V1().Proxies().Informer(),
+		trustedCAConfigMapInformer.Informer(),
+		configMapInformer.Informer(),
+	).ResyncEvery(engineTLSCheckInterval).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtEngineTLSController) sync(ctx context.Context, syncCtx factory.SyncContext) error {
+	degradedCondition := operatorapi.OperatorCondition{
+		Type:   c.name + operatorapi.OperatorStatusTypeDegraded,
+		Status: operatorapi.ConditionFalse,
+	}
+
+	settings, err := c.getConnectionSettings()
+	if err != nil {
+		return err
+	}
+	err = ovirt.CheckEngineTLS(ctx, settings)
+	var complianceErr *ovirt.TLSComplianceError
+	if err != nil && !errors.As(err, &complianceErr) {
+		// e.g. the engine is unreachable, the compliance is unknown
+		return fmt.Errorf("failed to check the engine TLS: %w", err)
+	}
+	if err != nil {
+		klog.Errorf("engine TLS check failed: %v", err)
+		degradedCondition.Status = operatorapi.ConditionTrue
+		degradedCondition.Reason = "EngineTLSCheckFailed"
+		degradedCondition.Message = fmt.Sprintf("The oVirt engine connection does not comply with the cluster TLS security profile: %v", err)
+	}
+
+	_, updated, updateErr := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(degradedCondition))
+	if updated && err != nil {
+		syncCtx.Recorder().Warning("EngineTLSCheckFailed", degradedCondition.Message)
+	}
+	return updateErr
+}
@@ -0,0 +1,151 @@
+package operator
+
+import (
+	"strings"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	dc "github.com/openshift/library-go/pkg/operator/deploymentcontroller"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/ovirt/csi-driver-operator/assets"
+)
+
+const (
+	// Secret in the control plane namespace with the kubeconfig of the guest cluster,
+	// as provided by HyperShift
+	guestKubeConfigSecret    = "service-network-admin-kubeconfig"
+	guestKubeConfigSecretKey = "kubeconfig"
+	guestKubeConfigVolume    = "hosted-kubeconfig"
+	guestKubeConfigMountPath = "/etc/hosted-kubernetes"
+	guestKubeConfigArg       = "--kubeconfig=" + guestKubeConfigMountPath + "/" + guestKubeConfigSecretKey
+)
+
+// staticAssets are the static resources of the operand.
+var staticAssets = []string{
+	"controller_sa.yaml",
+	"node_sa.yaml",
+	"cabundle_cm.yaml",
+	"rbac/attacher_role.yaml",
+	"rbac/privileged_role.yaml",
+	"rbac/provisioner_role.yaml",
+	"rbac/resizer_role.yaml",
+	"rbac/snapshotter_role.yaml",
+	"rbac/kube_rbac_proxy_role.yaml",
+	"rbac/prometheus_role.yaml",
+	"rbac/attacher_binding.yaml",
+	"rbac/node_privileged_binding.yaml",
+	"rbac/controller_privileged_binding.yaml",
+	"rbac/provisioner_binding.yaml",
+	"rbac/resizer_binding.yaml",
+	"rbac/snapshotter_binding.yaml",
+	"rbac/kube_rbac_proxy_binding.yaml",
+	"rbac/node_kube_rbac_proxy_binding.yaml",
+	"rbac/prometheus_rolebinding.yaml",
+	"controller_pdb.yaml",
+	"service.yaml",
+	"node_service.yaml",
+	"csidriver.yaml",
+}
+
+// nodeServiceMonitorAsset is applied to the cluster running the node DaemonSet apart from the
+// static resources, its CRD may be missing
+const nodeServiceMonitorAsset = "node_servicemonitor.yaml"
+
+// controlPlaneAssets are the static resources which accompany the controller Deployment.
+// With a hosted control plane they are applied to the management cluster, the sharedAssets
+// among them to the guest cluster as well.
+var controlPlaneAssets = sets.NewString(
+	"controller_sa.yaml",
+	"cabundle_cm.yaml",
+	"rbac/kube_rbac_proxy_role.yaml",
+	"rbac/prometheus_role.yaml",
+	"rbac/kube_rbac_proxy_binding.yaml",
+	"rbac/prometheus_rolebinding.yaml",
+	"controller_pdb.yaml",
+	"service.yaml",
+)
+
+// sharedAssets are the control plane assets needed in both clusters: the trusted CA bundle and
+// the RBAC the metrics of the node plugin are scraped with.
+var sharedAssets = sets.NewString(
+	"cabundle_cm.yaml",
+	"rbac/kube_rbac_proxy_role.yaml",
+	"rbac/prometheus_role.yaml",
+	"rbac/prometheus_rolebinding.yaml",
+)
+
+// guestStaticAssets returns the static resources applied to the guest cluster of a hosted control plane.
+func guestStaticAssets() []string {
+	var files []string
+	for _, file := range staticAssets {
+		if !controlPlaneAssets.Has(file) || sharedAssets.Has(file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// controlPlaneStaticAssets returns the static resources applied to the management cluster of a
+// hosted control plane.
+func controlPlaneStaticAssets() []string {
+	var files []string
+	for _, file := range staticAssets {
+		if controlPlaneAssets.Has(file) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// loadGuestKubeConfig loads the REST config of the guest cluster of a hosted control plane.
+func loadGuestKubeConfig(path string) (*rest.Config, error) {
+	return clientcmd.BuildConfigFromFlags("", path)
+}
+
+// namespacedAssets returns an AssetFunc which moves the assets from the default namespace
+// into the given one.
+func namespacedAssets(namespace string) resourceapply.AssetFunc {
+	return func(name string) ([]byte, error) {
+		manifest, err := assets.ReadFile(name)
+		if err != nil || namespace == defaultNamespace {
+			return manifest, err
+		}
+		return []byte(strings.ReplaceAll(string(manifest), defaultNamespace, namespace)), nil
+	}
+}
+
+// withHostedControlPlaneDeploymentHook makes the sidecars of a controller Deployment running
+// in the management cluster talk to the guest cluster through the mounted guest kubeconfig.
+// The Deployment does not need the host network outside of the guest cluster. The node selector
+// is cleared by the WithControlPlaneTopologyHook, which the library adds to every controller
+// Deployment.
+func withHostedControlPlaneDeploymentHook() dc.DeploymentHookFunc {
+	return func(_ *opv1.OperatorSpec, deployment *appsv1.Deployment) error {
+		podSpec := &deployment.Spec.Template.Spec
+		podSpec.HostNetwork = false
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: guestKubeConfigVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: guestKubeConfigSecret},
+			},
+		})
+		for i := range podSpec.Containers {
+			container := &podSpec.Containers[i]
+			switch container.Name {
+			case provisionerContainer, attacherContainer, resizerContainer:
+				container.Args = append(container.Args, guestKubeConfigArg)
+				container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+					Name:      guestKubeConfigVolume,
+					MountPath: guestKubeConfigMountPath,
+					ReadOnly:  true,
+				})
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,610 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+const (
+	// populatedFromAnnotation on the PV names the OvirtImagePopulator it was populated from
+	populatedFromAnnotation = "csi.ovirt.org/populated-from"
+
+	// imageSourceLabel on the source pods names their OvirtImagePopulator
+	imageSourceLabel = "csi.ovirt.org/image-populator"
+
+	// operatorImageEnv is the image of the operator, it runs serve-image in the source pods
+	operatorImageEnv = "OPERATOR_IMAGE"
+
+	// populatorProgressInterval is how often the progress of running uploads is reported
+	populatorProgressInterval = 10 * time.Second
+
+	// populatorRetryInterval is the time a failed upload waits before it is started again
+	populatorRetryInterval = time.Minute
+
+	fsTypeParameter = "csi.storage.k8s.io/fstype"
+)
+
+// imageUpload is an upload started for a PVC. It runs in the background and outlives syncs.
+type imageUpload struct {
+	cancel   context.CancelFunc
+	reader   *imageReader
+	progress ovirtclient.UploadImageProgress
+	// err and finished are set once an upload failed, it is started again after
+	// populatorRetryInterval
+	err      error
+	finished time.Time
+	// diskID is set once the PV is created, the upload is kept until the PVC is bound
+	diskID ovirtclient.DiskID
+}
+
+// imageSourcePending is returned while the source pod of a PVC is not ready yet.
+type imageSourcePending struct {
+	message string
+}
+
+func (e *imageSourcePending) Error() string {
+	return e.message
+}
+
+// OvirtImagePopulatorController is the volume populator of the OvirtImagePopulator resources.
+// An unbound PVC of a StorageClass of the driver with a dataSourceRef to an OvirtImagePopulator
+// is ignored by the provisioner. The controller uploads the source image of the populator to a
+// new disk in the storage domain of the StorageClass, extends it to the requested size and
+// creates a PV of the disk pre-bound to the PVC. The image, on a PVC or at a URL, is served by a
+// pod running serve-image in the namespace of the PVC, read through the API server proxy. The
+// operator never fetches a URL itself, so a populator cannot reach what only the operator can.
+// The progress is reported per PVC in the status of the populator.
+type OvirtImagePopulatorController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	dynamicClient      dynamic.Interface
+	populatorLister    cache.GenericLister
+	pvcLister          corelisters.PersistentVolumeClaimLister
+	pvLister           corelisters.PersistentVolumeLister
+	scLister           storagelisters.StorageClassLister
+	getConfig          func() (*OperatorConfig, error)
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	// openSource reads the image served by the source pod
+	openSource    func(ctx context.Context, namespace, pod string) (*imageReader, error)
+	operatorImage string
+	eventRecorder events.Recorder
+
+	lock    sync.Mutex
+	uploads map[types.UID]*imageUpload
+}
+
+func NewOvirtImagePopulatorController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	populatorInformer informers.GenericInformer,
+	pvcInformer corev1informers.PersistentVolumeClaimInformer,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	scInformer storagev1informers.StorageClassInformer,
+	getConfig func() (*OperatorConfig, error),
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtImagePopulatorController{
+		name:               "OvirtImagePopulatorController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		dynamicClient:      dynamicClient,
+		populatorLister:    populatorInformer.Lister(),
+		pvcLister:          pvcInformer.Lister(),
+		pvLister:           pvInformer.Lister(),
+		scLister:           scInformer.Lister(),
+		getConfig:          getConfig,
+		ovirtClientFactory: ovirtClientFactory,
+		openSource: func(ctx context.Context, namespace, pod string) (*imageReader, error) {
+			return newPodImageReader(ctx, kubeClient, namespace, pod)
+		},
+		operatorImage: os.Getenv(operatorImageEnv),
+		eventRecorder: eventRecorder,
+		uploads:       map[types.UID]*imageUpload{},
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		populatorInformer.Informer(),
+		pvcInformer.Informer(),
+		pvInformer.Informer(),
+		scInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtImagePopulatorController) sync(ctx context.Context, syncCtx factory.SyncContext) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	objs, err := c.populatorLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	populators := map[string]*v1alpha1.OvirtImagePopulator{}
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		populator := &v1alpha1.OvirtImagePopulator{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, populator); err != nil {
+			klog.Errorf("Failed to decode OvirtImagePopulator %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		populators[populator.Namespace+"/"+populator.Name] = populator
+	}
+
+	pvcs, err := c.pvcLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	volumes := map[string][]v1alpha1.OvirtImagePopulatorVolumeStatus{}
+	pending := map[types.UID]bool{}
+	var errs []error
+	for _, pvc := range pvcs {
+		ref := pvc.Spec.DataSourceRef
+		if ref == nil || ref.APIGroup == nil || *ref.APIGroup != v1alpha1.GroupName || ref.Kind != v1alpha1.OvirtImagePopulatorKind {
+			continue
+		}
+		key := pvc.Namespace + "/" + ref.Name
+		populator, ok := populators[key]
+		if !ok {
+			klog.V(4).Infof("PVC %s/%s waits for OvirtImagePopulator %s", pvc.Namespace, pvc.Name, key)
+			continue
+		}
+		if pvc.Spec.VolumeName != "" {
+			// Populated or bound by someone else, keep the last status
+			if status := findVolumeStatus(populator.Status.Volumes, pvc.Name); status != nil {
+				volumes[key] = append(volumes[key], *status)
+			}
+			continue
+		}
+		if pvc.Spec.StorageClassName == nil {
+			continue
+		}
+		sc, err := c.scLister.Get(*pvc.Spec.StorageClassName)
+		if err != nil || sc.Provisioner != instanceName || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		pending[pvc.UID] = true
+		status, err := c.populate(ctx, pvc, sc, populator)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PVC %s/%s: %w", pvc.Namespace, pvc.Name, err))
+		}
+		volumes[key] = append(volumes[key], status)
+	}
+
+	// Cancel the uploads of PVCs which were deleted or bound meanwhile, forget the finished ones
+	for uid, upload := range c.uploads {
+		if !pending[uid] {
+			upload.stop()
+			delete(c.uploads, uid)
+		}
+	}
+
+	for key, populator := range populators {
+		if err := c.updateStatus(ctx, populator, volumes[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(pending) > 0 {
+		syncCtx.Queue().AddAfter(syncCtx.QueueKey(), populatorProgressInterval)
+	}
+	return v1helpers.NewMultiLineAggregate(errs)
+}
+
+// populate advances the population of the PVC and returns its status.
+func (c *OvirtImagePopulatorController) populate(ctx context.Context, pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, populator *v1alpha1.OvirtImagePopulator) (v1alpha1.OvirtImagePopulatorVolumeStatus, error) {
+	status := v1alpha1.OvirtImagePopulatorVolumeStatus{ClaimName: pvc.Name, Phase: v1alpha1.OvirtImagePopulatorPending}
+	pvName := populatedVolumeName(pvc)
+	if pv, err := c.pvLister.Get(pvName); err == nil {
+		// Populated before a restart of the operator, the PVC is not bound yet
+		status.Phase, status.DiskID, status.Progress = v1alpha1.OvirtImagePopulatorSucceeded, pv.Spec.CSI.VolumeHandle, "100%"
+		return status, nil
+	}
+
+	upload, ok := c.uploads[pvc.UID]
+	if ok && upload.diskID != "" {
+		status.Phase, status.DiskID, status.Progress = v1alpha1.OvirtImagePopulatorSucceeded, string(upload.diskID), "100%"
+		return status, nil
+	}
+	if ok && upload.err != nil && time.Since(upload.finished) < populatorRetryInterval {
+		status.Phase, status.Message = v1alpha1.OvirtImagePopulatorFailed, upload.err.Error()
+		return status, nil
+	}
+	if !ok || upload.err != nil {
+		var err error
+		upload, err = c.startUpload(ctx, pvc, sc, populator)
+		if pending, ok := err.(*imageSourcePending); ok {
+			status.Message = pending.message
+			return status, nil
+		}
+		if err != nil {
+			klog.Errorf("Failed to start the upload for PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+			c.uploads[pvc.UID] = &imageUpload{err: err, finished: time.Now()}
+			status.Phase, status.Message = v1alpha1.OvirtImagePopulatorFailed, err.Error()
+			return status, nil
+		}
+		c.uploads[pvc.UID] = upload
+		klog.Infof("Started the upload of OvirtImagePopulator %s/%s for PVC %s", populator.Namespace, populator.Name, pvc.Name)
+	}
+
+	uploaded, total := upload.progress.UploadedBytes(), upload.progress.TotalBytes()
+	status.Phase = v1alpha1.OvirtImagePopulatorUploading
+	status.UploadedBytes, status.TotalBytes = int64(uploaded), int64(total)
+	if total > 0 {
+		status.Progress = fmt.Sprintf("%d%%", uploaded*100/total)
+	}
+	select {
+	case <-upload.progress.Done():
+	default:
+		return status, nil
+	}
+
+	if err := upload.progress.Err(); err != nil {
+		upload.stop()
+		upload.err, upload.finished = err, time.Now()
+		c.eventRecorder.Warningf("ImagePopulationFailed", "Upload of OvirtImagePopulator %s/%s for PVC %s failed: %v", populator.Namespace, populator.Name, pvc.Name, err)
+		status.Phase, status.Message = v1alpha1.OvirtImagePopulatorFailed, err.Error()
+		return status, nil
+	}
+	disk := upload.progress.Disk()
+	status.DiskID, status.Progress = string(disk.ID()), "100%"
+	if err := c.createPersistentVolume(ctx, pvc, sc, populator, disk); err != nil {
+		status.Message = err.Error()
+		return status, err
+	}
+	upload.stop()
+	upload.diskID = disk.ID()
+	err := c.kubeClient.CoreV1().Pods(pvc.Namespace).Delete(ctx, imageSourcePodName(pvc), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.Warningf("Failed to delete the image source pod of PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+	}
+	c.eventRecorder.Eventf("ImagePopulated", "Populated PVC %s/%s from OvirtImagePopulator %s with disk %s", pvc.Namespace, pvc.Name, populator.Name, disk.ID())
+	status.Phase = v1alpha1.OvirtImagePopulatorSucceeded
+	return status, nil
+}
+
+// startUpload starts the upload of the image to a new disk named like the PV of the PVC.
+func (c *OvirtImagePopulatorController) startUpload(ctx context.Context, pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, populator *v1alpha1.OvirtImagePopulator) (*imageUpload, error) {
+	spec := populator.Spec
+	if (spec.URL == "") == (spec.PersistentVolumeClaim == nil) {
+		return nil, fmt.Errorf("exactly one of url and persistentVolumeClaim must be set")
+	}
+	if spec.URL != "" {
+		if err := validateImageURL(spec.URL); err != nil {
+			return nil, err
+		}
+	} else if err := validateVolumePath(spec.PersistentVolumeClaim.Path); err != nil {
+		return nil, err
+	}
+
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	storageDomainID, err := findStorageDomainID(ctx, ovirtClient, sc.Parameters["storageDomainName"])
+	if err != nil {
+		return nil, err
+	}
+	alias := populatedVolumeName(pvc)
+	if err := removeDisksByAlias(ctx, ovirtClient, alias); err != nil {
+		return nil, err
+	}
+
+	pod, err := c.ensureSourcePod(ctx, pvc, populator)
+	if err != nil {
+		return nil, err
+	}
+	// The upload outlives the sync, it is cancelled when the PVC is deleted
+	uploadCtx, cancel := context.WithCancel(context.Background())
+	reader, err := c.openSource(uploadCtx, pvc.Namespace, pod)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	format, sparse := ovirtclient.ImageFormatCow, true
+	if sc.Parameters["thinProvisioning"] == "false" {
+		format, sparse = ovirtclient.ImageFormatRaw, false
+	}
+	params := ovirtclient.CreateDiskParams().MustWithAlias(alias).MustWithSparse(sparse)
+	progress, err := ovirtClient.StartUploadToNewDisk(storageDomainID, format, uint64(reader.size), params, reader, ovirtclient.ContextStrategy(uploadCtx))
+	if err != nil {
+		cancel()
+		_ = reader.Close()
+		return nil, fmt.Errorf("failed to start the upload: %w", err)
+	}
+	return &imageUpload{cancel: cancel, reader: reader, progress: progress}, nil
+}
+
+// ensureSourcePod creates the pod serving the source image and returns its name once it is
+// ready. The pod is owned by the populated PVC.
+func (c *OvirtImagePopulatorController) ensureSourcePod(ctx context.Context, pvc *corev1.PersistentVolumeClaim, populator *v1alpha1.OvirtImagePopulator) (string, error) {
+	name := imageSourcePodName(pvc)
+	pod, err := c.kubeClient.CoreV1().Pods(pvc.Namespace).Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if c.operatorImage == "" {
+			return "", fmt.Errorf("%s is not set, images cannot be populated", operatorImageEnv)
+		}
+		pod = imageSourcePod(name, c.operatorImage, pvc, populator)
+		if _, err := c.kubeClient.CoreV1().Pods(pvc.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return "", fmt.Errorf("failed to create pod %s/%s: %w", pvc.Namespace, name, err)
+		}
+		return "", &imageSourcePending{message: fmt.Sprintf("Waiting for the source pod %s", name)}
+	case err != nil:
+		return "", err
+	case pod.Status.Phase == corev1.PodFailed:
+		message := string(pod.Status.Phase)
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				message = status.State.Terminated.Message
+			}
+		}
+		// Deleted so that the next attempt starts a new one
+		if err := c.kubeClient.CoreV1().Pods(pvc.Namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to delete pod %s/%s: %v", pvc.Namespace, name, err)
+		}
+		return "", fmt.Errorf("source pod %s failed: %s", name, message)
+	case pod.Status.Phase != corev1.PodRunning || !isPodReady(pod):
+		return "", &imageSourcePending{message: fmt.Sprintf("Waiting for the source pod %s", name)}
+	}
+	return name, nil
+}
+
+// createPersistentVolume extends the disk to the requested size and creates its PV pre-bound
+// to the PVC, as the provisioner would have.
+func (c *OvirtImagePopulatorController) createPersistentVolume(ctx context.Context, pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, populator *v1alpha1.OvirtImagePopulator, disk ovirtclient.Disk) error {
+	size := disk.ProvisionedSize()
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if request := uint64(requested.Value()); size < request {
+		ovirtClient, err := c.ovirtClientFactory()
+		if err != nil {
+			return fmt.Errorf("failed to create oVirt client (%w)", err)
+		}
+		params := ovirtclient.UpdateDiskParams().MustWithProvisionedSize(request)
+		if _, err := ovirtClient.UpdateDisk(disk.ID(), params, ovirtclient.ContextStrategy(ctx)); err != nil {
+			return fmt.Errorf("failed to extend disk %s to %d bytes: %w", disk.ID(), request, err)
+		}
+		size = request
+	}
+
+	volumeMode := corev1.PersistentVolumeFilesystem
+	if pvc.Spec.VolumeMode != nil {
+		volumeMode = *pvc.Spec.VolumeMode
+	}
+	csiSource := &corev1.CSIPersistentVolumeSource{
+		Driver:       instanceName,
+		VolumeHandle: string(disk.ID()),
+	}
+	if volumeMode == corev1.PersistentVolumeFilesystem {
+		csiSource.FSType = sc.Parameters[fsTypeParameter]
+		if csiSource.FSType == "" {
+			csiSource.FSType = "ext4"
+			if config, err := c.getConfig(); err == nil {
+				csiSource.FSType = config.Controller.Provisioner.DefaultFSType
+			}
+		}
+	}
+	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
+	if sc.ReclaimPolicy != nil {
+		reclaimPolicy = *sc.ReclaimPolicy
+	}
+
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: populatedVolumeName(pvc),
+			Annotations: map[string]string{
+				populatedFromAnnotation: populator.Namespace + "/" + populator.Name,
+				provisionedByAnnotation: instanceName,
+			},
+		},
+		Spec: corev1.PersistentVolumeSpec{
+			Capacity: corev1.ResourceList{
+				corev1.ResourceStorage: *resource.NewQuantity(int64(size), resource.BinarySI),
+			},
+			AccessModes:                   pvc.Spec.AccessModes,
+			PersistentVolumeReclaimPolicy: reclaimPolicy,
+			StorageClassName:              sc.Name,
+			MountOptions:                  sc.MountOptions,
+			VolumeMode:                    &volumeMode,
+			PersistentVolumeSource:        corev1.PersistentVolumeSource{CSI: csiSource},
+			ClaimRef: &corev1.ObjectReference{
+				Kind:       "PersistentVolumeClaim",
+				APIVersion: "v1",
+				Namespace:  pvc.Namespace,
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			},
+		},
+	}
+	_, err := c.kubeClient.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create PV %s: %w", pv.Name, err)
+	}
+	klog.Infof("Created PV %s with disk %s for PVC %s/%s", pv.Name, disk.ID(), pvc.Namespace, pvc.Name)
+	return nil
+}
+
+func (c *OvirtImagePopulatorController) updateStatus(ctx context.Context, populator *v1alpha1.OvirtImagePopulator, volumes []v1alpha1.OvirtImagePopulatorVolumeStatus) error {
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].ClaimName < volumes[j].ClaimName
+	})
+	if equality.Semantic.DeepEqual(populator.Status.Volumes, volumes) {
+		return nil
+	}
+	updated := populator.DeepCopy()
+	updated.Status.Volumes = volumes
+	updated.APIVersion, updated.Kind = v1alpha1.GroupVersion.String(), v1alpha1.OvirtImagePopulatorKind
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updated)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(v1alpha1.OvirtImagePopulatorResource).Namespace(populator.Namespace).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update the status of OvirtImagePopulator %s/%s: %w", populator.Namespace, populator.Name, err)
+	}
+	return nil
+}
+
+// stop cancels the upload, if it still runs, and releases the source.
+func (u *imageUpload) stop() {
+	if u.cancel != nil {
+		u.cancel()
+	}
+	if u.reader != nil {
+		_ = u.reader.Close()
+	}
+}
+
+// imageSourcePod serves the image file of the source PVC or the image at the URL with
+// serve-image. The proxy settings of the operator are passed on for the URL.
+func imageSourcePod(name, image string, pvc *corev1.PersistentVolumeClaim, populator *v1alpha1.OvirtImagePopulator) *corev1.Pod {
+	allowPrivilegeEscalation, runAsNonRoot := false, true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pvc.Namespace,
+			Labels:    map[string]string{imageSourceLabel: populator.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "PersistentVolumeClaim",
+				Name:       pvc.Name,
+				UID:        pvc.UID,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:  "serve-image",
+				Image: image,
+				Args:  []string{"serve-image", "--url", populator.Spec.URL},
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: imageSourcePort}},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						HTTPGet: &corev1.HTTPGetAction{Path: "/" + imageSizePath, Port: intstr.FromInt(imageSourcePort)},
+					},
+				},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("50Mi"),
+					},
+				},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+					RunAsNonRoot:             &runAsNonRoot,
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+					SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				},
+				TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+			}},
+		},
+	}
+	container := &pod.Spec.Containers[0]
+	if source := populator.Spec.PersistentVolumeClaim; source != nil {
+		container.Args = []string{"serve-image", "--file", path.Join("/source", source.Path)}
+		container.VolumeMounts = []corev1.VolumeMount{{Name: "source", MountPath: "/source", ReadOnly: true}}
+		pod.Spec.Volumes = []corev1.Volume{{
+			Name: "source",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: source.ClaimName, ReadOnly: true},
+			},
+		}}
+		return pod
+	}
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		if value := os.Getenv(name); value != "" {
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+	return pod
+}
+
+// populatedVolumeName is the name of the PV and disk of the PVC, the same as the provisioner uses.
+func populatedVolumeName(pvc *corev1.PersistentVolumeClaim) string {
+	return "pvc-" + string(pvc.UID)
+}
+
+func imageSourcePodName(pvc *corev1.PersistentVolumeClaim) string {
+	return "ovirt-image-source-" + string(pvc.UID)
+}
+
+func findVolumeStatus(volumes []v1alpha1.OvirtImagePopulatorVolumeStatus, claimName string) *v1alpha1.OvirtImagePopulatorVolumeStatus {
+	for i := range volumes {
+		if volumes[i].ClaimName == claimName {
+			return &volumes[i]
+		}
+	}
+	return nil
+}
+
+func findStorageDomainID(ctx context.Context, ovirtClient ovirtclient.Client, name string) (ovirtclient.StorageDomainID, error) {
+	names, err := listStorageDomainNames(ctx, ovirtClient)
+	if err != nil {
+		return "", err
+	}
+	for id, n := range names {
+		if n == name {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("storage domain %q does not exist", name)
+}
+
+// removeDisksByAlias removes the disks left by an upload interrupted by a restart of the operator.
+func removeDisksByAlias(ctx context.Context, ovirtClient ovirtclient.Client, alias string) error {
+	disks, err := ovirtClient.ListDisksByAlias(alias, ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to list disks with alias %s: %w", alias, err)
+	}
+	for _, disk := range disks {
+		if disk.Alias() != alias {
+			continue
+		}
+		klog.Infof("Removing disk %s left by an interrupted upload", disk.ID())
+		if err := ovirtClient.RemoveDisk(disk.ID(), ovirtclient.ContextStrategy(ctx)); err != nil {
+			return fmt.Errorf("failed to remove disk %s: %w", disk.ID(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+// fakeUploadProgress is an upload which finished with err.
+type fakeUploadProgress struct {
+	err  error
+	done chan struct{}
+}
+
+func newFailedUploadProgress(err error) *fakeUploadProgress {
+	done := make(chan struct{})
+	close(done)
+	return &fakeUploadProgress{err: err, done: done}
+}
+
+func (p *fakeUploadProgress) Disk() ovirtclient.Disk { return nil }
+func (p *fakeUploadProgress) UploadedBytes() uint64  { return 0 }
+func (p *fakeUploadProgress) TotalBytes() uint64     { return 1 << 20 }
+func (p *fakeUploadProgress) Err() error             { return p.err }
+func (p *fakeUploadProgress) Done() <-chan struct{}  { return p.done }
+
+func TestImagePopulatorPopulate(t *testing.T) {
+	image := make([]byte, 1<<20)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app", UID: "0d6a3a8e-5a4c-4f7e-9a57-3e1b0c5d2f10"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Mi")},
+			},
+		},
+	}
+	sc := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "ovirt-csi-sc"},
+		Provisioner: instanceName,
+		Parameters:  map[string]string{"storageDomainName": "Test storage domain", "thinProvisioning": "false"},
+	}
+	newSourcePod := func(phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: imageSourcePodName(pvc), Namespace: pvc.Namespace},
+			Status: corev1.PodStatus{
+				Phase:      phase,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	urlSource := v1alpha1.OvirtImagePopulatorSpec{URL: "https://images.example.com/db.img"}
+	claimSource := v1alpha1.OvirtImagePopulatorSpec{
+		PersistentVolumeClaim: &v1alpha1.OvirtImagePopulatorClaimSource{ClaimName: "images", Path: "db.img"},
+	}
+
+	testCases := []struct {
+		name string
+		spec v1alpha1.OvirtImagePopulatorSpec
+		// upload is the upload already known for the PVC
+		upload       func(t *testing.T, ovirtClient ovirtclient.Client) *imageUpload
+		objects      []runtime.Object
+		expectPhase  v1alpha1.OvirtImagePopulatorPhase
+		expectPod    bool
+		expectArgs   []string
+		expectUpload bool
+		expectPV     bool
+	}{
+		{
+			name:        "source pod is created first",
+			spec:        urlSource,
+			expectPhase: v1alpha1.OvirtImagePopulatorPending,
+			expectPod:   true,
+			expectArgs:  []string{"serve-image", "--url", urlSource.URL},
+		},
+		{
+			name:        "claim source pod mounts the image PVC",
+			spec:        claimSource,
+			expectPhase: v1alpha1.OvirtImagePopulatorPending,
+			expectPod:   true,
+			expectArgs:  []string{"serve-image", "--file", "/source/db.img"},
+		},
+		{
+			name:         "upload starts once the source pod is ready",
+			spec:         urlSource,
+			objects:      []runtime.Object{newSourcePod(corev1.PodRunning)},
+			expectPhase:  v1alpha1.OvirtImagePopulatorUploading,
+			expectPod:    true,
+			expectUpload: true,
+		},
+		{
+			name: "failed upload waits for the retry",
+			spec: urlSource,
+			upload: func(*testing.T, ovirtclient.Client) *imageUpload {
+				return &imageUpload{err: errors.New("connection reset"), finished: time.Now()}
+			},
+			objects:     []runtime.Object{newSourcePod(corev1.PodRunning)},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+			expectPod:   true,
+		},
+		{
+			name: "failed upload is retried after the interval",
+			spec: urlSource,
+			upload: func(*testing.T, ovirtclient.Client) *imageUpload {
+				return &imageUpload{err: errors.New("connection reset"), finished: time.Now().Add(-populatorRetryInterval)}
+			},
+			objects:      []runtime.Object{newSourcePod(corev1.PodRunning)},
+			expectPhase:  v1alpha1.OvirtImagePopulatorUploading,
+			expectPod:    true,
+			expectUpload: true,
+		},
+		{
+			name: "failure of a running upload is reported",
+			spec: urlSource,
+			upload: func(*testing.T, ovirtclient.Client) *imageUpload {
+				return &imageUpload{cancel: func() {}, progress: newFailedUploadProgress(errors.New("image transfer failed"))}
+			},
+			objects:     []runtime.Object{newSourcePod(corev1.PodRunning)},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+			expectPod:   true,
+		},
+		{
+			name: "finished upload creates the PV",
+			spec: urlSource,
+			upload: func(t *testing.T, ovirtClient ovirtclient.Client) *imageUpload {
+				reader := newImageReader(context.Background(), int64(len(image)), func(_ context.Context, offset int64) (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(image[offset:])), nil
+				})
+				storageDomainID, err := findStorageDomainID(context.Background(), ovirtClient, sc.Parameters["storageDomainName"])
+				if err != nil {
+					t.Fatal(err)
+				}
+				params := ovirtclient.CreateDiskParams().MustWithAlias(populatedVolumeName(pvc))
+				progress, err := ovirtClient.StartUploadToNewDisk(storageDomainID, ovirtclient.ImageFormatRaw, uint64(len(image)), params, reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				<-progress.Done()
+				return &imageUpload{cancel: func() {}, reader: reader, progress: progress}
+			},
+			objects:     []runtime.Object{newSourcePod(corev1.PodRunning)},
+			expectPhase: v1alpha1.OvirtImagePopulatorSucceeded,
+			expectPV:    true,
+		},
+		{
+			name:        "failed source pod is replaced on the retry",
+			spec:        urlSource,
+			objects:     []runtime.Object{newSourcePod(corev1.PodFailed)},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+		},
+		{
+			name: "path outside the volume",
+			spec: v1alpha1.OvirtImagePopulatorSpec{
+				PersistentVolumeClaim: &v1alpha1.OvirtImagePopulatorClaimSource{ClaimName: "images", Path: "../../etc/shadow"},
+			},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+		},
+		{
+			name: "absolute path",
+			spec: v1alpha1.OvirtImagePopulatorSpec{
+				PersistentVolumeClaim: &v1alpha1.OvirtImagePopulatorClaimSource{ClaimName: "images", Path: "/etc/shadow"},
+			},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+		},
+		{
+			name:        "unsupported URL scheme",
+			spec:        v1alpha1.OvirtImagePopulatorSpec{URL: "file:///etc/shadow"},
+			expectPhase: v1alpha1.OvirtImagePopulatorFailed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ovirtClient := ovirtclient.NewMock()
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			populator := &v1alpha1.OvirtImagePopulator{
+				ObjectMeta: metav1.ObjectMeta{Name: "db-image", Namespace: pvc.Namespace},
+				Spec:       tc.spec,
+			}
+			c := &OvirtImagePopulatorController{
+				name:               "OvirtImagePopulatorController",
+				kubeClient:         kubeClient,
+				pvLister:           kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				getConfig:          func() (*OperatorConfig, error) { return &OperatorConfig{}, nil },
+				ovirtClientFactory: func() (ovirtclient.Client, error) { return ovirtClient, nil },
+				openSource: func(ctx context.Context, _, _ string) (*imageReader, error) {
+					return newImageReader(ctx, int64(len(image)), func(_ context.Context, offset int64) (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(image[offset:])), nil
+					}), nil
+				},
+				operatorImage: "quay.io/ovirt/csi-driver-operator:latest",
+				eventRecorder: events.NewInMemoryRecorder("test"),
+				uploads:       map[types.UID]*imageUpload{},
+			}
+			var seeded *imageUpload
+			if tc.upload != nil {
+				seeded = tc.upload(t, ovirtClient)
+				c.uploads[pvc.UID] = seeded
+			}
+			defer func() {
+				for _, upload := range c.uploads {
+					upload.stop()
+				}
+			}()
+
+			status, err := c.populate(context.Background(), pvc, sc, populator)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if status.Phase != tc.expectPhase {
+				t.Errorf("expected phase %s, got %s: %s", tc.expectPhase, status.Phase, status.Message)
+			}
+			if tc.expectPhase == v1alpha1.OvirtImagePopulatorFailed && status.Message == "" {
+				t.Errorf("expected the reason of the failure in the message")
+			}
+
+			upload := c.uploads[pvc.UID]
+			started := upload != nil && upload != seeded && upload.progress != nil
+			if started != tc.expectUpload {
+				t.Errorf("expected a new upload %t, got %+v", tc.expectUpload, upload)
+			}
+			if tc.expectPhase == v1alpha1.OvirtImagePopulatorFailed && (upload == nil || upload.err == nil || upload.finished.IsZero()) {
+				t.Errorf("expected the failure recorded for the retry, got %+v", upload)
+			}
+
+			pod, err := kubeClient.CoreV1().Pods(pvc.Namespace).Get(context.Background(), imageSourcePodName(pvc), metav1.GetOptions{})
+			if exists := err == nil; exists != tc.expectPod {
+				t.Errorf("expected the source pod %t, got %v", tc.expectPod, err)
+			} else if err != nil && !apierrors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			if tc.expectArgs != nil && pod != nil {
+				if args := strings.Join(pod.Spec.Containers[0].Args, " "); args != strings.Join(tc.expectArgs, " ") {
+					t.Errorf("expected the source pod to run %v, got %s", tc.expectArgs, args)
+				}
+			}
+
+			pv, err := kubeClient.CoreV1().PersistentVolumes().Get(context.Background(), populatedVolumeName(pvc), metav1.GetOptions{})
+			if exists := err == nil; exists != tc.expectPV {
+				t.Errorf("expected the PV %t, got %v", tc.expectPV, err)
+			}
+			if tc.expectPV && (pv.Spec.CSI.VolumeHandle != status.DiskID || pv.Spec.ClaimRef.UID != pvc.UID) {
+				t.Errorf("expected the PV of disk %s bound to the PVC, got %+v", status.DiskID, pv.Spec)
+			}
+		})
+	}
+}
+
+func TestValidateVolumePath(t *testing.T) {
+	for p, valid := range map[string]bool{
+		"db.img":              true,
+		"images/db.img":       true,
+		"images/../db.img":    false,
+		"..":                  false,
+		"../db.img":           false,
+		"/db.img":             false,
+		"":                    false,
+		"images/..foo/db.img": true,
+	} {
+		if err := validateVolumePath(p); (err == nil) != valid {
+			t.Errorf("path %q: expected valid %t, got %v", p, valid, err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// imageSourcePort is the port serve-image listens on in the source pod
+	imageSourcePort = 8080
+
+	// imageSourcePath and imageSizePath are served by serve-image
+	imageSourcePath = "image"
+	imageSizePath   = "size"
+)
+
+// openAtFunc opens the image for reading from the offset on.
+type openAtFunc func(ctx context.Context, offset int64) (io.ReadCloser, error)
+
+// imageReader is the io.ReadSeekCloser the upload of the oVirt client needs, reading a remote
+// image. The upload reads the header of the image first and seeks back, and seeks again on
+// retries. A seek drops the open response, the next read opens the image at the new offset.
+type imageReader struct {
+	ctx    context.Context
+	openAt openAtFunc
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func newImageReader(ctx context.Context, size int64, openAt openAtFunc) *imageReader {
+	return &imageReader{ctx: ctx, openAt: openAt, size: size}
+}
+
+func (r *imageReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.openAt(r.ctx, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *imageReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, fmt.Errorf("invalid offset %d", offset)
+	}
+	if offset != r.offset {
+		r.closeBody()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *imageReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *imageReader) closeBody() {
+	if r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+}
+
+// validateImageURL accepts only HTTP(S) URLs with a host.
+func validateImageURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url scheme %q is not supported, only http and https are", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", rawURL)
+	}
+	return nil
+}
+
+// validateVolumePath accepts only paths relative to the root of a volume which stay within it.
+func validateVolumePath(p string) error {
+	if p == "" {
+		return fmt.Errorf("path must not be empty")
+	}
+	if path.IsAbs(p) {
+		return fmt.Errorf("path %q must be relative to the volume", p)
+	}
+	for _, element := range strings.Split(p, "/") {
+		if element == ".." {
+			return fmt.Errorf("path %q must not contain ..", p)
+		}
+	}
+	return nil
+}
+
+// newURLImageReader reads the image from an HTTP(S) URL, with range requests when the server
+// supports them. It runs in the source pod, never in the operator.
+func newURLImageReader(ctx context.Context, client *http.Client, url string) (*imageReader, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %s returned %s", url, response.Status)
+	}
+	if response.ContentLength <= 0 {
+		return nil, fmt.Errorf("HEAD %s returned no Content-Length", url)
+	}
+
+	openAt := func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case response.StatusCode == http.StatusPartialContent:
+			return response.Body, nil
+		case response.StatusCode == http.StatusOK:
+			// No range support, skip to the offset
+			if _, err := io.CopyN(io.Discard, response.Body, offset); err != nil {
+				_ = response.Body.Close()
+				return nil, err
+			}
+			return response.Body, nil
+		default:
+			_ = response.Body.Close()
+			return nil, fmt.Errorf("GET %s returned %s", url, response.Status)
+		}
+	}
+	return newImageReader(ctx, response.ContentLength, openAt), nil
+}
+
+// newPodImageReader reads the image served by serve-image in the pod through the API server
+// proxy, which works from a hosted control plane as well.
+func newPodImageReader(ctx context.Context, kubeClient kubernetes.Interface, namespace, pod string) (*imageReader, error) {
+	proxy := func() *rest.Request {
+		return kubeClient.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("pods").
+			Name(fmt.Sprintf("%s:%d", pod, imageSourcePort)).
+			SubResource("proxy")
+	}
+	raw, err := proxy().Suffix(imageSizePath).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the image size from pod %s/%s: %w", namespace, pod, err)
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("pod %s/%s returned an invalid image size %q", namespace, pod, raw)
+	}
+
+	openAt := func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		return proxy().Suffix(imageSourcePath).SetHeader("Range", fmt.Sprintf("bytes=%d-", offset)).Stream(ctx)
+	}
+	return newImageReader(ctx, size, openAt), nil
+}
+
+// ServeImage serves the image file, or the image at the URL, and its size to the image
+// populator until the context is done. It runs in the source pod of an OvirtImagePopulator in
+// the namespace of the PVC, so a URL is fetched with the network access of that namespace
+// rather than of the operator.
+func ServeImage(ctx context.Context, file, imageURL string) error {
+	var size int64
+	var modTime time.Time
+	var open func(r *http.Request) (io.ReadSeekCloser, error)
+	if imageURL != "" {
+		if err := validateImageURL(imageURL); err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+		source, err := newURLImageReader(ctx, client, imageURL)
+		if err != nil {
+			return err
+		}
+		size = source.size
+		open = func(r *http.Request) (io.ReadSeekCloser, error) {
+			// A reader per request, each seeks on its own
+			return newImageReader(r.Context(), source.size, source.openAt), nil
+		}
+	} else {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", file)
+		}
+		size, modTime = info.Size(), info.ModTime()
+		open = func(*http.Request) (io.ReadSeekCloser, error) {
+			// Opened per request, ServeContent seeks the file
+			return os.Open(file)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+imageSizePath, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d\n", size)
+	})
+	mux.HandleFunc("/"+imageSourcePath, func(w http.ResponseWriter, r *http.Request) {
+		f, err := open(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		// Set, so that ServeContent does not read the image to sniff it
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", modTime, f)
+	})
+	server := &http.Server{Addr: fmt.Sprintf(":%d", imageSourcePort), Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
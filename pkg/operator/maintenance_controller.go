@@ -0,0 +1,116 @@
+package operator
+
+import (
+	"context"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog/v2"
+)
+
+// maintenanceStorageClassAnnotation marks a StorageClass the operator made non-default
+// because of the maintenance mode
+const maintenanceStorageClassAnnotation = "csi.ovirt.org/demoted-by-maintenance"
+
+// OvirtMaintenanceController applies the maintenance mode of the operator configuration. In
+// maintenance the default StorageClasses of the driver are marked non-default and
+// <name>Progressing is True with the reason Maintenance. The provisioner is removed from the
+// controller Deployment by its hook. Once the maintenance ends the StorageClasses are restored.
+type OvirtMaintenanceController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	storageClassLister storagelisters.StorageClassLister
+	getConfig          func() (*OperatorConfig, error)
+	eventRecorder      events.Recorder
+}
+
+func NewOvirtMaintenanceController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	storageClassInformer storagev1informers.StorageClassInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtMaintenanceController{
+		name:               "OvirtMaintenanceController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		storageClassLister: storageClassInformer.Lister(),
+		getConfig:          getConfig,
+		eventRecorder:      eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		storageClassInformer.Informer(),
+		configMapInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtMaintenanceController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping maintenance mode: %v", err)
+		return nil
+	}
+
+	storageClasses, err := c.storageClassLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, sc := range storageClasses {
+		if sc.Provisioner != instanceName {
+			continue
+		}
+		_, demoted := sc.Annotations[maintenanceStorageClassAnnotation]
+		switch {
+		case config.Maintenance && sc.Annotations[defaultStorageClassAnnotation] == "true":
+			if err := patchDefaultStorageClass(ctx, c.kubeClient, sc, "false", maintenanceStorageClassAnnotation, "true"); err != nil {
+				return err
+			}
+			c.eventRecorder.Eventf("StorageClassDemoted", "StorageClass %s is no longer the default during the maintenance", sc.Name)
+		case !config.Maintenance && demoted:
+			if err := patchDefaultStorageClass(ctx, c.kubeClient, sc, "true", maintenanceStorageClassAnnotation, nil); err != nil {
+				return err
+			}
+			c.eventRecorder.Eventf("StorageClassRestored", "StorageClass %s is the default again after the maintenance", sc.Name)
+		}
+	}
+
+	condition := operatorapi.OperatorCondition{
+		Type:    c.name + operatorapi.OperatorStatusTypeProgressing,
+		Status:  operatorapi.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: "Not in maintenance",
+	}
+	if config.Maintenance {
+		condition.Status, condition.Reason = operatorapi.ConditionTrue, "Maintenance"
+		condition.Message = "In maintenance, new volumes are not provisioned"
+	}
+	_, oldStatus, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	if previous := v1helpers.FindOperatorCondition(oldStatus.Conditions, condition.Type); previous == nil || previous.Status != condition.Status {
+		if config.Maintenance {
+			c.eventRecorder.Warning("MaintenanceStarted", "Maintenance mode is on, the provisioner is stopped")
+		} else if previous != nil {
+			c.eventRecorder.Event("MaintenanceEnded", "Maintenance mode is off, the provisioner is started")
+		}
+	}
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
@@ -0,0 +1,129 @@
+package operator
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// volumeLabels label the volume metrics with the PV, its claim and the storage domain of its disk
+var volumeLabels = []string{"namespace", "persistentvolumeclaim", "persistentvolume", "storage_domain"}
+
+var (
+	volumeActualBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_volume_actual_bytes",
+			Help:           "Bytes actually allocated in the storage domain for the disk of an oVirt CSI volume.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		volumeLabels,
+	)
+	volumeProvisionedBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_volume_provisioned_bytes",
+			Help:           "Provisioned size in bytes of the disk of an oVirt CSI volume.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		volumeLabels,
+	)
+
+	storageDomainProvisionedBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_storage_domain_provisioned_bytes",
+			Help:           "Sum of the provisioned size in bytes of the oVirt CSI disks in a storage domain.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"storage_domain"},
+	)
+	storageDomainSizeBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_storage_domain_size_bytes",
+			Help:           "Size in bytes of a storage domain, available plus used space.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"storage_domain"},
+	)
+	storageDomainAvailableBytes = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_storage_domain_available_bytes",
+			Help:           "Available space in bytes of a storage domain.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"storage_domain"},
+	)
+
+	engineUp = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_engine_up",
+			Help:           "Whether the last connection of the operator to the oVirt engine succeeded (1) or failed (0).",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	engineCertificateExpiry = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_engine_certificate_expiry_timestamp_seconds",
+			Help:           "Unix time when a certificate of the chain presented by the oVirt engine (source engine) or of the configured CA bundle (source ca_bundle) expires.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"source", "subject", "serial"},
+	)
+	engineCertificateChainValid = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_engine_certificate_chain_valid",
+			Help:           "Whether the certificate chain presented by the oVirt engine validates against the configured CA bundle (1) or not (0).",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	volumeAttachmentPendingSince = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_volume_attachment_pending_since_timestamp_seconds",
+			Help:           "Unix time since which a VolumeAttachment of an oVirt CSI volume waits for its volume to be attached or detached.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"volumeattachment", "node", "persistentvolume", "operation"},
+	)
+
+	nodeStartupTaintSeconds = metrics.NewHistogram(
+		&metrics.HistogramOpts{
+			Name:           "ovirt_csi_node_startup_taint_seconds",
+			Help:           "Seconds from the creation of a node until the oVirt CSI node plugin was ready on it and its startup taint was removed.",
+			Buckets:        metrics.ExponentialBuckets(15, 2, 10),
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+	nodesWaitingForNodePlugin = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Name:           "ovirt_csi_nodes_waiting_for_node_plugin",
+			Help:           "Number of nodes with the startup taint, waiting for the oVirt CSI node plugin to be ready.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	operandImageInfo = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "openshift_ovirt_csi_driver_operand_image_info",
+			Help:           "A metric with a constant '1' value labeled by the container name and image of the applied controller Deployment and node DaemonSet.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operand", "image"},
+	)
+)
+
+func init() {
+	// legacyregistry is served on the metrics endpoint of the controller command
+	legacyregistry.MustRegister(
+		volumeActualBytes,
+		volumeProvisionedBytes,
+		storageDomainProvisionedBytes,
+		storageDomainSizeBytes,
+		storageDomainAvailableBytes,
+		engineUp,
+		engineCertificateExpiry,
+		engineCertificateChainValid,
+		volumeAttachmentPendingSince,
+		nodeStartupTaintSeconds,
+		nodesWaitingForNodePlugin,
+		operandImageInfo,
+	)
+}
@@ -0,0 +1,205 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ovirtVMNodeLabel records whether the node is backed by an oVirt VM
+	ovirtVMNodeLabel = "csi.ovirt.org/ovirt-vm"
+
+	// maxReportedNodes limits the node names listed in the condition message
+	maxReportedNodes = 10
+)
+
+// OvirtNodePlacementController labels nodes backed by oVirt VMs when the node plugin
+// is restricted to them, and reports the nodes the node plugin is excluded from. A node
+// which is not detected as an oVirt VM but has VolumeAttachments of the driver is left
+// unlabeled, which keeps the node plugin on it until the node is drained.
+type OvirtNodePlacementController struct {
+	name                   string
+	operatorClient         v1helpers.OperatorClient
+	kubeClient             kubernetes.Interface
+	nodeLister             corelisters.NodeLister
+	volumeAttachmentLister storagelisters.VolumeAttachmentLister
+	getConfig              func() (*OperatorConfig, error)
+	ovirtClientFactory     func() (ovirtclient.Client, error)
+	eventRecorder          events.Recorder
+}
+
+func NewOvirtNodePlacementController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	volumeAttachmentInformer storagev1informers.VolumeAttachmentInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtNodePlacementController{
+		name:                   "OvirtNodePlacementController",
+		operatorClient:         operatorClient,
+		kubeClient:             kubeClient,
+		nodeLister:             nodeInformer.Lister(),
+		volumeAttachmentLister: volumeAttachmentInformer.Lister(),
+		getConfig:              getConfig,
+		ovirtClientFactory:     ovirtClientFactory,
+		eventRecorder:          eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		nodeInformer.Informer(),
+		volumeAttachmentInformer.Informer(),
+		configMapInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtNodePlacementController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping node placement: %v", err)
+		return nil
+	}
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	if config.Node.OvirtVMsOnly {
+		attachedNodes, err := c.attachedNodes()
+		if err != nil {
+			return err
+		}
+		labeled := make([]*corev1.Node, 0, len(nodes))
+		for _, node := range nodes {
+			if _, ok := node.Labels[ovirtVMNodeLabel]; !ok {
+				node, err = c.labelNode(ctx, node, attachedNodes.Has(node.Name))
+				if err != nil {
+					return err
+				}
+			}
+			labeled = append(labeled, node)
+		}
+		nodes = labeled
+	}
+
+	var excluded, unlabeled []string
+	for _, node := range nodes {
+		if _, ok := node.Labels[ovirtVMNodeLabel]; config.Node.OvirtVMsOnly && !ok {
+			unlabeled = append(unlabeled, node.Name)
+		}
+		if !nodePluginRunsOn(config, node) {
+			excluded = append(excluded, node.Name)
+		}
+	}
+
+	return c.updateCondition(ctx, len(nodes), excluded, unlabeled)
+}
+
+// attachedNodes returns the names of the nodes with VolumeAttachments of the driver.
+func (c *OvirtNodePlacementController) attachedNodes() (sets.String, error) {
+	attachments, err := c.volumeAttachmentLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	nodes := sets.NewString()
+	for _, va := range attachments {
+		if va.Spec.Attacher == instanceName {
+			nodes.Insert(va.Spec.NodeName)
+		}
+	}
+	return nodes, nil
+}
+
+// labelNode detects whether the node is backed by an oVirt VM and records it in the
+// ovirtVMNodeLabel label. The label is never re-evaluated, as the backing VM of a node
+// does not change. A node with VolumeAttachments is never labeled as not backed by a VM,
+// as that would evict the node plugin its volumes are attached through; it is returned
+// unlabeled.
+func (c *OvirtNodePlacementController) labelNode(ctx context.Context, node *corev1.Node, attached bool) (*corev1.Node, error) {
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+
+	vmID := strings.ToLower(node.Status.NodeInfo.SystemUUID)
+	isVM := true
+	if _, err := ovirtClient.GetVM(ovirtclient.VMID(vmID), ovirtclient.ContextStrategy(ctx)); err != nil {
+		if !ovirtclient.HasErrorCode(err, ovirtclient.ENotFound) {
+			return nil, fmt.Errorf("failed to get VM %s of node %s: %w", vmID, node.Name, err)
+		}
+		isVM = false
+	}
+	if !isVM && attached {
+		c.eventRecorder.Warningf("NodeNotOvirtVM", "Node %s is not backed by an oVirt VM but has oVirt CSI volumes attached, it is left unlabeled until they are detached", node.Name)
+		return node, nil
+	}
+
+	value := strconv.FormatBool(isVM)
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, ovirtVMNodeLabel, value)
+	patched, err := c.kubeClient.CoreV1().Nodes().Patch(ctx, node.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to label node %s: %w", node.Name, err)
+	}
+	if !isVM {
+		c.eventRecorder.Warningf("NodeNotOvirtVM", "Node %s is not backed by an oVirt VM, the oVirt CSI node plugin will not run on it", node.Name)
+	}
+	klog.Infof("Labeled node %s with %s=%s", node.Name, ovirtVMNodeLabel, value)
+	return patched, nil
+}
+
+func (c *OvirtNodePlacementController) updateCondition(ctx context.Context, total int, excluded, unlabeled []string) error {
+	condition := operatorapi.OperatorCondition{
+		Type:    c.name + operatorapi.OperatorStatusTypeAvailable,
+		Status:  operatorapi.ConditionTrue,
+		Reason:  "AsExpected",
+		Message: fmt.Sprintf("The node plugin runs on all %d nodes", total),
+	}
+	switch {
+	case len(excluded) > 0:
+		condition.Reason = "NodesExcluded"
+		condition.Message = fmt.Sprintf("The node plugin is excluded from %d of %d nodes: %s", len(excluded), total, reportedNodes(excluded))
+	case len(unlabeled) > 0:
+		condition.Reason = "NodesUnlabeled"
+		condition.Message = fmt.Sprintf("%d of %d nodes are not labeled with %s yet, the node plugin runs on them until they are: %s", len(unlabeled), total, ovirtVMNodeLabel, reportedNodes(unlabeled))
+	}
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// reportedNodes joins the sorted node names for a condition message.
+func reportedNodes(names []string) string {
+	sort.Strings(names)
+	if len(names) > maxReportedNodes {
+		names = append(names[:maxReportedNodes:maxReportedNodes], "...")
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,157 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodePlacementSync(t *testing.T) {
+	ovirtClient := ovirtclient.NewMock()
+	vmID := newMockVM(t, ovirtClient, "worker-0")
+	const notVM = "5d0e6c3b-2f4a-4b1e-8c7d-9a8b7c6d5e4f"
+	attachment := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-1"},
+		Spec:       storagev1.VolumeAttachmentSpec{Attacher: instanceName, NodeName: "bare-1"},
+	}
+
+	testCases := []struct {
+		name    string
+		config  NodeConfig
+		objects []runtime.Object
+		// expectLabels are the ovirtVMNodeLabel values by node, empty for unlabeled nodes
+		expectLabels map[string]string
+		expectReason string
+	}{
+		{
+			name:         "no placement",
+			objects:      []runtime.Object{newVMNode("worker-0", vmID)},
+			expectLabels: map[string]string{"worker-0": ""},
+			expectReason: "AsExpected",
+		},
+		{
+			name:         "ovirtVMsOnly labels the nodes",
+			config:       NodeConfig{OvirtVMsOnly: true},
+			objects:      []runtime.Object{newVMNode("worker-0", strings.ToUpper(vmID)), newVMNode("bare-0", notVM)},
+			expectLabels: map[string]string{"worker-0": "true", "bare-0": "false"},
+			expectReason: "NodesExcluded",
+		},
+		{
+			name:         "a node with attachments is not labeled false",
+			config:       NodeConfig{OvirtVMsOnly: true},
+			objects:      []runtime.Object{newVMNode("worker-0", vmID), newVMNode("bare-1", notVM), attachment},
+			expectLabels: map[string]string{"worker-0": "true", "bare-1": ""},
+			expectReason: "NodesUnlabeled",
+		},
+		{
+			name:   "labeled nodes are not evaluated again",
+			config: NodeConfig{OvirtVMsOnly: true},
+			objects: []runtime.Object{
+				withLabels(newVMNode("worker-0", notVM), map[string]string{ovirtVMNodeLabel: "true"}),
+			},
+			expectLabels: map[string]string{"worker-0": "true"},
+			expectReason: "AsExpected",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{}, nil)
+			config := &OperatorConfig{Node: tc.config}
+			c := &OvirtNodePlacementController{
+				name:                   "OvirtNodePlacementController",
+				operatorClient:         operatorClient,
+				kubeClient:             kubeClient,
+				nodeLister:             kubeInformers.Core().V1().Nodes().Lister(),
+				volumeAttachmentLister: kubeInformers.Storage().V1().VolumeAttachments().Lister(),
+				getConfig:              func() (*OperatorConfig, error) { return config, nil },
+				ovirtClientFactory:     func() (ovirtclient.Client, error) { return ovirtClient, nil },
+				eventRecorder:          events.NewInMemoryRecorder("test"),
+			}
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("sync failed: %v", err)
+			}
+			for name, expect := range tc.expectLabels {
+				node, err := kubeClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := node.Labels[ovirtVMNodeLabel]; got != expect {
+					t.Errorf("expected node %s labeled %q, got %q", name, expect, got)
+				}
+			}
+			expectOperatorCondition(t, operatorClient, "OvirtNodePlacementControllerAvailable", opv1.ConditionTrue, tc.expectReason)
+		})
+	}
+}
+
+// newFakeKubeInformers returns a fake clientset with the objects and informers whose
+// indexers hold them, without starting the informers.
+func newFakeKubeInformers(t *testing.T, objects ...runtime.Object) (*fake.Clientset, informers.SharedInformerFactory) {
+	t.Helper()
+	kubeClient := fake.NewSimpleClientset(objects...)
+	kubeInformers := informers.NewSharedInformerFactory(kubeClient, 0)
+	for _, obj := range objects {
+		var err error
+		switch o := obj.(type) {
+		case *corev1.Node:
+			err = kubeInformers.Core().V1().Nodes().Informer().GetIndexer().Add(o)
+		case *corev1.Pod:
+			err = kubeInformers.Core().V1().Pods().Informer().GetIndexer().Add(o)
+		case *corev1.ConfigMap:
+			err = kubeInformers.Core().V1().ConfigMaps().Informer().GetIndexer().Add(o)
+		case *corev1.Secret:
+			err = kubeInformers.Core().V1().Secrets().Informer().GetIndexer().Add(o)
+		case *corev1.PersistentVolume:
+			err = kubeInformers.Core().V1().PersistentVolumes().Informer().GetIndexer().Add(o)
+		case *corev1.PersistentVolumeClaim:
+			err = kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(o)
+		case *storagev1.StorageClass:
+			err = kubeInformers.Storage().V1().StorageClasses().Informer().GetIndexer().Add(o)
+		case *storagev1.VolumeAttachment:
+			err = kubeInformers.Storage().V1().VolumeAttachments().Informer().GetIndexer().Add(o)
+		case *storagev1.CSINode:
+			err = kubeInformers.Storage().V1().CSINodes().Informer().GetIndexer().Add(o)
+		case *appsv1.Deployment:
+			err = kubeInformers.Apps().V1().Deployments().Informer().GetIndexer().Add(o)
+		case *appsv1.DaemonSet:
+			err = kubeInformers.Apps().V1().DaemonSets().Informer().GetIndexer().Add(o)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return kubeClient, kubeInformers
+}
+
+func expectOperatorCondition(t *testing.T, operatorClient v1helpers.OperatorClient, conditionType string, status opv1.ConditionStatus, reason string) {
+	t.Helper()
+	_, opStatus, _, err := operatorClient.GetOperatorState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	condition := v1helpers.FindOperatorCondition(opStatus.Conditions, conditionType)
+	if condition == nil {
+		t.Fatalf("condition %s not found in %v", conditionType, opStatus.Conditions)
+	}
+	if condition.Status != status || condition.Reason != reason {
+		t.Errorf("expected %s=%s with reason %s, got %s with reason %s: %s", conditionType, status, reason, condition.Status, condition.Reason, condition.Message)
+	}
+}
+
+func withLabels(node *corev1.Node, labels map[string]string) *corev1.Node {
+	node.Labels = labels
+	return node
+}
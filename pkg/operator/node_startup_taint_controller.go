@@ -0,0 +1,265 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appsv1informers "k8s.io/client-go/informers/apps/v1"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// startupTaintKey keeps pods off a node until the node plugin is registered on it
+	startupTaintKey = "csi.ovirt.org/agent-not-ready"
+
+	// nodePluginReadyAnnotation records when the node plugin was first ready on the node,
+	// such nodes are not tainted again
+	nodePluginReadyAnnotation = "csi.ovirt.org/node-plugin-ready-since"
+
+	// nodePluginLabel selects the pods of the node DaemonSet
+	nodePluginLabel = "app"
+	nodePluginValue = "ovirt-csi-driver-node"
+)
+
+// startupTaint is the taint managed on new nodes.
+var startupTaint = corev1.Taint{Key: startupTaintKey, Effect: corev1.TaintEffectNoSchedule}
+
+// OvirtNodeStartupTaintController taints new nodes the node plugin runs on, so that pods
+// with oVirt volumes are not scheduled to them before the node plugin can mount them. The
+// taint is removed once the CSINode of the node lists the driver and the node plugin pod on
+// the node is Ready. Nodes are only tainted while the node DaemonSet exists, otherwise the
+// taints are removed. Nothing is changed unless the operand is Managed, the
+// OvirtOperandRemovalController removes the taints of a Removed one.
+type OvirtNodeStartupTaintController struct {
+	name            string
+	operatorClient  v1helpers.OperatorClient
+	kubeClient      kubernetes.Interface
+	nodeLister      corelisters.NodeLister
+	csiNodeLister   storagelisters.CSINodeLister
+	podLister       corelisters.PodLister
+	daemonSetLister appslisters.DaemonSetLister
+	getConfig       func() (*OperatorConfig, error)
+	eventRecorder   events.Recorder
+}
+
+func NewOvirtNodeStartupTaintController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	csiNodeInformer storagev1informers.CSINodeInformer,
+	podInformer corev1informers.PodInformer,
+	daemonSetInformer appsv1informers.DaemonSetInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtNodeStartupTaintController{
+		name:            "OvirtNodeStartupTaintController",
+		operatorClient:  operatorClient,
+		kubeClient:      kubeClient,
+		nodeLister:      nodeInformer.Lister(),
+		csiNodeLister:   csiNodeInformer.Lister(),
+		podLister:       podInformer.Lister(),
+		daemonSetLister: daemonSetInformer.Lister(),
+		getConfig:       getConfig,
+		eventRecorder:   eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		nodeInformer.Informer(),
+		csiNodeInformer.Informer(),
+		podInformer.Informer(),
+		daemonSetInformer.Informer(),
+		configMapInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtNodeStartupTaintController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping node startup taints: %v", err)
+		return nil
+	}
+
+	nodePluginDeployed, err := c.nodePluginDeployed()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	readyPods, err := c.readyNodePluginPods()
+	if err != nil {
+		return err
+	}
+
+	waiting := 0
+	for _, node := range nodes {
+		tainted := hasStartupTaint(node)
+		_, wasReady := node.Annotations[nodePluginReadyAnnotation]
+		eligible := nodePluginDeployed && config.Node.StartupTaint && nodePluginRunsOn(config, node)
+
+		ready, err := c.nodePluginReady(node, readyPods)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !eligible || wasReady && !ready:
+			// Only new nodes wait for the node plugin
+			if tainted {
+				if err := c.updateNode(ctx, node.Name, false, ""); err != nil {
+					return err
+				}
+			}
+		case ready:
+			if tainted || !wasReady {
+				readySince := ""
+				if !wasReady {
+					readySince = time.Now().UTC().Format(time.RFC3339)
+				}
+				if err := c.updateNode(ctx, node.Name, false, readySince); err != nil {
+					return err
+				}
+			}
+			if tainted {
+				wait := time.Since(node.CreationTimestamp.Time)
+				nodeStartupTaintSeconds.Observe(wait.Seconds())
+				klog.Infof("Node plugin is ready on node %s after %s, removed taint %s", node.Name, wait.Round(time.Second), startupTaintKey)
+			}
+		default:
+			waiting++
+			if !tainted {
+				if err := c.updateNode(ctx, node.Name, true, ""); err != nil {
+					return err
+				}
+				c.eventRecorder.Eventf("NodeStartupTaintAdded", "Added taint %s to node %s until the node plugin is ready", startupTaintKey, node.Name)
+			}
+		}
+	}
+	nodesWaitingForNodePlugin.Set(float64(waiting))
+	return nil
+}
+
+// nodePluginDeployed reports whether the node DaemonSet exists.
+// Otherwise no node plugin ever removes the taint and nothing is scheduled to new nodes.
+func (c *OvirtNodeStartupTaintController) nodePluginDeployed() (bool, error) {
+	daemonSet, err := c.daemonSetLister.DaemonSets(defaultNamespace).Get(nodeDaemonSetName)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return daemonSet.DeletionTimestamp == nil, nil
+}
+
+// readyNodePluginPods returns the names of the nodes with a Ready node plugin pod.
+func (c *OvirtNodeStartupTaintController) readyNodePluginPods() (map[string]bool, error) {
+	pods, err := c.podLister.Pods(defaultNamespace).List(labels.SelectorFromSet(labels.Set{nodePluginLabel: nodePluginValue}))
+	if err != nil {
+		return nil, err
+	}
+	ready := map[string]bool{}
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil && isPodReady(pod) {
+			ready[pod.Spec.NodeName] = true
+		}
+	}
+	return ready, nil
+}
+
+// nodePluginReady reports whether the driver is registered on the node and its pod is Ready.
+func (c *OvirtNodeStartupTaintController) nodePluginReady(node *corev1.Node, readyPods map[string]bool) (bool, error) {
+	if !readyPods[node.Name] {
+		return false, nil
+	}
+	csiNode, err := c.csiNodeLister.Get(node.Name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, driver := range csiNode.Spec.Drivers {
+		if driver.Name == instanceName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateNode adds or removes the startup taint and, when readySince is set, records it.
+func (c *OvirtNodeStartupTaintController) updateNode(ctx context.Context, name string, taint bool, readySince string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		node = node.DeepCopy()
+		var taints []corev1.Taint
+		for _, t := range node.Spec.Taints {
+			if t.Key != startupTaintKey {
+				taints = append(taints, t)
+			}
+		}
+		if taint {
+			taints = append(taints, startupTaint)
+		}
+		node.Spec.Taints = taints
+		if readySince != "" {
+			if node.Annotations == nil {
+				node.Annotations = map[string]string{}
+			}
+			node.Annotations[nodePluginReadyAnnotation] = readySince
+		}
+		_, err = c.kubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update node %s: %w", name, err)
+	}
+	return nil
+}
+
+func hasStartupTaint(node *corev1.Node) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == startupTaintKey {
+			return true
+		}
+	}
+	return false
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNodeStartupTaintSync(t *testing.T) {
+	const nodeName = "worker-0"
+	daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: nodeDaemonSetName, Namespace: defaultNamespace}}
+	readyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ovirt-csi-driver-node-abcde", Namespace: defaultNamespace, Labels: map[string]string{nodePluginLabel: nodePluginValue}},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+		Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+	}
+	registered := &storagev1.CSINode{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName},
+		Spec:       storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{{Name: instanceName, NodeID: nodeName}}},
+	}
+	unregistered := &storagev1.CSINode{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	newNode := func(tainted, wasReady bool, labels map[string]string) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName, Labels: labels}}
+		if tainted {
+			node.Spec.Taints = []corev1.Taint{startupTaint}
+		}
+		if wasReady {
+			node.Annotations = map[string]string{nodePluginReadyAnnotation: "2023-01-01T00:00:00Z"}
+		}
+		return node
+	}
+
+	testCases := []struct {
+		name            string
+		managementState opv1.ManagementState
+		node            NodeConfig
+		objects         []runtime.Object
+		expectTainted   bool
+		expectReady     bool
+	}{
+		{
+			name:          "new node waits for the node plugin",
+			node:          NodeConfig{StartupTaint: true},
+			objects:       []runtime.Object{newNode(false, false, nil), daemonSet},
+			expectTainted: true,
+		},
+		{
+			name:          "taint stays until the driver is registered",
+			node:          NodeConfig{StartupTaint: true},
+			objects:       []runtime.Object{newNode(true, false, nil), daemonSet, readyPod, unregistered},
+			expectTainted: true,
+		},
+		{
+			name:        "taint removed once the node plugin is ready",
+			node:        NodeConfig{StartupTaint: true},
+			objects:     []runtime.Object{newNode(true, false, nil), daemonSet, readyPod, registered},
+			expectReady: true,
+		},
+		{
+			name:        "a node ready before is not tainted again",
+			node:        NodeConfig{StartupTaint: true},
+			objects:     []runtime.Object{newNode(false, true, nil), daemonSet},
+			expectReady: true,
+		},
+		{
+			name:    "taint removed when disabled",
+			node:    NodeConfig{StartupTaint: false},
+			objects: []runtime.Object{newNode(true, false, nil), daemonSet},
+		},
+		{
+			name:    "taint removed without the node DaemonSet",
+			node:    NodeConfig{StartupTaint: true},
+			objects: []runtime.Object{newNode(true, false, nil)},
+		},
+		{
+			name:    "node the node plugin does not run on",
+			node:    NodeConfig{StartupTaint: true, NodeSelector: map[string]string{"node-role.kubernetes.io/storage": ""}},
+			objects: []runtime.Object{newNode(false, false, nil), daemonSet},
+		},
+		{
+			name:            "unmanaged operand leaves the taint",
+			managementState: opv1.Unmanaged,
+			node:            NodeConfig{StartupTaint: false},
+			objects:         []runtime.Object{newNode(true, false, nil), daemonSet},
+			expectTainted:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			managementState := tc.managementState
+			if managementState == "" {
+				managementState = opv1.Managed
+			}
+			operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: managementState}, &opv1.OperatorStatus{}, nil)
+			config := &OperatorConfig{Node: tc.node}
+			config.setDefaults()
+			c := &OvirtNodeStartupTaintController{
+				name:            "OvirtNodeStartupTaintController",
+				operatorClient:  operatorClient,
+				kubeClient:      kubeClient,
+				nodeLister:      kubeInformers.Core().V1().Nodes().Lister(),
+				csiNodeLister:   kubeInformers.Storage().V1().CSINodes().Lister(),
+				podLister:       kubeInformers.Core().V1().Pods().Lister(),
+				daemonSetLister: kubeInformers.Apps().V1().DaemonSets().Lister(),
+				getConfig:       func() (*OperatorConfig, error) { return config, nil },
+				eventRecorder:   events.NewInMemoryRecorder("test"),
+			}
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			node, err := kubeClient.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tainted := hasStartupTaint(node); tainted != tc.expectTainted {
+				t.Errorf("expected tainted %t, got taints %v", tc.expectTainted, node.Spec.Taints)
+			}
+			if _, ready := node.Annotations[nodePluginReadyAnnotation]; ready != tc.expectReady {
+				t.Errorf("expected the %s annotation %t, got %v", nodePluginReadyAnnotation, tc.expectReady, node.Annotations)
+			}
+		})
+	}
+}
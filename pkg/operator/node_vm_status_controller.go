@@ -0,0 +1,330 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// nodeVMStatusAnnotation records the status of the VM of a node while it is paused or not
+	// responding, it is removed once the VM is up again
+	nodeVMStatusAnnotation = "csi.ovirt.org/vm-status"
+
+	// vmPausedTaintKey keeps new pods off a node whose VM is paused or not responding, its
+	// value is the status of the VM
+	vmPausedTaintKey = "csi.ovirt.org/vm-paused"
+
+	// cordonedByVMStatusAnnotation marks a node the operator cordoned, nodes cordoned before
+	// are not uncordoned
+	cordonedByVMStatusAnnotation = "csi.ovirt.org/cordoned-by-vm-status"
+
+	nodeVMConditionType = "OvirtNodeVMsPaused"
+
+	// nodeVMStatusInterval is the interval the VMs of the nodes are polled in
+	nodeVMStatusInterval = time.Minute
+)
+
+// OvirtNodeVMStatusController polls the status of the VMs of the nodes. The engine pauses a
+// VM on storage I/O errors, e.g. when its storage domain runs out of space or loses
+// connectivity, and the node just goes NotReady. Paused and not responding VMs are reported
+// with the likely storage domain in the OvirtNodeVMsPaused condition and a Node event, and
+// optionally their nodes are cordoned and tainted until the VM is up again.
+type OvirtNodeVMStatusController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	nodeLister         corelisters.NodeLister
+	getConfig          func() (*OperatorConfig, error)
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	eventRecorder      events.Recorder
+}
+
+func NewOvirtNodeVMStatusController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	nodeInformer corev1informers.NodeInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtNodeVMStatusController{
+		name:               "OvirtNodeVMStatusController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		nodeLister:         nodeInformer.Lister(),
+		getConfig:          getConfig,
+		ovirtClientFactory: ovirtClientFactory,
+		eventRecorder:      eventRecorder,
+	}
+	// Driven by the resync and configuration changes only: node and operator status updates
+	// would trigger a sync, and an engine request, every few seconds
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithBareInformers(
+		operatorClient.Informer(),
+		nodeInformer.Informer(),
+	).WithInformers(
+		configMapInformer.Informer(),
+	).ResyncEvery(nodeVMStatusInterval).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtNodeVMStatusController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping node VM status check: %v", err)
+		return nil
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	// The VMs are listed in one request to spare the engine
+	vmList, err := ovirtClient.ListVMs(ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to list VMs: %w", err)
+	}
+	vms := make(map[ovirtclient.VMID]ovirtclient.VM, len(vmList))
+	for _, vm := range vmList {
+		vms[vm.ID()] = vm
+	}
+
+	suspects := &storageDomainSuspects{ovirtClient: ovirtClient, freePercent: *config.Alerts.StorageDomainFreePercent}
+	var messages []string
+	for _, node := range nodes {
+		if node.Labels[ovirtVMNodeLabel] == "false" {
+			continue
+		}
+		vmID := ovirtclient.VMID(strings.ToLower(node.Status.NodeInfo.SystemUUID))
+		vm, found := vms[vmID]
+
+		previous := node.Annotations[nodeVMStatusAnnotation]
+		status := previous
+		switch {
+		case !found || vm.Status() == ovirtclient.VMStatusUp:
+			status = ""
+		case vm.Status() == ovirtclient.VMStatusPaused || vm.Status() == ovirtclient.VMStatusNotResponding:
+			status = string(vm.Status())
+		}
+		if status == "" {
+			if err := c.updateNode(ctx, node, "", false); err != nil {
+				return err
+			}
+			if previous != "" {
+				c.nodeEvent(node, corev1.EventTypeNormal, "NodeVMUp", fmt.Sprintf("The oVirt VM %s of node %s is up again", vmID, node.Name))
+			}
+			continue
+		}
+
+		storageDomains, err := suspects.describe(ctx, vmID)
+		if err != nil {
+			return err
+		}
+		described := strings.ReplaceAll(status, "_", " ")
+		messages = append(messages, fmt.Sprintf("%s (VM %s, %s)", node.Name, described, storageDomains))
+		if err := c.updateNode(ctx, node, status, config.Node.CordonPausedVMs); err != nil {
+			return err
+		}
+		if previous != status {
+			c.nodeEvent(node, corev1.EventTypeWarning, "NodeVMPaused",
+				fmt.Sprintf("The oVirt VM %s of node %s is %s, %s", vmID, node.Name, described, storageDomains))
+		}
+	}
+	return c.updateCondition(ctx, messages)
+}
+
+// updateNode records the status of the VM in the node annotation and, with cordon, taints and
+// cordons the node. An empty status restores the node.
+func (c *OvirtNodeVMStatusController) updateNode(ctx context.Context, node *corev1.Node, status string, cordon bool) error {
+	taint := status != "" && cordon
+	_, cordonedByUs := node.Annotations[cordonedByVMStatusAnnotation]
+	if node.Annotations[nodeVMStatusAnnotation] == status && hasTaint(node, vmPausedTaintKey) == taint && cordonedByUs == (taint && (cordonedByUs || !node.Spec.Unschedulable)) {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		node = node.DeepCopy()
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		if status != "" {
+			node.Annotations[nodeVMStatusAnnotation] = status
+		} else {
+			delete(node.Annotations, nodeVMStatusAnnotation)
+		}
+		var taints []corev1.Taint
+		for _, t := range node.Spec.Taints {
+			if t.Key != vmPausedTaintKey {
+				taints = append(taints, t)
+			}
+		}
+		_, cordonedByUs := node.Annotations[cordonedByVMStatusAnnotation]
+		if taint {
+			taints = append(taints, corev1.Taint{Key: vmPausedTaintKey, Value: status, Effect: corev1.TaintEffectNoSchedule})
+			if !node.Spec.Unschedulable {
+				node.Spec.Unschedulable = true
+				node.Annotations[cordonedByVMStatusAnnotation] = "true"
+			}
+		} else if cordonedByUs {
+			node.Spec.Unschedulable = false
+			delete(node.Annotations, cordonedByVMStatusAnnotation)
+		}
+		node.Spec.Taints = taints
+		_, err = c.kubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update node %s: %w", node.Name, err)
+	}
+	switch {
+	case taint:
+		klog.Infof("Cordoned and tainted node %s with %s=%s", node.Name, vmPausedTaintKey, status)
+	case hasTaint(node, vmPausedTaintKey):
+		klog.Infof("Removed taint %s from node %s", vmPausedTaintKey, node.Name)
+	}
+	return nil
+}
+
+// nodeEvent records the event on the node, like the kubelet does.
+func (c *OvirtNodeVMStatusController) nodeEvent(node *corev1.Node, eventType, reason, message string) {
+	ref := &corev1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: node.Name, UID: types.UID(node.Name)}
+	recorder := events.NewRecorder(c.kubeClient.CoreV1().Events(metav1.NamespaceDefault), c.eventRecorder.ComponentName(), ref)
+	if eventType == corev1.EventTypeWarning {
+		recorder.Warning(reason, message)
+	} else {
+		recorder.Event(reason, message)
+	}
+}
+
+func (c *OvirtNodeVMStatusController) updateCondition(ctx context.Context, messages []string) error {
+	condition := operatorapi.OperatorCondition{
+		Type:    nodeVMConditionType,
+		Status:  operatorapi.ConditionFalse,
+		Reason:  "AsExpected",
+		Message: "No VM of a node is paused or not responding",
+	}
+	if len(messages) > 0 {
+		sort.Strings(messages)
+		if len(messages) > maxReportedNodes {
+			messages = append(messages[:maxReportedNodes:maxReportedNodes], "...")
+		}
+		condition.Status = operatorapi.ConditionTrue
+		condition.Reason = "NodeVMsPaused"
+		condition.Message = fmt.Sprintf("VMs of nodes are paused or not responding: %s", strings.Join(messages, "; "))
+	}
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// storageDomainSuspects finds the storage domains a VM was likely paused by. The storage
+// domains are listed once per sync, when the first paused VM is found.
+type storageDomainSuspects struct {
+	ovirtClient    ovirtclient.Client
+	freePercent    int
+	storageDomains map[ovirtclient.StorageDomainID]ovirtclient.StorageDomain
+	capacities     map[ovirtclient.StorageDomainID]*storageDomainCapacity
+}
+
+// describe names the storage domains of the disks of the VM which are not active or low on
+// space, or all of them when none is.
+func (s *storageDomainSuspects) describe(ctx context.Context, vmID ovirtclient.VMID) (string, error) {
+	if s.storageDomains == nil {
+		storageDomains, err := s.ovirtClient.ListStorageDomains(ovirtclient.ContextStrategy(ctx))
+		if err != nil {
+			return "", fmt.Errorf("failed to list storage domains: %w", err)
+		}
+		s.storageDomains = map[ovirtclient.StorageDomainID]ovirtclient.StorageDomain{}
+		for _, sd := range storageDomains {
+			s.storageDomains[sd.ID()] = sd
+		}
+		if s.capacities, err = listStorageDomainCapacities(ctx, s.ovirtClient); err != nil {
+			return "", err
+		}
+	}
+
+	attachments, err := s.ovirtClient.ListDiskAttachments(vmID, ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to list the disk attachments of VM %s: %w", vmID, err)
+	}
+	ids := map[ovirtclient.StorageDomainID]bool{}
+	for _, attachment := range attachments {
+		disk, err := s.ovirtClient.GetDisk(attachment.DiskID(), ovirtclient.ContextStrategy(ctx))
+		if err != nil {
+			return "", fmt.Errorf("failed to get disk %s of VM %s: %w", attachment.DiskID(), vmID, err)
+		}
+		for _, id := range disk.StorageDomainIDs() {
+			ids[id] = true
+		}
+	}
+
+	var all, likely []string
+	for id := range ids {
+		sd, ok := s.storageDomains[id]
+		if !ok {
+			continue
+		}
+		all = append(all, sd.Name())
+		switch {
+		case sd.Status() != ovirtclient.StorageDomainStatusActive && sd.Status() != ovirtclient.StorageDomainStatusNA:
+			likely = append(likely, fmt.Sprintf("%s is %s", sd.Name(), sd.Status()))
+		case sd.ExternalStatus() == ovirtclient.StorageDomainExternalStatusError || sd.ExternalStatus() == ovirtclient.StorageDomainExternalStatusFailure:
+			likely = append(likely, fmt.Sprintf("%s reports %s", sd.Name(), sd.ExternalStatus()))
+		default:
+			if capacity, ok := s.capacities[id]; ok && capacity.size > 0 && capacity.available*100 < capacity.size*uint64(s.freePercent) {
+				likely = append(likely, fmt.Sprintf("%s has %s of %s free", sd.Name(), formatBytes(capacity.available), formatBytes(capacity.size)))
+			}
+		}
+	}
+	sort.Strings(all)
+	sort.Strings(likely)
+	switch {
+	case len(likely) > 0:
+		return "likely storage domain " + strings.Join(likely, ", "), nil
+	case len(all) > 0:
+		return "storage domains of its disks: " + strings.Join(all, ", "), nil
+	default:
+		return "no storage domain found", nil
+	}
+}
+
+func hasTaint(node *corev1.Node, key string) bool {
+	for _, t := range node.Spec.Taints {
+		if t.Key == key {
+			return true
+		}
+	}
+	return false
+}
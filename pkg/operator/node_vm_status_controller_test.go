@@ -0,0 +1,118 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeVMStatusSync(t *testing.T) {
+	const (
+		storageDomainID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1"
+		upVMID          = "3f9e7c52-1d4b-4e8a-9b0c-6a2d5f8e1c47"
+		pausedVMID      = "7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+		diskID          = "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f"
+	)
+	engine := startFakeEngine(t)
+	engine.AddStorageDomain(storageDomainID, "nfs", 80<<30, 20<<30)
+	engine.AddVM(upVMID, "worker-0")
+	engine.AddVM(pausedVMID, "worker-1")
+	if err := engine.AddDisk(diskID, "worker-1-boot", storageDomainID, 20<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.AttachDisk(pausedVMID, diskID, true); err != nil {
+		t.Fatal(err)
+	}
+	ovirtClient := newFakeEngineClient(t)
+
+	kubeClient, kubeInformers := newFakeKubeInformers(t,
+		newVMNode("worker-0", upVMID),
+		newVMNode("worker-1", strings.ToUpper(pausedVMID)),
+		// A node whose VM is not in the engine is left alone
+		newVMNode("bare-0", "5d0e6c3b-2f4a-4b1e-8c7d-9a8b7c6d5e4f"),
+	)
+	operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{}, nil)
+	config := &OperatorConfig{Node: NodeConfig{CordonPausedVMs: true}}
+	config.setDefaults()
+	c := &OvirtNodeVMStatusController{
+		name:               "OvirtNodeVMStatusController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		nodeLister:         kubeInformers.Core().V1().Nodes().Lister(),
+		getConfig:          func() (*OperatorConfig, error) { return config, nil },
+		ovirtClientFactory: func() (ovirtclient.Client, error) { return ovirtClient, nil },
+		eventRecorder:      events.NewInMemoryRecorder("test"),
+	}
+	// sync runs the controller and feeds the updated nodes back into the informer, as the
+	// next sync would see them
+	sync := func() {
+		t.Helper()
+		if err := c.sync(context.Background(), nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		nodes, err := kubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range nodes.Items {
+			if err := kubeInformers.Core().V1().Nodes().Informer().GetIndexer().Update(&nodes.Items[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectNode := func(name, status string, tainted, cordoned bool) {
+		t.Helper()
+		node, err := kubeClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if annotation := node.Annotations[nodeVMStatusAnnotation]; annotation != status {
+			t.Errorf("expected node %s annotated with VM status %q, got %q", name, status, annotation)
+		}
+		if hasTaint(node, vmPausedTaintKey) != tainted {
+			t.Errorf("expected node %s tainted %t, got %v", name, tainted, node.Spec.Taints)
+		}
+		if node.Spec.Unschedulable != cordoned {
+			t.Errorf("expected node %s cordoned %t", name, cordoned)
+		}
+	}
+
+	sync()
+	expectOperatorCondition(t, operatorClient, nodeVMConditionType, opv1.ConditionFalse, "AsExpected")
+	if requests := engine.Requests("/api/vms"); requests != 1 {
+		t.Errorf("expected one VM list request, got %d", requests)
+	}
+
+	if err := engine.SetVMStatus(pausedVMID, ovirtsdk.VMSTATUS_PAUSED); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	expectOperatorCondition(t, operatorClient, nodeVMConditionType, opv1.ConditionTrue, "NodeVMsPaused")
+	expectNode("worker-0", "", false, false)
+	expectNode("worker-1", "paused", true, true)
+	expectNode("bare-0", "", false, false)
+
+	if err := engine.SetVMStatus(pausedVMID, ovirtsdk.VMSTATUS_UP); err != nil {
+		t.Fatal(err)
+	}
+	sync()
+	expectOperatorCondition(t, operatorClient, nodeVMConditionType, opv1.ConditionFalse, "AsExpected")
+	expectNode("worker-1", "", false, false)
+
+	// Each sync lists the VMs once, the VMs of the nodes are not fetched one by one
+	if requests := engine.Requests("/api/vms"); requests != 3 {
+		t.Errorf("expected three VM list requests, got %d", requests)
+	}
+	for _, id := range []string{upVMID, pausedVMID} {
+		if requests := engine.Requests("/api/vms/" + id); requests != 0 {
+			t.Errorf("expected no request for VM %s, got %d", id, requests)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtsdk "github.com/ovirt/go-ovirt"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// demotedStorageClassAnnotation marks a StorageClass the operator made non-default
+	// because its storage domain is overcommitted
+	demotedStorageClassAnnotation = "csi.ovirt.org/demoted-by-overcommit"
+
+	overcommitConditionType = "OvirtStorageDomainOvercommitted"
+)
+
+// storageDomainCapacity holds the figures of a storage domain relevant for overcommit.
+type storageDomainCapacity struct {
+	name        string
+	size        uint64
+	available   uint64
+	provisioned uint64
+}
+
+// OvirtOvercommitController compares the provisioned size of the CSI disks in each storage
+// domain with its size. It reports the storage domains above the configured limit and
+// optionally marks their StorageClasses non-default.
+type OvirtOvercommitController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	pvLister           corelisters.PersistentVolumeLister
+	storageClassLister storagelisters.StorageClassLister
+	getConfig          func() (*OperatorConfig, error)
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	listDisks          func(ctx context.Context) (*diskListing, error)
+	eventRecorder      events.Recorder
+}
+
+func NewOvirtOvercommitController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	storageClassInformer storagev1informers.StorageClassInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	listDisks func(ctx context.Context) (*diskListing, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtOvercommitController{
+		name:               "OvirtOvercommitController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		pvLister:           pvInformer.Lister(),
+		storageClassLister: storageClassInformer.Lister(),
+		getConfig:          getConfig,
+		ovirtClientFactory: ovirtClientFactory,
+		listDisks:          listDisks,
+		eventRecorder:      eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		pvInformer.Informer(),
+		storageClassInformer.Informer(),
+		configMapInformer.Informer(),
+	).ResyncEvery(volumeUsageResync).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtOvercommitController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping overcommit check: %v", err)
+		return nil
+	}
+	// The capacity of every storage domain is published regardless of the overcommit check,
+	// the free space alert depends on it
+	capacities, err := c.getCapacities(ctx)
+	if err != nil {
+		return err
+	}
+	storageDomainProvisionedBytes.Reset()
+	storageDomainSizeBytes.Reset()
+	storageDomainAvailableBytes.Reset()
+	for _, capacity := range capacities {
+		storageDomainProvisionedBytes.WithLabelValues(capacity.name).Set(float64(capacity.provisioned))
+		storageDomainSizeBytes.WithLabelValues(capacity.name).Set(float64(capacity.size))
+		storageDomainAvailableBytes.WithLabelValues(capacity.name).Set(float64(capacity.available))
+	}
+
+	maxPercent := *config.Overcommit.MaxPercent
+	if maxPercent == 0 {
+		if err := c.enforce(ctx, sets.NewString(), config.Maintenance); err != nil {
+			return err
+		}
+		return c.updateCondition(ctx, operatorapi.ConditionFalse, "Disabled", "The overcommit check is disabled")
+	}
+
+	overcommitted := sets.NewString()
+	var messages []string
+	for _, capacity := range capacities {
+		if capacity.provisioned == 0 {
+			continue
+		}
+		if capacity.size == 0 || capacity.provisioned*100 > capacity.size*uint64(maxPercent) {
+			overcommitted.Insert(capacity.name)
+			messages = append(messages, fmt.Sprintf("%s (%s of %s provisioned)", capacity.name, formatBytes(capacity.provisioned), formatBytes(capacity.size)))
+		}
+	}
+
+	if config.Overcommit.Enforcement == OvercommitEnforcementDemoteStorageClass {
+		err = c.enforce(ctx, overcommitted, config.Maintenance)
+	} else {
+		err = c.enforce(ctx, sets.NewString(), config.Maintenance)
+	}
+	if err != nil {
+		return err
+	}
+
+	if overcommitted.Len() == 0 {
+		return c.updateCondition(ctx, operatorapi.ConditionFalse, "AsExpected",
+			fmt.Sprintf("No storage domain exceeds %d%% provisioned", maxPercent))
+	}
+	sort.Strings(messages)
+	return c.updateCondition(ctx, operatorapi.ConditionTrue, "StorageDomainOvercommitted",
+		fmt.Sprintf("Storage domains exceed %d%% provisioned: %s", maxPercent, strings.Join(messages, ", ")))
+}
+
+// getCapacities returns the capacities of all storage domains, with the provisioned size of
+// the disks of the driver's PVs.
+func (c *OvirtOvercommitController) getCapacities(ctx context.Context) (map[ovirtclient.StorageDomainID]*storageDomainCapacity, error) {
+	pvs, err := driverPersistentVolumes(c.pvLister)
+	if err != nil {
+		return nil, err
+	}
+	volumeHandles := sets.NewString()
+	for _, pv := range pvs {
+		volumeHandles.Insert(pv.Spec.CSI.VolumeHandle)
+	}
+
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	capacities, err := listStorageDomainCapacities(ctx, ovirtClient)
+	if err != nil {
+		return nil, err
+	}
+	listing, err := c.listDisks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, disk := range listing.disks {
+		if !volumeHandles.Has(string(disk.ID())) {
+			continue
+		}
+		for _, id := range disk.StorageDomainIDs() {
+			if capacity, ok := capacities[id]; ok {
+				capacity.provisioned += disk.ProvisionedSize()
+			}
+		}
+	}
+	return capacities, nil
+}
+
+// enforce marks the default StorageClasses of the driver on overcommitted storage domains
+// non-default and restores the ones it marked before on the other storage domains. Nothing is
+// restored in maintenance, the StorageClasses are restored once it ends.
+func (c *OvirtOvercommitController) enforce(ctx context.Context, overcommitted sets.String, maintenance bool) error {
+	storageClasses, err := c.storageClassLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, sc := range storageClasses {
+		if sc.Provisioner != instanceName {
+			continue
+		}
+		storageDomain := sc.Parameters["storageDomainName"]
+		_, demoted := sc.Annotations[demotedStorageClassAnnotation]
+		switch {
+		case overcommitted.Has(storageDomain) && sc.Annotations[defaultStorageClassAnnotation] == "true":
+			if err := patchDefaultStorageClass(ctx, c.kubeClient, sc, "false", demotedStorageClassAnnotation, "true"); err != nil {
+				return err
+			}
+			c.eventRecorder.Warningf("StorageClassDemoted", "StorageClass %s is no longer the default, its storage domain %s is overcommitted", sc.Name, storageDomain)
+		case !overcommitted.Has(storageDomain) && demoted && !maintenance:
+			if err := patchDefaultStorageClass(ctx, c.kubeClient, sc, "true", demotedStorageClassAnnotation, nil); err != nil {
+				return err
+			}
+			c.eventRecorder.Eventf("StorageClassRestored", "StorageClass %s is the default again, its storage domain %s is no longer overcommitted", sc.Name, storageDomain)
+		}
+	}
+	return nil
+}
+
+// patchDefaultStorageClass sets the default StorageClass annotation and the marker annotation
+// of the controller which demoted it, nil removes the marker.
+func patchDefaultStorageClass(ctx context.Context, kubeClient kubernetes.Interface, sc *storagev1.StorageClass, isDefault, marker string, demoted interface{}) error {
+	return patchStorageClassAnnotations(ctx, kubeClient, sc, map[string]interface{}{
+		defaultStorageClassAnnotation: isDefault,
+		marker:                        demoted,
+	})
+}
+
+// patchStorageClassAnnotations merges the annotations into the StorageClass, nil values remove
+// them.
+func patchStorageClassAnnotations(ctx context.Context, kubeClient kubernetes.Interface, sc *storagev1.StorageClass, annotations map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := kubeClient.StorageV1().StorageClasses().Patch(ctx, sc.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch StorageClass %s: %w", sc.Name, err)
+	}
+	return nil
+}
+
+func (c *OvirtOvercommitController) updateCondition(ctx context.Context, status operatorapi.ConditionStatus, reason, message string) error {
+	condition := operatorapi.OperatorCondition{
+		Type:    overcommitConditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+	_, oldStatus, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	previous := v1helpers.FindOperatorCondition(oldStatus.Conditions, overcommitConditionType)
+	if status == operatorapi.ConditionTrue && (previous == nil || previous.Status != operatorapi.ConditionTrue) {
+		c.eventRecorder.Warning("StorageDomainOvercommitted", message)
+	}
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// listStorageDomainCapacities returns the storage domains by their ID. The used space is not
+// available through the oVirt client, it is read through the SDK. Storage domains whose size
+// cannot be read are an error, any other size would hide or fake an overcommit.
+func listStorageDomainCapacities(ctx context.Context, ovirtClient ovirtclient.Client) (map[ovirtclient.StorageDomainID]*storageDomainCapacity, error) {
+	legacyClient, ok := ovirtClient.(ovirtclient.ClientWithLegacySupport)
+	if !ok {
+		return nil, fmt.Errorf("failed to list storage domains: the oVirt client cannot read their size")
+	}
+	response, err := legacyClient.GetSDKClient().SystemService().StorageDomainsService().List().Send()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage domains: %w", err)
+	}
+	capacities := map[ovirtclient.StorageDomainID]*storageDomainCapacity{}
+	storageDomains, ok := response.StorageDomains()
+	if !ok {
+		return capacities, nil
+	}
+	for _, sd := range storageDomains.Slice() {
+		id, _ := sd.Id()
+		name, _ := sd.Name()
+		available, availableOK := sd.Available()
+		used, usedOK := sd.Used()
+		if !availableOK || !usedOK {
+			// Only data storage domains hold disks
+			if sdType, _ := sd.Type(); sdType != ovirtsdk.STORAGEDOMAINTYPE_DATA {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read the size of storage domain %s", name)
+		}
+		capacities[ovirtclient.StorageDomainID(id)] = &storageDomainCapacity{name: name, size: uint64(available + used), available: uint64(available)}
+	}
+	return capacities, nil
+}
+
+// formatBytes formats a size in binary units for condition messages.
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,152 @@
+package operator
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+	"github.com/ovirt/csi-driver-operator/internal/ovirt/fakeengine"
+)
+
+func TestListStorageDomainCapacities(t *testing.T) {
+	const nfsID, unknownID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1", "0e9d8c7b-6a5f-4e3d-2c1b-0a9f8e7d6c5b"
+
+	t.Run("size is available plus used space", func(t *testing.T) {
+		engine := startFakeEngine(t)
+		engine.AddStorageDomain(nfsID, "nfs", 80<<30, 20<<30)
+		capacities, err := listStorageDomainCapacities(context.Background(), newFakeEngineClient(t))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		capacity, ok := capacities[nfsID]
+		if !ok {
+			t.Fatalf("expected storage domain %s in %v", nfsID, capacities)
+		}
+		if capacity.name != "nfs" || capacity.size != 100<<30 || capacity.available != 80<<30 {
+			t.Errorf("unexpected capacity %+v", *capacity)
+		}
+	})
+
+	t.Run("unknown size", func(t *testing.T) {
+		engine := startFakeEngine(t)
+		engine.AddStorageDomain(nfsID, "nfs", 80<<30, 20<<30)
+		engine.AddStorageDomainWithoutSize(unknownID, "unknown")
+		_, err := listStorageDomainCapacities(context.Background(), newFakeEngineClient(t))
+		if err == nil || !strings.Contains(err.Error(), "storage domain unknown") {
+			t.Errorf("expected an error for the storage domain without size, got %v", err)
+		}
+	})
+
+	t.Run("client without the SDK", func(t *testing.T) {
+		if _, err := listStorageDomainCapacities(context.Background(), ovirtclient.NewMock()); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestOvercommitSync(t *testing.T) {
+	const nfsID, emptyID, diskID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1", "0e9d8c7b-6a5f-4e3d-2c1b-0a9f8e7d6c5b", "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f"
+
+	testCases := []struct {
+		name         string
+		maxPercent   int
+		expectStatus opv1.ConditionStatus
+		expectReason string
+	}{
+		{name: "check disabled", maxPercent: 0, expectStatus: opv1.ConditionFalse, expectReason: "Disabled"},
+		{name: "below the limit", maxPercent: 200, expectStatus: opv1.ConditionFalse, expectReason: "AsExpected"},
+		{name: "above the limit", maxPercent: 100, expectStatus: opv1.ConditionTrue, expectReason: "StorageDomainOvercommitted"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := startFakeEngine(t)
+			engine.AddStorageDomain(nfsID, "nfs", 80<<30, 20<<30)
+			engine.AddStorageDomain(emptyID, "empty", 40<<30, 10<<30)
+			if err := engine.AddDisk(diskID, "pvc-1", nfsID, 150<<30); err != nil {
+				t.Fatal(err)
+			}
+			ovirtClient := newFakeEngineClient(t)
+
+			_, kubeInformers := newFakeKubeInformers(t, newDriverPV("pv-1", diskID, time.Now(), nil))
+			operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{}, nil)
+			config := &OperatorConfig{Overcommit: OvercommitConfig{MaxPercent: intPtr(tc.maxPercent)}}
+			config.setDefaults()
+			ovirtClientFactory := func() (ovirtclient.Client, error) { return ovirtClient, nil }
+			c := &OvirtOvercommitController{
+				name:               "OvirtOvercommitController",
+				operatorClient:     operatorClient,
+				pvLister:           kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				storageClassLister: kubeInformers.Storage().V1().StorageClasses().Lister(),
+				getConfig:          func() (*OperatorConfig, error) { return config, nil },
+				ovirtClientFactory: ovirtClientFactory,
+				listDisks:          newDiskLister(ovirtClientFactory, diskListingMaxAge, time.Now),
+				eventRecorder:      events.NewInMemoryRecorder("test"),
+			}
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expectOperatorCondition(t, operatorClient, overcommitConditionType, tc.expectStatus, tc.expectReason)
+
+			// The capacity of every storage domain is published, with or without CSI disks
+			for name, expected := range map[string]map[string]float64{
+				"nfs":   {"size": 100 << 30, "available": 80 << 30, "provisioned": 150 << 30},
+				"empty": {"size": 50 << 30, "available": 40 << 30, "provisioned": 0},
+			} {
+				for gauge, value := range map[string]float64{
+					"size":        getGaugeValue(t, storageDomainSizeBytes.WithLabelValues(name)),
+					"available":   getGaugeValue(t, storageDomainAvailableBytes.WithLabelValues(name)),
+					"provisioned": getGaugeValue(t, storageDomainProvisionedBytes.WithLabelValues(name)),
+				} {
+					if value != expected[gauge] {
+						t.Errorf("expected %s bytes %v of storage domain %s, got %v", gauge, expected[gauge], name, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func getGaugeValue(t *testing.T, gauge metrics.GaugeMetric) float64 {
+	t.Helper()
+	value, err := testutil.GetGaugeMetricValue(gauge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// startFakeEngine starts a fake engine and points OVIRT_CONFIG to its config.
+func startFakeEngine(t *testing.T) *fakeengine.Engine {
+	t.Helper()
+	engine, err := fakeengine.New()
+	if err != nil {
+		t.Fatalf("failed to start the fake engine: %v", err)
+	}
+	t.Cleanup(engine.Close)
+	configFile := filepath.Join(t.TempDir(), "ovirt-config.yaml")
+	if err := engine.WriteConfig(configFile); err != nil {
+		t.Fatalf("failed to write the engine config: %v", err)
+	}
+	t.Setenv("OVIRT_CONFIG", configFile)
+	return engine
+}
+
+// newFakeEngineClient connects to the fake engine of startFakeEngine.
+func newFakeEngineClient(t *testing.T) ovirtclient.Client {
+	t.Helper()
+	ovirtClient, err := ovirt.NewClient()
+	if err != nil {
+		t.Fatalf("failed to connect to the fake engine: %v", err)
+	}
+	return ovirtClient
+}
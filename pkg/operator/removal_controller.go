@@ -0,0 +1,340 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/management"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// forceRemovalAnnotation on the ClusterCSIDriver removes the operand although PVs of the
+	// driver still exist, they can no longer be attached, resized or deleted afterwards
+	forceRemovalAnnotation = "csi.ovirt.org/force-removal"
+
+	// removedReason is the reason of the condition once the operand is removed
+	removedReason = "Removed"
+
+	controllerDeploymentName = "ovirt-csi-driver-controller"
+	nodeDaemonSetName        = "ovirt-csi-driver-node"
+
+	// removedResync repeats the removal while Removed: a controller which synced just before
+	// it observed the managementState may recreate a part of the operand afterwards
+	removedResync = time.Minute
+)
+
+// nodeMarkerAnnotations are the annotations the node controllers put on nodes
+var nodeMarkerAnnotations = []string{nodePluginReadyAnnotation, nodeVMStatusAnnotation, cordonedByVMStatusAnnotation}
+
+// nodeMarkerTaints are the keys of the taints the node controllers put on nodes
+var nodeMarkerTaints = []string{startupTaintKey, vmPausedTaintKey}
+
+// storageClassMarkerAnnotations are the annotations the StorageClass controllers put on
+// StorageClasses
+var storageClassMarkerAnnotations = []string{
+	demotedStorageClassAnnotation,
+	maintenanceStorageClassAnnotation,
+	failoverDemotedStorageClassAnnotation,
+	failoverPromotedStorageClassAnnotation,
+}
+
+// operandResources are static resources of the operand deleted through one set of clients.
+type operandResources struct {
+	clients *resourceapply.ClientHolder
+	assets  resourceapply.AssetFunc
+	files   []string
+}
+
+// OvirtOperandRemovalController removes the operand when the managementState of the
+// ClusterCSIDriver is Removed or the ClusterCSIDriver is deleted: the controller Deployment,
+// the node DaemonSet, the StorageClasses of the driver and the static resources, i.e. the
+// CSIDriver, ServiceMonitor and RBAC. The label, taints and annotations the operator put on
+// nodes are removed as well, nodes cordoned because of a paused VM are uncordoned. Its
+// finalizer keeps a deleted ClusterCSIDriver until the operand is gone. The removal is refused
+// while PVs of the driver exist, unless the ClusterCSIDriver carries the
+// csi.ovirt.org/force-removal annotation. The other controllers changing nodes,
+// StorageClasses or engine disks do nothing unless Managed, see operandManaged.
+type OvirtOperandRemovalController struct {
+	name                   string
+	operatorClient         v1helpers.OperatorClientWithFinalizers
+	kubeClient             kubernetes.Interface
+	controlPlaneKubeClient kubernetes.Interface
+	controlPlaneNamespace  string
+	resources              []operandResources
+	pvLister               corelisters.PersistentVolumeLister
+	nodeLister             corelisters.NodeLister
+	eventRecorder          events.Recorder
+}
+
+func NewOvirtOperandRemovalController(
+	operatorClient v1helpers.OperatorClientWithFinalizers,
+	kubeClient kubernetes.Interface,
+	controlPlaneKubeClient kubernetes.Interface,
+	controlPlaneNamespace string,
+	resources []operandResources,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	nodeInformer corev1informers.NodeInformer,
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtOperandRemovalController{
+		name:                   "OvirtOperandRemovalController",
+		operatorClient:         operatorClient,
+		kubeClient:             kubeClient,
+		controlPlaneKubeClient: controlPlaneKubeClient,
+		controlPlaneNamespace:  controlPlaneNamespace,
+		resources:              resources,
+		pvLister:               pvInformer.Lister(),
+		nodeLister:             nodeInformer.Lister(),
+		eventRecorder:          eventRecorder,
+	}
+	// Node status updates would trigger a removal every few seconds while Removed
+	return factory.New().WithSync(c.sync).WithInformers(
+		operatorClient.Informer(),
+		pvInformer.Informer(),
+	).WithBareInformers(
+		nodeInformer.Informer(),
+	).ResyncEvery(removedResync).ToController(c.name, c.eventRecorder)
+}
+
+// operandManaged reports whether the managementState of the ClusterCSIDriver is Managed. The
+// controllers changing nodes, StorageClasses, workloads or engine disks skip their sync
+// otherwise: an Unmanaged operand is left as it is and a Removed one is cleaned up by the
+// OvirtOperandRemovalController.
+func operandManaged(operatorClient v1helpers.OperatorClient) (bool, error) {
+	opSpec, _, _, err := operatorClient.GetOperatorState()
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return opSpec.ManagementState == operatorapi.Managed, nil
+}
+
+func (c *OvirtOperandRemovalController) sync(ctx context.Context, _ factory.SyncContext) error {
+	opSpec, _, resourceVersion, err := c.operatorClient.GetOperatorState()
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	meta, err := c.operatorClient.GetObjectMeta()
+	if err != nil {
+		return err
+	}
+
+	deleting := meta.DeletionTimestamp != nil
+	switch {
+	case management.IsOperatorUnknownState(opSpec.ManagementState) && !deleting:
+		return c.updateCondition(ctx, operatorapi.ConditionTrue, "Unknown", fmt.Sprintf("Unsupported management state %q", opSpec.ManagementState))
+	case opSpec.ManagementState == operatorapi.Managed && !deleting:
+		if err := v1helpers.EnsureFinalizer(ctx, c.operatorClient, c.name); err != nil {
+			return err
+		}
+		return c.updateCondition(ctx, operatorapi.ConditionFalse, "AsExpected", "")
+	case opSpec.ManagementState != operatorapi.Removed && !deleting:
+		// Unmanaged, leave the operand as it is
+		return nil
+	}
+	// Removed already or not, the removal is repeated: nothing is deleted twice, but a part
+	// of the operand recreated by a controller that had not observed Removed yet is removed
+	pvs, err := driverPersistentVolumes(c.pvLister)
+	if err != nil {
+		return err
+	}
+	if len(pvs) > 0 && meta.Annotations[forceRemovalAnnotation] != "true" {
+		names := make([]string, 0, maxReportedVolumes+1)
+		for i, pv := range pvs {
+			if i == maxReportedVolumes {
+				names = append(names, "...")
+				break
+			}
+			names = append(names, pv.Name)
+		}
+		klog.Infof("Not removing the operand, %d PVs of the driver exist", len(pvs))
+		return c.updateCondition(ctx, operatorapi.ConditionTrue, "VolumesExist", fmt.Sprintf(
+			"The operand is not removed while %d PVs of the driver exist, delete them or set the %s=true annotation: %s",
+			len(pvs), forceRemovalAnnotation, strings.Join(names, ", ")))
+	}
+
+	if deleting && opSpec.ManagementState != operatorapi.Removed {
+		// Stop the other controllers from recreating the operand of the deleted
+		// ClusterCSIDriver, the removal continues once the change is observed
+		spec := opSpec.DeepCopy()
+		spec.ManagementState = operatorapi.Removed
+		if _, _, err := c.operatorClient.UpdateOperatorSpec(ctx, resourceVersion, spec); err != nil {
+			return fmt.Errorf("failed to set managementState Removed: %w", err)
+		}
+		klog.Infof("The ClusterCSIDriver is deleted, set its managementState to Removed")
+		return nil
+	}
+
+	if err := c.removeOperand(ctx); err != nil {
+		if condErr := c.updateCondition(ctx, operatorapi.ConditionTrue, "RemovalFailed", err.Error()); condErr != nil {
+			klog.Errorf("Failed to update the status: %v", condErr)
+		}
+		return err
+	}
+	if err := c.updateCondition(ctx, operatorapi.ConditionFalse, removedReason, "The operand is removed"); err != nil {
+		return err
+	}
+	// All removed, remove the finalizer as the last step
+	return v1helpers.RemoveFinalizer(ctx, c.operatorClient, c.name)
+}
+
+// removeOperand deletes the workloads first, so that the driver stops using its RBAC.
+func (c *OvirtOperandRemovalController) removeOperand(ctx context.Context) error {
+	err := c.controlPlaneKubeClient.AppsV1().Deployments(c.controlPlaneNamespace).Delete(ctx, controllerDeploymentName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Deployment %s/%s: %w", c.controlPlaneNamespace, controllerDeploymentName, err)
+	} else if err == nil {
+		c.eventRecorder.Eventf("DeploymentDeleted", "Deleted Deployment %s/%s", c.controlPlaneNamespace, controllerDeploymentName)
+	}
+	err = c.kubeClient.AppsV1().DaemonSets(defaultNamespace).Delete(ctx, nodeDaemonSetName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete DaemonSet %s/%s: %w", defaultNamespace, nodeDaemonSetName, err)
+	} else if err == nil {
+		c.eventRecorder.Eventf("DaemonSetDeleted", "Deleted DaemonSet %s/%s", defaultNamespace, nodeDaemonSetName)
+	}
+
+	storageClasses, err := c.kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list StorageClasses: %w", err)
+	}
+	for _, sc := range storageClasses.Items {
+		if sc.Provisioner != instanceName {
+			// A StorageClass copied from one of the driver may carry its markers
+			if err := c.removeStorageClassMarkers(ctx, &sc); err != nil {
+				return err
+			}
+			continue
+		}
+		err := c.kubeClient.StorageV1().StorageClasses().Delete(ctx, sc.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete StorageClass %s: %w", sc.Name, err)
+		} else if err == nil {
+			c.eventRecorder.Eventf("StorageClassDeleted", "Deleted StorageClass %s", sc.Name)
+		}
+	}
+
+	if err := c.restoreNodes(ctx); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, r := range c.resources {
+		for _, result := range resourceapply.DeleteAll(ctx, r.clients, c.eventRecorder, r.assets, r.files...) {
+			if result.Error != nil {
+				errs = append(errs, fmt.Errorf("failed to delete %q: %w", result.File, result.Error))
+			}
+		}
+	}
+	return v1helpers.NewMultiLineAggregate(errs)
+}
+
+// removeStorageClassMarkers removes the marker annotations of the StorageClass controllers.
+func (c *OvirtOperandRemovalController) removeStorageClassMarkers(ctx context.Context, sc *storagev1.StorageClass) error {
+	annotations := map[string]interface{}{}
+	for _, marker := range storageClassMarkerAnnotations {
+		if _, ok := sc.Annotations[marker]; ok {
+			annotations[marker] = nil
+		}
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return patchStorageClassAnnotations(ctx, c.kubeClient, sc, annotations)
+}
+
+// restoreNodes removes the label, taints and annotations of the node controllers from the
+// nodes and uncordons the nodes cordoned because of a paused VM.
+func (c *OvirtOperandRemovalController) restoreNodes(ctx context.Context) error {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if !hasNodeMarkers(node) {
+			continue
+		}
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, node.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			node = node.DeepCopy()
+			if _, ok := node.Annotations[cordonedByVMStatusAnnotation]; ok {
+				node.Spec.Unschedulable = false
+			}
+			for _, annotation := range nodeMarkerAnnotations {
+				delete(node.Annotations, annotation)
+			}
+			delete(node.Labels, ovirtVMNodeLabel)
+			var taints []corev1.Taint
+			for _, taint := range node.Spec.Taints {
+				if !sets.NewString(nodeMarkerTaints...).Has(taint.Key) {
+					taints = append(taints, taint)
+				}
+			}
+			node.Spec.Taints = taints
+			_, err = c.kubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+			return err
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore node %s: %w", node.Name, err)
+		}
+		klog.Infof("Removed the markers of the operator from node %s", node.Name)
+	}
+	return nil
+}
+
+// hasNodeMarkers reports whether the node carries a label, taint or annotation of the node
+// controllers.
+func hasNodeMarkers(node *corev1.Node) bool {
+	if _, ok := node.Labels[ovirtVMNodeLabel]; ok {
+		return true
+	}
+	for _, annotation := range nodeMarkerAnnotations {
+		if _, ok := node.Annotations[annotation]; ok {
+			return true
+		}
+	}
+	for _, key := range nodeMarkerTaints {
+		if hasTaint(node, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *OvirtOperandRemovalController) updateCondition(ctx context.Context, status operatorapi.ConditionStatus, reason, message string) error {
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(operatorapi.OperatorCondition{
+		Type:    c.name + operatorapi.OperatorStatusTypeDegraded,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}))
+	return err
+}
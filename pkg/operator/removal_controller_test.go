@@ -0,0 +1,241 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/ovirt/csi-driver-operator/assets"
+)
+
+func TestOperandRemovalSync(t *testing.T) {
+	now := metav1.NewTime(time.Now())
+	// markedNode carries all markers of the node controllers and was cordoned because of its VM
+	markedNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "worker-0",
+			Labels: map[string]string{ovirtVMNodeLabel: "true", "kubernetes.io/os": "linux"},
+			Annotations: map[string]string{
+				nodePluginReadyAnnotation:    "2023-01-01T00:00:00Z",
+				nodeVMStatusAnnotation:       "paused",
+				cordonedByVMStatusAnnotation: "true",
+			},
+		},
+		Spec: corev1.NodeSpec{
+			Unschedulable: true,
+			Taints: []corev1.Taint{
+				startupTaint,
+				{Key: vmPausedTaintKey, Value: "paused", Effect: corev1.TaintEffectNoSchedule},
+				{Key: "dedicated", Value: "storage", Effect: corev1.TaintEffectNoSchedule},
+			},
+		},
+	}
+	// cordonedNode was cordoned by an administrator, it stays cordoned
+	cordonedNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-1", Annotations: map[string]string{nodeVMStatusAnnotation: "paused"}},
+		Spec: corev1.NodeSpec{
+			Unschedulable: true,
+			Taints:        []corev1.Taint{{Key: vmPausedTaintKey, Value: "paused", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+	driverSC := &storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "ovirt-csi-sc", Annotations: map[string]string{maintenanceStorageClassAnnotation: "true"}},
+		Provisioner: instanceName,
+	}
+	// copiedSC was copied from a StorageClass of the driver, with its markers
+	copiedSC := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "copied", Annotations: map[string]string{
+			demotedStorageClassAnnotation:          "true",
+			failoverPromotedStorageClassAnnotation: "ovirt-csi-sc",
+			defaultStorageClassAnnotation:          "false",
+		}},
+		Provisioner: "example.com/other",
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: controllerDeploymentName, Namespace: defaultNamespace}}
+	daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: nodeDaemonSetName, Namespace: defaultNamespace}}
+	csiDriver := &storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: instanceName}}
+	pv := newDriverPV("pv-a", "disk-a", now.Time, nil)
+
+	testCases := []struct {
+		name            string
+		managementState opv1.ManagementState
+		annotations     map[string]string
+		deleting        bool
+		objects         []runtime.Object
+		expectReason    string
+		expectRemoved   bool
+		expectFinalizer bool
+		expectSpecState opv1.ManagementState
+	}{
+		{
+			name:            "managed operand gets the finalizer",
+			managementState: opv1.Managed,
+			objects:         []runtime.Object{deployment, daemonSet, csiDriver, driverSC, markedNode},
+			expectReason:    "AsExpected",
+			expectFinalizer: true,
+			expectSpecState: opv1.Managed,
+		},
+		{
+			name:            "removal refused while PVs exist",
+			managementState: opv1.Removed,
+			objects:         []runtime.Object{deployment, daemonSet, csiDriver, driverSC, markedNode, pv},
+			expectReason:    "VolumesExist",
+			expectFinalizer: true,
+			expectSpecState: opv1.Removed,
+		},
+		{
+			name:            "forced removal with PVs",
+			managementState: opv1.Removed,
+			annotations:     map[string]string{forceRemovalAnnotation: "true"},
+			objects:         []runtime.Object{deployment, daemonSet, csiDriver, driverSC, copiedSC, markedNode, cordonedNode, pv},
+			expectReason:    removedReason,
+			expectRemoved:   true,
+			expectSpecState: opv1.Removed,
+		},
+		{
+			name:            "removal",
+			managementState: opv1.Removed,
+			objects:         []runtime.Object{deployment, daemonSet, csiDriver, driverSC, copiedSC, markedNode, cordonedNode},
+			expectReason:    removedReason,
+			expectRemoved:   true,
+			expectSpecState: opv1.Removed,
+		},
+		{
+			name:            "deleted ClusterCSIDriver is set to Removed first",
+			managementState: opv1.Managed,
+			deleting:        true,
+			objects:         []runtime.Object{deployment, daemonSet, csiDriver, driverSC, markedNode},
+			expectFinalizer: true,
+			expectSpecState: opv1.Removed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, obj := range tc.objects {
+				objects = append(objects, obj.DeepCopyObject())
+			}
+			kubeClient, kubeInformers := newFakeKubeInformers(t, objects...)
+			meta := &metav1.ObjectMeta{Name: instanceName, Annotations: tc.annotations}
+			if tc.deleting {
+				meta.DeletionTimestamp = &now
+			}
+			operatorClient := v1helpers.NewFakeOperatorClientWithObjectMeta(meta, &opv1.OperatorSpec{ManagementState: tc.managementState}, &opv1.OperatorStatus{}, nil)
+			if tc.managementState != opv1.Managed || tc.deleting {
+				// The finalizer was added while Managed
+				if err := v1helpers.EnsureFinalizer(context.Background(), operatorClient, "OvirtOperandRemovalController"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c := &OvirtOperandRemovalController{
+				name:                   "OvirtOperandRemovalController",
+				operatorClient:         operatorClient,
+				kubeClient:             kubeClient,
+				controlPlaneKubeClient: kubeClient,
+				controlPlaneNamespace:  defaultNamespace,
+				resources: []operandResources{{
+					clients: (&resourceapply.ClientHolder{}).WithKubernetes(kubeClient),
+					assets:  assets.ReadFile,
+					files:   []string{"csidriver.yaml"},
+				}},
+				pvLister:      kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				nodeLister:    kubeInformers.Core().V1().Nodes().Lister(),
+				eventRecorder: events.NewInMemoryRecorder("test"),
+			}
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			spec, _, _, err := operatorClient.GetOperatorState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spec.ManagementState != tc.expectSpecState {
+				t.Errorf("expected managementState %s, got %s", tc.expectSpecState, spec.ManagementState)
+			}
+			if tc.expectReason != "" {
+				status := opv1.ConditionFalse
+				if tc.expectReason == "VolumesExist" {
+					status = opv1.ConditionTrue
+				}
+				expectOperatorCondition(t, operatorClient, "OvirtOperandRemovalControllerDegraded", status, tc.expectReason)
+			}
+			meta, err = operatorClient.GetObjectMeta()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasFinalizer := len(meta.Finalizers) > 0; hasFinalizer != tc.expectFinalizer {
+				t.Errorf("expected finalizer %t, got %v", tc.expectFinalizer, meta.Finalizers)
+			}
+
+			ctx := context.Background()
+			exists := map[string]func() error{
+				"Deployment": func() error {
+					_, err := kubeClient.AppsV1().Deployments(defaultNamespace).Get(ctx, controllerDeploymentName, metav1.GetOptions{})
+					return err
+				},
+				"DaemonSet": func() error {
+					_, err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Get(ctx, nodeDaemonSetName, metav1.GetOptions{})
+					return err
+				},
+				"CSIDriver": func() error {
+					_, err := kubeClient.StorageV1().CSIDrivers().Get(ctx, instanceName, metav1.GetOptions{})
+					return err
+				},
+				"StorageClass": func() error {
+					_, err := kubeClient.StorageV1().StorageClasses().Get(ctx, driverSC.Name, metav1.GetOptions{})
+					return err
+				},
+			}
+			for kind, get := range exists {
+				if err := get(); apierrors.IsNotFound(err) != tc.expectRemoved {
+					t.Errorf("expected %s removed %t, got %v", kind, tc.expectRemoved, err)
+				}
+			}
+
+			node, err := kubeClient.CoreV1().Nodes().Get(ctx, markedNode.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored := !hasNodeMarkers(node) && !node.Spec.Unschedulable; restored != tc.expectRemoved {
+				t.Errorf("expected node %s restored %t, got %+v", node.Name, tc.expectRemoved, node)
+			}
+			if !tc.expectRemoved {
+				return
+			}
+			if len(node.Spec.Taints) != 1 || node.Spec.Taints[0].Key != "dedicated" || node.Labels["kubernetes.io/os"] != "linux" {
+				t.Errorf("expected the other taints and labels of node %s kept, got %v and %v", node.Name, node.Spec.Taints, node.Labels)
+			}
+			node, err = kubeClient.CoreV1().Nodes().Get(ctx, cordonedNode.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasNodeMarkers(node) || !node.Spec.Unschedulable {
+				t.Errorf("expected node %s without markers and still cordoned, got %+v", node.Name, node)
+			}
+			sc, err := kubeClient.StorageV1().StorageClasses().Get(ctx, copiedSC.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, marker := range storageClassMarkerAnnotations {
+				if _, ok := sc.Annotations[marker]; ok {
+					t.Errorf("expected no %s annotation on StorageClass %s", marker, sc.Name)
+				}
+			}
+			if sc.Annotations[defaultStorageClassAnnotation] != "false" {
+				t.Errorf("expected the other annotations of StorageClass %s kept, got %v", sc.Name, sc.Annotations)
+			}
+		})
+	}
+}
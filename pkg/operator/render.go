@@ -0,0 +1,241 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	configv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	configinformers "github.com/openshift/client-go/config/informers/externalversions"
+	"github.com/openshift/library-go/pkg/config/leaderelection"
+	"github.com/openshift/library-go/pkg/operator/csi/csiconfigobservercontroller"
+	"github.com/openshift/library-go/pkg/operator/csi/csidrivercontrollerservicecontroller"
+	"github.com/openshift/library-go/pkg/operator/csi/csidrivernodeservicecontroller"
+	dc "github.com/openshift/library-go/pkg/operator/deploymentcontroller"
+	"github.com/openshift/library-go/pkg/operator/loglevel"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ovirt/csi-driver-operator/assets"
+)
+
+// RenderOptions hold the cluster state Render would otherwise read through the informers.
+type RenderOptions struct {
+	LogLevel             opv1.LogLevel
+	InfrastructureName   string
+	ControlPlaneTopology configv1.TopologyMode
+	// MasterNodes is the number of nodes the controller Deployment can be scheduled to.
+	MasterNodes     int
+	HTTPProxy       string
+	HTTPSProxy      string
+	NoProxy         string
+	TrustedCABundle []byte
+	// OperatorConfig is the content of the config.yaml key of the operator ConfigMap.
+	OperatorConfig []byte
+	StorageDomain  string
+}
+
+// Render writes the static resources, the ServiceMonitors, the PrometheusRule, the controller
+// Deployment, the node DaemonSet and the StorageClass as the operator would apply them, as
+// multi-document YAML. The informers the hooks read from are filled from the options instead
+// of a cluster and never started. The secret hash annotations are left out, as they need the credentials.
+func Render(opts RenderOptions, w io.Writer) error {
+	// The clients are never used, the informers only serve their indexers
+	restConfig := &rest.Config{Host: "localhost"}
+	configInformers := configinformers.NewSharedInformerFactory(configclient.NewForConfigOrDie(restConfig), 0)
+	kubeInformers := kubeinformers.NewSharedInformerFactory(kubeclient.NewForConfigOrDie(restConfig), 0)
+	nodeInformer := kubeInformers.Core().V1().Nodes()
+	configMapInformer := kubeInformers.Core().V1().ConfigMaps()
+
+	infrastructure := &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName},
+		Status: configv1.InfrastructureStatus{
+			InfrastructureName:   opts.InfrastructureName,
+			ControlPlaneTopology: opts.ControlPlaneTopology,
+		},
+	}
+	if err := addToIndexer(configInformers.Config().V1().Infrastructures().Informer(), infrastructure); err != nil {
+		return err
+	}
+	if len(opts.TrustedCABundle) > 0 {
+		if err := addToIndexer(configMapInformer.Informer(), renderConfigMap(trustedCAConfigMap, caBundleKey, opts.TrustedCABundle)); err != nil {
+			return err
+		}
+	}
+	if len(opts.OperatorConfig) > 0 {
+		if err := addToIndexer(configMapInformer.Informer(), renderConfigMap(operatorConfigMap, operatorConfigKey, opts.OperatorConfig)); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < opts.MasterNodes; i++ {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("master-%d", i),
+				Labels: map[string]string{"node-role.kubernetes.io/master": ""},
+			},
+		}
+		if err := addToIndexer(nodeInformer.Informer(), node); err != nil {
+			return err
+		}
+	}
+
+	opSpec, err := renderOperatorSpec(opts)
+	if err != nil {
+		return err
+	}
+	getOperatorConfig := newOperatorConfigGetter(configMapInformer.Lister())
+
+	// The manifest and Deployment hooks of WithCSIDriverControllerService, in the same order
+	deployment, err := renderDeployment(opSpec, "controller.yaml",
+		[]dc.ManifestHookFunc{
+			csidrivercontrollerservicecontroller.WithPlaceholdersHook(configInformers),
+			csidrivercontrollerservicecontroller.WithLeaderElectionReplacerHook(
+				leaderelection.LeaderElectionDefaulting(configv1.LeaderElection{}, "default", "default")),
+		},
+		csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
+		csidrivercontrollerservicecontroller.WithReplicasHook(nodeInformer.Lister()),
+		csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(defaultNamespace, trustedCAConfigMap, configMapInformer),
+		withOperatorConfigDeploymentHook(getOperatorConfig),
+		withTLSProfileDeploymentHook(configInformers.Config().V1().APIServers().Lister()),
+		csidrivercontrollerservicecontroller.WithControlPlaneTopologyHook(configInformers),
+	)
+	if err != nil {
+		return err
+	}
+
+	daemonSet, err := renderDaemonSet(opSpec, "node.yaml",
+		csidrivernodeservicecontroller.WithObservedProxyDaemonSetHook(),
+		csidrivernodeservicecontroller.WithCABundleDaemonSetHook(defaultNamespace, trustedCAConfigMap, configMapInformer),
+		withOperatorConfigDaemonSetHook(getOperatorConfig),
+		withTLSProfileDaemonSetHook(configInformers.Config().V1().APIServers().Lister()),
+	)
+	if err != nil {
+		return err
+	}
+
+	var docs [][]byte
+	assetFunc := withMaintenanceServiceMonitor(withAlertThresholds(assets.ReadFile, getOperatorConfig), getOperatorConfig)
+	for _, file := range append(staticAssets, serviceMonitorAsset, nodeServiceMonitorAsset, prometheusRuleAsset) {
+		manifest, err := assetFunc(file)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, manifest)
+	}
+	for _, obj := range []interface{}{deployment, daemonSet, generateStorageClass(opts.StorageDomain)} {
+		manifest, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, manifest)
+	}
+
+	for _, doc := range docs {
+		if _, err := fmt.Fprintf(w, "---\n%s\n", strings.TrimSpace(strings.TrimPrefix(string(doc), "---\n"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderOperatorSpec returns the ClusterCSIDriver spec with the proxy as observed by the
+// CSI config observer.
+func renderOperatorSpec(opts RenderOptions) (*opv1.OperatorSpec, error) {
+	proxy := map[string]interface{}{}
+	for name, value := range map[string]string{
+		"HTTP_PROXY":  opts.HTTPProxy,
+		"HTTPS_PROXY": opts.HTTPSProxy,
+		"NO_PROXY":    opts.NoProxy,
+	} {
+		if value != "" {
+			proxy[name] = value
+		}
+	}
+	observedConfig := map[string]interface{}{}
+	if len(proxy) > 0 {
+		if err := unstructured.SetNestedField(observedConfig, proxy, csiconfigobservercontroller.ProxyConfigPath()...); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := json.Marshal(observedConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &opv1.OperatorSpec{
+		ManagementState: opv1.Managed,
+		LogLevel:        opts.LogLevel,
+		ObservedConfig:  runtime.RawExtension{Raw: raw},
+	}, nil
+}
+
+func addToIndexer(informer cache.SharedIndexInformer, obj interface{}) error {
+	return informer.GetIndexer().Add(obj)
+}
+
+func renderConfigMap(name, key string, data []byte) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: defaultNamespace, Name: name},
+		Data:       map[string]string{key: string(data)},
+	}
+}
+
+func renderDeployment(opSpec *opv1.OperatorSpec, file string, manifestHooks []dc.ManifestHookFunc, hooks ...dc.DeploymentHookFunc) (interface{}, error) {
+	manifest, err := assets.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, hook := range manifestHooks {
+		if manifest, err = hook(opSpec, manifest); err != nil {
+			return nil, fmt.Errorf("error running manifest hook of %s (index=%d): %w", file, i, err)
+		}
+	}
+	deployment := resourceread.ReadDeploymentV1OrDie(manifest)
+	for i, hook := range hooks {
+		if err := hook(opSpec, deployment); err != nil {
+			return nil, fmt.Errorf("error running hook of %s (index=%d): %w", file, i, err)
+		}
+	}
+	deployment.APIVersion, deployment.Kind = "apps/v1", "Deployment"
+	return deployment, nil
+}
+
+func renderDaemonSet(opSpec *opv1.OperatorSpec, file string, hooks ...csidrivernodeservicecontroller.DaemonSetHookFunc) (interface{}, error) {
+	manifest, err := assets.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	daemonSet := resourceread.ReadDaemonSetV1OrDie(replaceNodePlaceholders(manifest, opSpec))
+	for i, hook := range hooks {
+		if err := hook(opSpec, daemonSet); err != nil {
+			return nil, fmt.Errorf("error running hook of %s (index=%d): %w", file, i, err)
+		}
+	}
+	daemonSet.APIVersion, daemonSet.Kind = "apps/v1", "DaemonSet"
+	return daemonSet, nil
+}
+
+// replaceNodePlaceholders replaces the placeholders of the node DaemonSet like the
+// CSIDriverNodeServiceController does, which does not export it.
+func replaceNodePlaceholders(manifest []byte, spec *opv1.OperatorSpec) []byte {
+	var pairs []string
+	for _, placeholder := range []string{"DRIVER_IMAGE", "NODE_DRIVER_REGISTRAR_IMAGE", "LIVENESS_PROBE_IMAGE", "KUBE_RBAC_PROXY_IMAGE"} {
+		if image := os.Getenv(placeholder); image != "" {
+			pairs = append(pairs, "${"+placeholder+"}", image)
+		}
+	}
+	pairs = append(pairs, "${LOG_LEVEL}", strconv.Itoa(loglevel.LogLevelToVerbosity(spec.LogLevel)))
+	return []byte(strings.NewReplacer(pairs...).Replace(string(manifest)))
+}
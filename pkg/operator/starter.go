@@ -3,6 +3,8 @@ package operator
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/openshift/library-go/pkg/operator/csi/csidrivercontrollerservicecontroller"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/ovirt/csi-driver-operator/assets"
 	"github.com/ovirt/csi-driver-operator/internal/ovirt"
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
 
 	opv1 "github.com/openshift/api/operator/v1"
 	configclient "github.com/openshift/client-go/config/clientset/versioned"
@@ -20,9 +23,14 @@ import (
 	"github.com/openshift/library-go/pkg/controller/controllercmd"
 	"github.com/openshift/library-go/pkg/controller/factory"
 	"github.com/openshift/library-go/pkg/operator/csi/csicontrollerset"
+	dc "github.com/openshift/library-go/pkg/operator/deploymentcontroller"
 	goc "github.com/openshift/library-go/pkg/operator/genericoperatorclient"
+	"github.com/openshift/library-go/pkg/operator/management"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceapply"
+	"github.com/openshift/library-go/pkg/operator/staticresourcecontroller"
 	"github.com/openshift/library-go/pkg/operator/v1helpers"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeclient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
@@ -40,116 +48,190 @@ const (
 )
 
 type CSIOperator struct {
-	ovirtClient ovirtclient.Client
-	nodeName    *string
-}
+	ovirtClient     ovirtclient.Client
+	nodeName        *string
+	guestKubeConfig *string
 
-func NewCSIOperator(nodeName *string) (*CSIOperator, error) {
-	client, err := ovirt.NewClient()
-	if err != nil {
-		klog.Error(err)
-		return nil, err
-	}
+	// connectionLock guards ovirtClient and connectionSettings, the client is shared by all controllers
+	connectionLock sync.Mutex
+	// connectionSettings are the settings the current ovirtClient was built with
+	connectionSettings *ovirt.ConnectionSettings
+	// getConnectionSettings returns the desired cluster-wide connection settings, nil until the informers exist
+	// and no client is created before
+	getConnectionSettings func() (*ovirt.ConnectionSettings, error)
+}
 
+// NewCSIOperator returns the operator. Its oVirt client is created on first use, once the
+// cluster-wide connection settings are known, as the engine may only be reachable through the
+// cluster proxy.
+func NewCSIOperator(nodeName *string, guestKubeConfig *string) *CSIOperator {
 	return &CSIOperator{
-		ovirtClient: client,
-		nodeName:    nodeName,
-	}, nil
+		nodeName:        nodeName,
+		guestKubeConfig: guestKubeConfig,
+	}
 }
 
 func (o *CSIOperator) getConnection() (ovirtclient.Client, error) {
-	var err error
-	if o.ovirtClient == nil || o.ovirtClient.Test() != nil {
-		o.ovirtClient, err = ovirt.NewClient()
+	o.connectionLock.Lock()
+	defer o.connectionLock.Unlock()
+
+	if o.getConnectionSettings == nil {
+		return nil, fmt.Errorf("the engine connection settings are not known yet")
+	}
+	settings, err := o.getConnectionSettings()
+	if err != nil {
+		return nil, err
 	}
 
-	return o.ovirtClient, err
+	// Rebuild the client when the cluster proxy or the trusted CA bundle changed
+	if o.ovirtClient == nil || !reflect.DeepEqual(settings, o.connectionSettings) || o.ovirtClient.Test() != nil {
+		client, err := ovirt.NewClientWithSettings(settings)
+		if err != nil {
+			engineUp.Set(0)
+			return nil, err
+		}
+		o.ovirtClient = client
+		o.connectionSettings = settings
+	}
+	engineUp.Set(1)
+
+	return o.ovirtClient, nil
 }
 
 func (o *CSIOperator) RunOperator(ctx context.Context, controllerConfig *controllercmd.ControllerContext) error {
-	// Create clientsets and informers
-	kubeClient := kubeclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
+	// With a hosted control plane the operator and the controller Deployment run in the
+	// management cluster, everything else is reconciled in the guest cluster.
+	hosted := o.guestKubeConfig != nil && *o.guestKubeConfig != ""
+	guestKubeConfig := controllerConfig.KubeConfig
+	controlPlaneNamespace := defaultNamespace
+	nodeName := *o.nodeName
+	if hosted {
+		var err error
+		guestKubeConfig, err = loadGuestKubeConfig(*o.guestKubeConfig)
+		if err != nil {
+			return fmt.Errorf("failed to load guest kubeconfig %s: %w", *o.guestKubeConfig, err)
+		}
+		controlPlaneNamespace = controllerConfig.OperatorNamespace
+		// The operator does not run on a guest node, let the storage class controller pick one
+		nodeName = ""
+		klog.Infof("Running with a hosted control plane in namespace %s", controlPlaneNamespace)
+	}
+
+	// Create clientsets and informers of the guest cluster
+	kubeClient := kubeclient.NewForConfigOrDie(rest.AddUserAgent(guestKubeConfig, operatorName))
 	kubeInformersForNamespaces := v1helpers.NewKubeInformersForNamespaces(kubeClient, defaultNamespace, "")
 	nodeInformer := kubeInformersForNamespaces.InformersFor("").Core().V1().Nodes()
 	configMapInformer := kubeInformersForNamespaces.InformersFor(defaultNamespace).Core().V1().ConfigMaps()
 	secretInformer := kubeInformersForNamespaces.InformersFor(defaultNamespace).Core().V1().Secrets()
 	// Create GenericOperatorclient. This is used by the library-go controllers created down below
 	gvr := opv1.GroupVersion.WithResource("clustercsidrivers")
-	operatorClient, dynamicInformers, err := goc.NewClusterScopedOperatorClientWithConfigName(controllerConfig.KubeConfig, gvr, instanceName)
+	operatorClient, dynamicInformers, err := goc.NewClusterScopedOperatorClientWithConfigName(guestKubeConfig, gvr, instanceName)
 	if err != nil {
 		return err
 	}
 	// Create config clientset and informer. This is used to get the HTTP proxy setting
-	configClient := configclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
+	configClient := configclient.NewForConfigOrDie(rest.AddUserAgent(guestKubeConfig, operatorName))
 	configInformers := configinformers.NewSharedInformerFactory(configClient, resync)
 
 	// operator.openshift.io client, used for ClusterCSIDriver
-	operatorClientSet := opclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
+	operatorClientSet := opclient.NewForConfigOrDie(rest.AddUserAgent(guestKubeConfig, operatorName))
 	operatorInformers := opinformers.NewSharedInformerFactory(operatorClientSet, resync)
 
-	dynamicClient, err := dynamic.NewForConfig(controllerConfig.KubeConfig)
+	dynamicClient, err := dynamic.NewForConfig(guestKubeConfig)
 	if err != nil {
 		return err
 	}
 
+	// Clientsets and informers of the cluster running the controller Deployment. Without a
+	// hosted control plane these are the ones of the guest cluster.
+	controlPlaneKubeClient := kubeClient
+	controlPlaneInformers := kubeInformersForNamespaces
+	controlPlaneDynamicClient := dynamicClient
+	if hosted {
+		controlPlaneKubeClient = kubeclient.NewForConfigOrDie(rest.AddUserAgent(controllerConfig.KubeConfig, operatorName))
+		controlPlaneInformers = v1helpers.NewKubeInformersForNamespaces(controlPlaneKubeClient, controlPlaneNamespace, "")
+		controlPlaneDynamicClient, err = dynamic.NewForConfig(controllerConfig.KubeConfig)
+		if err != nil {
+			return err
+		}
+	}
+	controlPlaneNodeInformer := controlPlaneInformers.InformersFor("").Core().V1().Nodes()
+	controlPlaneConfigMapInformer := controlPlaneInformers.InformersFor(controlPlaneNamespace).Core().V1().ConfigMaps()
+	controlPlaneSecretInformer := controlPlaneInformers.InformersFor(controlPlaneNamespace).Core().V1().Secrets()
+	controlPlaneAssetFunc := namespacedAssets(controlPlaneNamespace)
+
+	// Optional operator configuration applied on top of the operand assets
+	getOperatorConfig := newOperatorConfigGetter(configMapInformer.Lister())
+
+	// The operator's own oVirt client follows the cluster proxy and TLS security profile
+	// and trusts the current engine CA of the credentials Secret and the injected CA bundle
+	getConnectionSettings := newConnectionSettingsGetter(
+		configInformers.Config().V1().Proxies().Lister(),
+		configInformers.Config().V1().APIServers().Lister(),
+		controlPlaneConfigMapInformer.Lister(),
+		controlPlaneSecretInformer.Lister(),
+		controlPlaneNamespace,
+		newStrictTLSGetter(configMapInformer.Lister()),
+	)
+	o.connectionLock.Lock()
+	o.getConnectionSettings = getConnectionSettings
+	o.connectionLock.Unlock()
+
+	controllerHooks := []dc.DeploymentHookFunc{
+		csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(controlPlaneNamespace, secretName, controlPlaneSecretInformer),
+		csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
+		csidrivercontrollerservicecontroller.WithReplicasHook(controlPlaneNodeInformer.Lister()),
+		csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(
+			controlPlaneNamespace,
+			trustedCAConfigMap,
+			controlPlaneConfigMapInformer,
+		),
+		withOperatorConfigDeploymentHook(getOperatorConfig),
+		withTLSProfileDeploymentHook(configInformers.Config().V1().APIServers().Lister()),
+	}
+	files := staticAssets
+	if hosted {
+		controllerHooks = append(controllerHooks,
+			withHostedControlPlaneDeploymentHook(),
+			csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(controlPlaneNamespace, guestKubeConfigSecret, controlPlaneSecretInformer),
+		)
+		files = guestStaticAssets()
+	}
+
+	// The OvirtOperandRemovalController is the only one removing the operand, it refuses while
+	// PVs of the driver exist. The operand removal of library-go would delete the controller
+	// Deployment and the node DaemonSet of a deleted ClusterCSIDriver without this check, and
+	// its management state controller would report Removed as unsupported.
+	management.SetOperatorNotRemovable()
+
 	csiControllerSet := csicontrollerset.NewCSIControllerSet(
 		operatorClient,
 		controllerConfig.EventRecorder,
-	).WithLogLevelController().WithManagementStateController(
-		operandName,
-		false,
-	).WithStaticResourcesController(
+	).WithLogLevelController().WithStaticResourcesController(
 		"OvirtDriverStaticResources",
 		kubeClient,
 		dynamicClient,
 		kubeInformersForNamespaces,
 		assets.ReadFile,
-		[]string{
-			"controller_sa.yaml",
-			"node_sa.yaml",
-			"cabundle_cm.yaml",
-			"rbac/attacher_role.yaml",
-			"rbac/privileged_role.yaml",
-			"rbac/provisioner_role.yaml",
-			"rbac/resizer_role.yaml",
-			"rbac/snapshotter_role.yaml",
-			"rbac/kube_rbac_proxy_role.yaml",
-			"rbac/prometheus_role.yaml",
-			"rbac/attacher_binding.yaml",
-			"rbac/node_privileged_binding.yaml",
-			"rbac/controller_privileged_binding.yaml",
-			"rbac/provisioner_binding.yaml",
-			"rbac/resizer_binding.yaml",
-			"rbac/snapshotter_binding.yaml",
-			"rbac/kube_rbac_proxy_binding.yaml",
-			"rbac/prometheus_rolebinding.yaml",
-			"controller_pdb.yaml",
-			"service.yaml",
-			"csidriver.yaml",
-		},
+		files,
 	).WithCSIConfigObserverController(
 		"OvirtDriverCSIConfigObserverController",
 		configInformers,
 	).WithCSIDriverControllerService(
 		"OvirtDriverControllerServiceController",
-		assets.ReadFile,
+		controlPlaneAssetFunc,
 		"controller.yaml",
-		kubeClient,
-		kubeInformersForNamespaces.InformersFor(defaultNamespace),
+		controlPlaneKubeClient,
+		controlPlaneInformers.InformersFor(controlPlaneNamespace),
 		configInformers,
 		[]factory.Informer{
-			nodeInformer.Informer(),
+			controlPlaneNodeInformer.Informer(),
+			controlPlaneConfigMapInformer.Informer(),
+			controlPlaneSecretInformer.Informer(),
 			configMapInformer.Informer(),
-			secretInformer.Informer(),
+			configInformers.Config().V1().APIServers().Informer(),
 		},
-		csidrivercontrollerservicecontroller.WithSecretHashAnnotationHook(defaultNamespace, secretName, secretInformer),
-		csidrivercontrollerservicecontroller.WithObservedProxyDeploymentHook(),
-		csidrivercontrollerservicecontroller.WithReplicasHook(nodeInformer.Lister()),
-		csidrivercontrollerservicecontroller.WithCABundleDeploymentHook(
-			defaultNamespace,
-			trustedCAConfigMap,
-			configMapInformer,
-		),
+		controllerHooks...,
 	).WithCSIDriverNodeService(
 		"OvirtDriverNodeServiceController",
 		assets.ReadFile,
@@ -159,6 +241,7 @@ func (o *CSIOperator) RunOperator(ctx context.Context, controllerConfig *control
 		[]factory.Informer{
 			configMapInformer.Informer(),
 			secretInformer.Informer(),
+			configInformers.Config().V1().APIServers().Informer(),
 		},
 		csidrivernodeservicecontroller.WithSecretHashAnnotationHook(defaultNamespace, secretName, secretInformer),
 		csidrivernodeservicecontroller.WithObservedProxyDaemonSetHook(),
@@ -167,20 +250,57 @@ func (o *CSIOperator) RunOperator(ctx context.Context, controllerConfig *control
 			trustedCAConfigMap,
 			configMapInformer,
 		),
+		withOperatorConfigDaemonSetHook(getOperatorConfig),
+		withTLSProfileDaemonSetHook(configInformers.Config().V1().APIServers().Lister()),
 	).WithServiceMonitorController(
 		"OvirtDriverServiceMonitorController",
-		dynamicClient,
-		assets.ReadFile,
-		"servicemonitor.yaml",
+		controlPlaneDynamicClient,
+		withMaintenanceServiceMonitor(controlPlaneAssetFunc, getOperatorConfig),
+		serviceMonitorAsset,
 	)
 
+	// With a hosted control plane the static resources of the controller are applied to
+	// the management cluster by a separate controller
+	var controlPlaneStaticResourcesController factory.Controller
+	if hosted {
+		controlPlaneStaticResourcesController = staticresourcecontroller.NewStaticResourceController(
+			"OvirtDriverControlPlaneStaticResources",
+			controlPlaneAssetFunc,
+			controlPlaneStaticAssets(),
+			(&resourceapply.ClientHolder{}).WithKubernetes(controlPlaneKubeClient).WithDynamicClient(controlPlaneDynamicClient),
+			operatorClient,
+			controllerConfig.EventRecorder,
+		).AddKubeInformers(controlPlaneInformers)
+	}
+
+	// The ServiceMonitor of the node plugin metrics, in the cluster running the node DaemonSet
+	nodeServiceMonitorController := staticresourcecontroller.NewStaticResourceController(
+		"OvirtDriverNodeServiceMonitorController",
+		assets.ReadFile,
+		[]string{nodeServiceMonitorAsset},
+		(&resourceapply.ClientHolder{}).WithDynamicClient(dynamicClient),
+		operatorClient,
+		controllerConfig.EventRecorder,
+	).WithIgnoreNotFoundOnCreate()
+
+	// The alerting rules accompany the ServiceMonitor, their thresholds follow the operator
+	// configuration
+	prometheusRuleController := staticresourcecontroller.NewStaticResourceController(
+		"OvirtDriverPrometheusRuleController",
+		withAlertThresholds(controlPlaneAssetFunc, getOperatorConfig),
+		[]string{prometheusRuleAsset},
+		(&resourceapply.ClientHolder{}).WithDynamicClient(controlPlaneDynamicClient),
+		operatorClient,
+		controllerConfig.EventRecorder,
+	).WithIgnoreNotFoundOnCreate().AddInformer(configMapInformer.Informer())
+
 	scController := NewOvirtStorageClassController(
 		operatorClient,
 		kubeClient,
 		kubeInformersForNamespaces,
 		operatorInformers,
 		o.getConnection,
-		*o.nodeName,
+		nodeName,
 		controllerConfig.EventRecorder,
 	)
 
@@ -191,16 +311,244 @@ func (o *CSIOperator) RunOperator(ctx context.Context, controllerConfig *control
 		controllerConfig.EventRecorder,
 	)
 
+	configController := NewOvirtOperatorConfigController(
+		operatorClient,
+		configMapInformer,
+		getOperatorConfig,
+		controllerConfig.EventRecorder,
+	)
+
+	nodePlacementController := NewOvirtNodePlacementController(
+		operatorClient,
+		kubeClient,
+		nodeInformer,
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().VolumeAttachments(),
+		configMapInformer,
+		getOperatorConfig,
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	nodeVMStatusController := NewOvirtNodeVMStatusController(
+		operatorClient,
+		kubeClient,
+		nodeInformer,
+		configMapInformer,
+		getOperatorConfig,
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	engineTLSController := NewOvirtEngineTLSController(
+		operatorClient,
+		configInformers,
+		controlPlaneConfigMapInformer,
+		configMapInformer,
+		getConnectionSettings,
+		controllerConfig.EventRecorder,
+	)
+
+	engineCertificateController := NewOvirtEngineCertificateController(
+		operatorClient,
+		configInformers,
+		controlPlaneConfigMapInformer,
+		controlPlaneSecretInformer,
+		configMapInformer,
+		getOperatorConfig,
+		getConnectionSettings,
+		controllerConfig.EventRecorder,
+	)
+
+	// The controllers which need all engine disks share the listing
+	listDisks := newDiskLister(o.getConnection, diskListingMaxAge, time.Now)
+
+	volumeUsageController := NewOvirtVolumeUsageController(
+		operatorClient,
+		kubeClient,
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		o.getConnection,
+		listDisks,
+		controllerConfig.EventRecorder,
+	)
+
+	overcommitController := NewOvirtOvercommitController(
+		operatorClient,
+		kubeClient,
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().StorageClasses(),
+		configMapInformer,
+		getOperatorConfig,
+		o.getConnection,
+		listDisks,
+		controllerConfig.EventRecorder,
+	)
+
+	maintenanceController := NewOvirtMaintenanceController(
+		operatorClient,
+		kubeClient,
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().StorageClasses(),
+		configMapInformer,
+		getOperatorConfig,
+		controllerConfig.EventRecorder,
+	)
+
+	storageClassFailoverController := NewOvirtStorageClassFailoverController(
+		operatorClient,
+		kubeClient,
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().StorageClasses(),
+		configMapInformer,
+		getOperatorConfig,
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	volumeAttachmentController := NewOvirtVolumeAttachmentController(
+		operatorClient,
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().VolumeAttachments(),
+		controllerConfig.EventRecorder,
+	)
+
+	diskStatusController := NewOvirtDiskStatusController(
+		operatorClient,
+		kubeClient,
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		configMapInformer,
+		getOperatorConfig,
+		listDisks,
+		controllerConfig.EventRecorder,
+	)
+
+	ovirtInformers := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, resync)
+	diskImportController := NewOvirtDiskImportController(
+		operatorClient,
+		kubeClient,
+		dynamicClient,
+		ovirtInformers.ForResource(v1alpha1.OvirtDiskImportResource),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	imagePopulatorController := NewOvirtImagePopulatorController(
+		operatorClient,
+		kubeClient,
+		dynamicClient,
+		ovirtInformers.ForResource(v1alpha1.OvirtImagePopulatorResource),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumeClaims(),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().StorageClasses(),
+		getOperatorConfig,
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	diskExportController := NewOvirtDiskExportController(
+		operatorClient,
+		kubeClient,
+		dynamicClient,
+		ovirtInformers.ForResource(v1alpha1.OvirtDiskExportResource),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumeClaims(),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		o.getConnection,
+		controllerConfig.EventRecorder,
+	)
+
+	volumeMigrationController := NewVolumeMigrationController(
+		operatorClient,
+		kubeClient,
+		dynamicClient,
+		ovirtInformers.ForResource(v1alpha1.VolumeMigrationResource),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumeClaims(),
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().StorageClasses(),
+		controllerConfig.EventRecorder,
+	)
+
+	// The ServiceMonitor, the PrometheusRule and, with a hosted control plane, the static
+	// resources of the controller are in the cluster running the controller Deployment
+	controlPlaneFiles := []string{"servicemonitor.yaml", prometheusRuleAsset}
+	if hosted {
+		controlPlaneFiles = append(controlPlaneStaticAssets(), controlPlaneFiles...)
+	}
+	removalController := NewOvirtOperandRemovalController(
+		operatorClient,
+		kubeClient,
+		controlPlaneKubeClient,
+		controlPlaneNamespace,
+		[]operandResources{
+			{
+				clients: (&resourceapply.ClientHolder{}).WithKubernetes(kubeClient).WithDynamicClient(dynamicClient),
+				assets:  assets.ReadFile,
+				files:   append(files, nodeServiceMonitorAsset),
+			},
+			{
+				clients: (&resourceapply.ClientHolder{}).WithKubernetes(controlPlaneKubeClient).WithDynamicClient(controlPlaneDynamicClient),
+				assets:  controlPlaneAssetFunc,
+				files:   controlPlaneFiles,
+			},
+		},
+		kubeInformersForNamespaces.InformersFor("").Core().V1().PersistentVolumes(),
+		nodeInformer,
+		controllerConfig.EventRecorder,
+	)
+
+	startupTaintController := NewOvirtNodeStartupTaintController(
+		operatorClient,
+		kubeClient,
+		nodeInformer,
+		kubeInformersForNamespaces.InformersFor("").Storage().V1().CSINodes(),
+		kubeInformersForNamespaces.InformersFor(defaultNamespace).Core().V1().Pods(),
+		kubeInformersForNamespaces.InformersFor(defaultNamespace).Apps().V1().DaemonSets(),
+		configMapInformer,
+		getOperatorConfig,
+		controllerConfig.EventRecorder,
+	)
+
+	versionController := NewOvirtVersionController(
+		operatorClient,
+		controlPlaneInformers.InformersFor(controlPlaneNamespace).Apps().V1().Deployments(),
+		kubeInformersForNamespaces.InformersFor(defaultNamespace).Apps().V1().DaemonSets(),
+		controlPlaneNamespace,
+		controllerConfig.EventRecorder,
+	)
+
 	klog.Info("Starting the informers")
 	go kubeInformersForNamespaces.Start(ctx.Done())
+	if hosted {
+		go controlPlaneInformers.Start(ctx.Done())
+	}
 	go dynamicInformers.Start(ctx.Done())
+	go ovirtInformers.Start(ctx.Done())
 	go configInformers.Start(ctx.Done())
 	go operatorInformers.Start(ctx.Done())
 
 	klog.Info("Starting controllerset")
 	go csiControllerSet.Run(ctx, 1)
+	if controlPlaneStaticResourcesController != nil {
+		go controlPlaneStaticResourcesController.Run(ctx, 1)
+	}
+	go nodeServiceMonitorController.Run(ctx, 1)
+	go prometheusRuleController.Run(ctx, 1)
 	go scController.Run(ctx, 1)
 	go eolController.Run(ctx, 1)
+	go configController.Run(ctx, 1)
+	go nodePlacementController.Run(ctx, 1)
+	go nodeVMStatusController.Run(ctx, 1)
+	go engineTLSController.Run(ctx, 1)
+	go engineCertificateController.Run(ctx, 1)
+	go volumeUsageController.Run(ctx, 1)
+	go overcommitController.Run(ctx, 1)
+	go maintenanceController.Run(ctx, 1)
+	go storageClassFailoverController.Run(ctx, 1)
+	go volumeAttachmentController.Run(ctx, 1)
+	go diskStatusController.Run(ctx, 1)
+	go diskImportController.Run(ctx, 1)
+	go imagePopulatorController.Run(ctx, 1)
+	go diskExportController.Run(ctx, 1)
+	go volumeMigrationController.Run(ctx, 1)
+	go startupTaintController.Run(ctx, 1)
+	go versionController.Run(ctx, 1)
+	go removalController.Run(ctx, 1)
 
 	<-ctx.Done()
 
@@ -0,0 +1,459 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configclient "github.com/openshift/client-go/config/clientset/versioned"
+	opclient "github.com/openshift/client-go/operator/clientset/versioned"
+	"github.com/openshift/library-go/pkg/controller/controllercmd"
+	"github.com/openshift/library-go/pkg/operator/events"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	envtestNodeName      = "worker-0"
+	envtestVMID          = "3f9e7c52-1d4b-4e8a-9b0c-6a2d5f8e1c47"
+	envtestStorageDomain = "data"
+	envtestTimeout       = 2 * time.Minute
+)
+
+// envtestCRDs are the CRDs watched or applied by the operator
+var envtestCRDs = []string{
+	"../../vendor/github.com/openshift/api/operator/v1/0000_90_cluster_csi_driver_01_config.crd.yaml",
+	"../../vendor/github.com/openshift/api/config/v1/0000_03_config-operator_01_proxy.crd.yaml",
+	"../../vendor/github.com/openshift/api/config/v1/0000_10_config-operator_01_apiserver.crd.yaml",
+	"../../vendor/github.com/openshift/api/config/v1/0000_10_config-operator_01_infrastructure-Default.crd.yaml",
+	"../../manifests/00_crd_ovirtdiskexport.yaml",
+	"../../manifests/00_crd_ovirtdiskimport.yaml",
+	"../../manifests/00_crd_ovirtimagepopulator.yaml",
+	"../../manifests/00_crd_volumemigration.yaml",
+	"testdata/monitoring_crds.yaml",
+}
+
+var serviceMonitorResource = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "servicemonitors"}
+
+// TestRunOperator runs the operator against a kube-apiserver and etcd started by envtest and
+// a fake engine. Without a kube-controller-manager the test reports the controller Deployment
+// and the node DaemonSet as rolled out itself.
+func TestRunOperator(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set, see docs/testing-custom-operator.md")
+	}
+
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     envtestCRDs,
+		ErrorIfCRDPathMissing: true,
+	}
+	kubeConfig, err := testEnv.Start()
+	if err != nil {
+		t.Fatalf("failed to start the test environment: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := testEnv.Stop(); err != nil {
+			t.Errorf("failed to stop the test environment: %v", err)
+		}
+	})
+
+	startEnvtestEngine(t)
+	for _, image := range []string{
+		"DRIVER_IMAGE",
+		"PROVISIONER_IMAGE",
+		"ATTACHER_IMAGE",
+		"RESIZER_IMAGE",
+		"SNAPSHOTTER_IMAGE",
+		"LIVENESS_PROBE_IMAGE",
+		"KUBE_RBAC_PROXY_IMAGE",
+		"NODE_DRIVER_REGISTRAR_IMAGE",
+	} {
+		t.Setenv(image, "quay.io/example/"+strings.ToLower(image))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	kubeClient := kubernetes.NewForConfigOrDie(kubeConfig)
+	operatorClient := opclient.NewForConfigOrDie(kubeConfig)
+	createClusterObjects(ctx, t, kubeClient, configclient.NewForConfigOrDie(kubeConfig), operatorClient)
+
+	recorder := events.NewLoggingEventRecorder(operatorName)
+	nodeName := envtestNodeName
+	guestKubeConfig := ""
+	operator := NewCSIOperator(&nodeName, &guestKubeConfig)
+	go func() {
+		err := operator.RunOperator(ctx, &controllercmd.ControllerContext{
+			// The operator sets its user agent in the config
+			KubeConfig:        rest.CopyConfig(kubeConfig),
+			EventRecorder:     recorder,
+			OperatorNamespace: defaultNamespace,
+		})
+		if ctx.Err() == nil {
+			t.Errorf("the operator stopped: %v", err)
+		}
+	}()
+	// Stop the operator before the test environment
+	t.Cleanup(func() {
+		cancel()
+		time.Sleep(time.Second)
+	})
+
+	dynamicClient := dynamic.NewForConfigOrDie(kubeConfig)
+	waitForOperand(ctx, t, kubeClient, dynamicClient)
+
+	markRolledOut(ctx, t, kubeClient)
+	waitFor(t, "the conditions to report the operand as available", func() error {
+		return operandAvailable(ctx, operatorClient)
+	})
+
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.LogLevel = opv1.Debug
+	})
+	waitFor(t, "the operand to log with --v=4", func() error {
+		deployment, err := kubeClient.AppsV1().Deployments(defaultNamespace).Get(ctx, controllerDeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := hasArg(&deployment.Spec.Template.Spec, "csi-driver", "--v=4"); err != nil {
+			return err
+		}
+		daemonSet, err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Get(ctx, nodeDaemonSetName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		return hasArg(&daemonSet.Spec.Template.Spec, "csi-driver", "--v=4")
+	})
+
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.OperatorLogLevel = opv1.Debug
+	})
+	waitFor(t, "the operator to log with --v=4", func() error {
+		if !klog.V(4).Enabled() {
+			return fmt.Errorf("the operator log level is not changed")
+		}
+		return nil
+	})
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.OperatorLogLevel = opv1.Normal
+	})
+	waitFor(t, "the operator to log with --v=2", func() error {
+		if klog.V(4).Enabled() || !klog.V(2).Enabled() {
+			return fmt.Errorf("the operator log level is not changed")
+		}
+		return nil
+	})
+
+	// An Unmanaged operand is left as it is
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.ManagementState = opv1.Unmanaged
+	})
+	// Let the informers of the controllers observe the change
+	time.Sleep(2 * time.Second)
+	if err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Delete(ctx, nodeDaemonSetName, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete the DaemonSet: %v", err)
+	}
+	time.Sleep(5 * time.Second)
+	if _, err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Get(ctx, nodeDaemonSetName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected the DaemonSet to stay deleted while Unmanaged, got %v", err)
+	}
+
+	// Without PVs of the driver the operand is removed
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.ManagementState = opv1.Removed
+	})
+	waitFor(t, "the operand to be removed", func() error {
+		if _, err := kubeClient.AppsV1().Deployments(defaultNamespace).Get(ctx, controllerDeploymentName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			return fmt.Errorf("the Deployment exists: %v", err)
+		}
+		if _, err := kubeClient.StorageV1().StorageClasses().Get(ctx, "ovirt-csi-sc", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			return fmt.Errorf("the StorageClass exists: %v", err)
+		}
+		if _, err := kubeClient.StorageV1().CSIDrivers().Get(ctx, instanceName, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			return fmt.Errorf("the CSIDriver exists: %v", err)
+		}
+		return expectCondition(ctx, operatorClient, "OvirtOperandRemovalControllerDegraded", opv1.ConditionFalse, removedReason)
+	})
+
+	// Managed again, the operand is recreated
+	updateOperatorSpec(ctx, t, operatorClient, func(spec *opv1.OperatorSpec) {
+		spec.ManagementState = opv1.Managed
+	})
+	waitForOperand(ctx, t, kubeClient, dynamicClient)
+	markRolledOut(ctx, t, kubeClient)
+	waitFor(t, "the conditions to report the operand as available again", func() error {
+		return operandAvailable(ctx, operatorClient)
+	})
+}
+
+// startEnvtestEngine starts a fake engine with the VM of the node, its boot disk is in the
+// storage domain expected in the default StorageClass.
+func startEnvtestEngine(t *testing.T) {
+	t.Helper()
+	engine := startFakeEngine(t)
+
+	const storageDomainID, diskID = "6f8b4cf3-0a5e-4fb3-9d5c-8b3f35a8e2a1", "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f"
+	engine.AddStorageDomain(storageDomainID, envtestStorageDomain, 80<<30, 20<<30)
+	engine.AddVM(envtestVMID, envtestNodeName)
+	if err := engine.AddDisk(diskID, envtestNodeName+"-boot", storageDomainID, 20<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.AttachDisk(envtestVMID, diskID, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createClusterObjects creates what the installer and the cluster version operator provide:
+// the namespace and credentials of the driver, the Infrastructure, the node of the VM and the
+// ClusterCSIDriver of manifests/08_cr.yaml.
+func createClusterObjects(ctx context.Context, t *testing.T, kubeClient kubernetes.Interface, configClient configclient.Interface, operatorClient opclient.Interface) {
+	t.Helper()
+	if _, err := kubeClient.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultNamespace},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create the namespace: %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Secrets(defaultNamespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName},
+		StringData: map[string]string{"ovirt_url": "https://engine.example.com/ovirt-engine/api"},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create the credentials: %v", err)
+	}
+
+	infrastructure, err := configClient.ConfigV1().Infrastructures().Create(ctx, &configv1.Infrastructure{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterConfigName},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the Infrastructure: %v", err)
+	}
+	infrastructure.Status = configv1.InfrastructureStatus{
+		InfrastructureName:     "envtest",
+		ControlPlaneTopology:   configv1.HighlyAvailableTopologyMode,
+		InfrastructureTopology: configv1.HighlyAvailableTopologyMode,
+	}
+	if _, err := configClient.ConfigV1().Infrastructures().UpdateStatus(ctx, infrastructure, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update the Infrastructure status: %v", err)
+	}
+
+	node, err := kubeClient.CoreV1().Nodes().Create(ctx, &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: envtestNodeName,
+			Labels: map[string]string{
+				"kubernetes.io/os":                      "linux",
+				"node-role.kubernetes.io/master":        "",
+				"node-role.kubernetes.io/worker":        "",
+				"node-role.kubernetes.io/control-plane": "",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create the node: %v", err)
+	}
+	node.Status.NodeInfo.SystemUUID = strings.ToUpper(envtestVMID)
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	if _, err := kubeClient.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update the node status: %v", err)
+	}
+
+	manifest, err := os.ReadFile("../../manifests/08_cr.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := &opv1.ClusterCSIDriver{}
+	if err := yaml.Unmarshal(manifest, driver); err != nil {
+		t.Fatalf("failed to read the ClusterCSIDriver: %v", err)
+	}
+	if _, err := operatorClient.OperatorV1().ClusterCSIDrivers().Create(ctx, driver, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create the ClusterCSIDriver: %v", err)
+	}
+}
+
+// waitForOperand waits for the static resources, the controller Deployment, the node
+// DaemonSet, the ServiceMonitor and the default StorageClass.
+func waitForOperand(ctx context.Context, t *testing.T, kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) {
+	t.Helper()
+	waitFor(t, "the operand", func() error {
+		if _, err := kubeClient.StorageV1().CSIDrivers().Get(ctx, instanceName, metav1.GetOptions{}); err != nil {
+			return err
+		}
+		for _, sa := range []string{"ovirt-csi-driver-controller-sa", "ovirt-csi-driver-node-sa"} {
+			if _, err := kubeClient.CoreV1().ServiceAccounts(defaultNamespace).Get(ctx, sa, metav1.GetOptions{}); err != nil {
+				return err
+			}
+		}
+		if _, err := kubeClient.AppsV1().Deployments(defaultNamespace).Get(ctx, controllerDeploymentName, metav1.GetOptions{}); err != nil {
+			return err
+		}
+		if _, err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Get(ctx, nodeDaemonSetName, metav1.GetOptions{}); err != nil {
+			return err
+		}
+		if _, err := dynamicClient.Resource(serviceMonitorResource).Namespace(defaultNamespace).Get(ctx, "ovirt-csi-driver-controller-monitor", metav1.GetOptions{}); err != nil {
+			return err
+		}
+		sc, err := kubeClient.StorageV1().StorageClasses().Get(ctx, "ovirt-csi-sc", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if sc.Parameters["storageDomainName"] != envtestStorageDomain {
+			return fmt.Errorf("expected the StorageClass on storage domain %s, got %s", envtestStorageDomain, sc.Parameters["storageDomainName"])
+		}
+		return nil
+	})
+}
+
+// markRolledOut reports the controller Deployment and the node DaemonSet as rolled out,
+// which the kube-controller-manager does in a cluster.
+func markRolledOut(ctx context.Context, t *testing.T, kubeClient kubernetes.Interface) {
+	t.Helper()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deployment, err := kubeClient.AppsV1().Deployments(defaultNamespace).Get(ctx, controllerDeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		replicas := *deployment.Spec.Replicas
+		deployment.Status = appsv1.DeploymentStatus{
+			ObservedGeneration: deployment.Generation,
+			Replicas:           replicas,
+			UpdatedReplicas:    replicas,
+			ReadyReplicas:      replicas,
+			AvailableReplicas:  replicas,
+		}
+		_, err = kubeClient.AppsV1().Deployments(defaultNamespace).UpdateStatus(ctx, deployment, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to update the Deployment status: %v", err)
+	}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		daemonSet, err := kubeClient.AppsV1().DaemonSets(defaultNamespace).Get(ctx, nodeDaemonSetName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		daemonSet.Status = appsv1.DaemonSetStatus{
+			ObservedGeneration:     daemonSet.Generation,
+			CurrentNumberScheduled: 1,
+			DesiredNumberScheduled: 1,
+			NumberReady:            1,
+			UpdatedNumberScheduled: 1,
+			NumberAvailable:        1,
+		}
+		_, err = kubeClient.AppsV1().DaemonSets(defaultNamespace).UpdateStatus(ctx, daemonSet, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to update the DaemonSet status: %v", err)
+	}
+}
+
+// operandAvailable returns an error while the controller Deployment or the node DaemonSet
+// is not reported available, or a condition reports the operator as degraded or progressing.
+func operandAvailable(ctx context.Context, operatorClient opclient.Interface) error {
+	driver, err := operatorClient.OperatorV1().ClusterCSIDrivers().Get(ctx, instanceName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, condition := range []string{
+		"OvirtDriverControllerServiceControllerAvailable",
+		"OvirtDriverNodeServiceControllerAvailable",
+	} {
+		if findCondition(driver.Status.Conditions, condition) == nil {
+			pending = append(pending, condition+" is missing")
+		}
+	}
+	for _, condition := range driver.Status.Conditions {
+		switch {
+		case strings.HasSuffix(condition.Type, opv1.OperatorStatusTypeAvailable) && condition.Status != opv1.ConditionTrue,
+			strings.HasSuffix(condition.Type, opv1.OperatorStatusTypeDegraded) && condition.Status == opv1.ConditionTrue,
+			strings.HasSuffix(condition.Type, opv1.OperatorStatusTypeProgressing) && condition.Status == opv1.ConditionTrue:
+			pending = append(pending, fmt.Sprintf("%s=%s: %s", condition.Type, condition.Status, condition.Message))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func expectCondition(ctx context.Context, operatorClient opclient.Interface, conditionType string, status opv1.ConditionStatus, reason string) error {
+	driver, err := operatorClient.OperatorV1().ClusterCSIDrivers().Get(ctx, instanceName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	condition := findCondition(driver.Status.Conditions, conditionType)
+	if condition == nil {
+		return fmt.Errorf("%s is missing", conditionType)
+	}
+	if condition.Status != status || condition.Reason != reason {
+		return fmt.Errorf("expected %s=%s with reason %s, got %s with reason %s", conditionType, status, reason, condition.Status, condition.Reason)
+	}
+	return nil
+}
+
+func findCondition(conditions []opv1.OperatorCondition, conditionType string) *opv1.OperatorCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func updateOperatorSpec(ctx context.Context, t *testing.T, operatorClient opclient.Interface, update func(spec *opv1.OperatorSpec)) {
+	t.Helper()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		driver, err := operatorClient.OperatorV1().ClusterCSIDrivers().Get(ctx, instanceName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		update(&driver.Spec.OperatorSpec)
+		_, err = operatorClient.OperatorV1().ClusterCSIDrivers().Update(ctx, driver, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to update the ClusterCSIDriver: %v", err)
+	}
+}
+
+func hasArg(podSpec *corev1.PodSpec, container, arg string) error {
+	for _, c := range podSpec.Containers {
+		if c.Name != container {
+			continue
+		}
+		for _, a := range c.Args {
+			if a == arg {
+				return nil
+			}
+		}
+		return fmt.Errorf("container %s has no argument %s: %v", container, arg, c.Args)
+	}
+	return fmt.Errorf("container %s not found", container)
+}
+
+// waitFor polls check until it succeeds, the last error is reported on timeout.
+func waitFor(t *testing.T, description string, check func() error) {
+	t.Helper()
+	var lastErr error
+	err := wait.PollImmediate(250*time.Millisecond, envtestTimeout, func() (bool, error) {
+		lastErr = check()
+		return lastErr == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("timed out waiting for %s: %v", description, lastErr)
+	}
+}
@@ -3,6 +3,8 @@ package operator
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	operatorapi "github.com/openshift/api/operator/v1"
 	opinformers "github.com/openshift/client-go/operator/informers/externalversions"
@@ -60,6 +62,15 @@ func NewOvirtStorageClassController(
 }
 
 func (c *OvirtStorageClassController) sync(ctx context.Context, _ factory.SyncContext) error {
+	opSpec, _, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+	if opSpec.ManagementState != operatorapi.Managed {
+		// With Removed the StorageClasses are deleted by the OvirtOperandRemovalController
+		return nil
+	}
+
 	scState := c.scStateEvaluator.GetStorageClassState(instanceName)
 	sdName, err := c.getStorageDomain(ctx, scState)
 	if err != nil {
@@ -94,17 +105,15 @@ func (c *OvirtStorageClassController) getStorageDomain(ctx context.Context, scSt
 		return "", nil
 	}
 
-	get, err := c.kubeClient.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("failed to get node: %w", err)
-		return "", err
-	}
-	nodeID := get.Status.NodeInfo.SystemUUID
-
 	ovirtClient, err := c.ovirtClientFactory()
 	if err != nil {
 		return "", fmt.Errorf("failed to create oVirt client (%w)", err)
 	}
+	nodeID, err := c.getNodeVMID(ctx, ovirtClient)
+	if err != nil {
+		klog.Errorf("failed to get node: %v", err)
+		return "", err
+	}
 	attachments, err := ovirtClient.ListDiskAttachments(ovirtclient.VMID(nodeID), ovirtclient.ContextStrategy(ctx))
 	if err != nil {
 		klog.Errorf("failed to fetch attachments: %w", err)
@@ -135,6 +144,39 @@ func (c *OvirtStorageClassController) getStorageDomain(ctx context.Context, scSt
 	return "", nil
 }
 
+// getNodeVMID returns the ID of the VM backing the node the operator runs on. Without a node
+// name, e.g. with a hosted control plane, the VM of the first node, by name, whose SystemUUID
+// is the ID of an engine VM is used.
+func (c *OvirtStorageClassController) getNodeVMID(ctx context.Context, ovirtClient ovirtclient.Client) (string, error) {
+	if c.nodeName != "" {
+		node, err := c.kubeClient.CoreV1().Nodes().Get(ctx, c.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return strings.ToLower(node.Status.NodeInfo.SystemUUID), nil
+	}
+	nodes, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	if len(nodes.Items) == 0 {
+		return "", fmt.Errorf("no nodes found")
+	}
+	sort.Slice(nodes.Items, func(i, j int) bool { return nodes.Items[i].Name < nodes.Items[j].Name })
+	for _, node := range nodes.Items {
+		vmID := strings.ToLower(node.Status.NodeInfo.SystemUUID)
+		if _, err := ovirtClient.GetVM(ovirtclient.VMID(vmID), ovirtclient.ContextStrategy(ctx)); err != nil {
+			if ovirtclient.HasErrorCode(err, ovirtclient.ENotFound) {
+				klog.V(4).Infof("Node %s is not backed by an oVirt VM", node.Name)
+				continue
+			}
+			return "", fmt.Errorf("failed to get VM %s of node %s: %w", vmID, node.Name, err)
+		}
+		return vmID, nil
+	}
+	return "", fmt.Errorf("none of the %d nodes is backed by an oVirt VM", len(nodes.Items))
+}
+
 func generateStorageClass(storageDomainName string) *storagev1.StorageClass {
 	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
 	expected := &storagev1.StorageClass{
@@ -0,0 +1,104 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetNodeVMID(t *testing.T) {
+	ovirtClient := ovirtclient.NewMock()
+	vmID := newMockVM(t, ovirtClient, "worker-1")
+	unknownVM := "5d0e6c3b-2f4a-4b1e-8c7d-9a8b7c6d5e4f"
+
+	testCases := []struct {
+		name     string
+		nodeName string
+		nodes    []*corev1.Node
+		expectID string
+		// expectErr is a part of the expected error, no error is expected when empty
+		expectErr string
+	}{
+		{
+			name:     "node of the operator",
+			nodeName: "worker-0",
+			nodes:    []*corev1.Node{newVMNode("worker-0", strings.ToUpper(unknownVM))},
+			expectID: unknownVM,
+		},
+		{
+			name:      "missing node of the operator",
+			nodeName:  "worker-0",
+			expectErr: "not found",
+		},
+		{
+			name: "hosted, first node backed by a VM",
+			nodes: []*corev1.Node{
+				newVMNode("worker-2", vmID),
+				newVMNode("worker-0", unknownVM),
+				newVMNode("worker-1", strings.ToUpper(vmID)),
+			},
+			expectID: vmID,
+		},
+		{
+			name:      "hosted, no node backed by a VM",
+			nodes:     []*corev1.Node{newVMNode("worker-0", unknownVM)},
+			expectErr: "none of the 1 nodes",
+		},
+		{
+			name:      "hosted, no nodes",
+			expectErr: "no nodes found",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var objects []runtime.Object
+			for _, node := range tc.nodes {
+				objects = append(objects, node)
+			}
+			c := &OvirtStorageClassController{
+				kubeClient: fake.NewSimpleClientset(objects...),
+				nodeName:   tc.nodeName,
+			}
+			id, err := c.getNodeVMID(context.Background(), ovirtClient)
+			if tc.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+					t.Fatalf("expected error %q, got %v", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if id != tc.expectID {
+				t.Errorf("expected VM %s, got %s", tc.expectID, id)
+			}
+		})
+	}
+}
+
+// newMockVM creates a VM in the mock engine and returns its ID.
+func newMockVM(t *testing.T, ovirtClient ovirtclient.MockClient, name string) string {
+	t.Helper()
+	clusters, err := ovirtClient.ListClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := ovirtClient.CreateVM(clusters[0].ID(), ovirtclient.DefaultBlankTemplateID, name, nil)
+	if err != nil {
+		t.Fatalf("failed to create VM %s: %v", name, err)
+	}
+	return string(vm.ID())
+}
+
+func newVMNode(name, systemUUID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{SystemUUID: systemUUID}},
+	}
+}
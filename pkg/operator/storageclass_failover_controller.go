@@ -0,0 +1,431 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// failoverDemotedStorageClassAnnotation marks the default StorageClass the operator made
+	// non-default because its storage domain is unusable
+	failoverDemotedStorageClassAnnotation = "csi.ovirt.org/demoted-by-failover"
+
+	// failoverPromotedStorageClassAnnotation marks the StorageClass the operator made the
+	// default instead, its value is the name of the original default StorageClass
+	failoverPromotedStorageClassAnnotation = "csi.ovirt.org/promoted-by-failover"
+
+	storageClassFailoverConditionType = "OvirtDefaultStorageClassFailover"
+
+	// storageClassFailoverInterval is the interval the storage domains are checked in
+	storageClassFailoverInterval = time.Minute
+)
+
+// storageDomainHealth holds the state of a storage domain relevant for the failover of the
+// default StorageClass.
+type storageDomainHealth struct {
+	status         ovirtclient.StorageDomainStatus
+	externalStatus ovirtclient.StorageDomainExternalStatus
+	size           uint64
+	available      uint64
+}
+
+// usable tells whether the storage domain is up and has at least freePercent of its size
+// free. A nil storage domain, i.e. one missing in oVirt, is not usable.
+func (h *storageDomainHealth) usable(freePercent int) bool {
+	switch {
+	case h == nil:
+		return false
+	case h.status != ovirtclient.StorageDomainStatusActive && h.status != ovirtclient.StorageDomainStatusNA:
+		return false
+	case h.externalStatus == ovirtclient.StorageDomainExternalStatusError || h.externalStatus == ovirtclient.StorageDomainExternalStatusFailure:
+		return false
+	case h.size == 0:
+		return false
+	}
+	return 100*h.available >= uint64(freePercent)*h.size
+}
+
+// describe explains why the storage domain is not usable with freePercent, for events and
+// condition messages.
+func (h *storageDomainHealth) describe(storageDomain string, freePercent int) string {
+	switch {
+	case h == nil:
+		return fmt.Sprintf("storage domain %s is not found", storageDomain)
+	case h.status != ovirtclient.StorageDomainStatusActive && h.status != ovirtclient.StorageDomainStatusNA:
+		return fmt.Sprintf("storage domain %s is %s", storageDomain, h.status)
+	case h.externalStatus == ovirtclient.StorageDomainExternalStatusError || h.externalStatus == ovirtclient.StorageDomainExternalStatusFailure:
+		return fmt.Sprintf("storage domain %s reports %s", storageDomain, h.externalStatus)
+	}
+	return fmt.Sprintf("storage domain %s has %s of %s free, below %d%%", storageDomain, formatBytes(h.available), formatBytes(h.size), freePercent)
+}
+
+// OvirtStorageClassFailoverController moves the default StorageClass annotation between the
+// StorageClasses of the driver. When the storage domain of the default StorageClass is in
+// maintenance, failing, or below minFreePercent free space, the StorageClass with a usable
+// storage domain and the most free space becomes the default. The original default gets it
+// back once its storage domain has been above recoveryFreePercent for failbackDelay.
+type OvirtStorageClassFailoverController struct {
+	name               string
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	storageClassLister storagelisters.StorageClassLister
+	getConfig          func() (*OperatorConfig, error)
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	eventRecorder      events.Recorder
+
+	// healthySince holds since when the storage domain of the original default StorageClass
+	// has been usable, by the name of the StorageClass
+	lock         sync.Mutex
+	healthySince map[string]time.Time
+}
+
+func NewOvirtStorageClassFailoverController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	storageClassInformer storagev1informers.StorageClassInformer,
+	configMapInformer corev1informers.ConfigMapInformer,
+	getConfig func() (*OperatorConfig, error),
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtStorageClassFailoverController{
+		name:               "OvirtStorageClassFailoverController",
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		storageClassLister: storageClassInformer.Lister(),
+		getConfig:          getConfig,
+		ovirtClientFactory: ovirtClientFactory,
+		eventRecorder:      eventRecorder,
+		healthySince:       map[string]time.Time{},
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		storageClassInformer.Informer(),
+		configMapInformer.Informer(),
+	).ResyncEvery(storageClassFailoverInterval).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtStorageClassFailoverController) sync(ctx context.Context, _ factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	config, err := c.getConfig()
+	if err != nil {
+		// reported by the OvirtOperatorConfigController
+		klog.V(4).Infof("Skipping default StorageClass failover: %v", err)
+		return nil
+	}
+	if config.Maintenance {
+		// the OvirtMaintenanceController owns the default StorageClasses during the maintenance
+		klog.V(4).Infof("Skipping default StorageClass failover during the maintenance")
+		return nil
+	}
+
+	allStorageClasses, err := c.storageClassLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	var storageClasses []*storagev1.StorageClass
+	var promoted *storagev1.StorageClass
+	for _, sc := range allStorageClasses {
+		if sc.Provisioner != instanceName {
+			continue
+		}
+		storageClasses = append(storageClasses, sc)
+		if _, ok := sc.Annotations[failoverPromotedStorageClassAnnotation]; ok && promoted == nil {
+			promoted = sc
+		}
+	}
+	sort.Slice(storageClasses, func(i, j int) bool { return storageClasses[i].Name < storageClasses[j].Name })
+
+	failover := config.DefaultStorageClassFailover
+	if !failover.Enabled {
+		c.resetHealthySince()
+		if promoted != nil {
+			blocked, err := c.failback(ctx, promoted, "the failover is disabled")
+			if err != nil {
+				return err
+			}
+			if blocked != "" {
+				return c.updateCondition(ctx, operatorapi.ConditionTrue, "FailbackBlocked", fmt.Sprintf("Default StorageClass failover is disabled, %s", blocked))
+			}
+		}
+		return c.updateCondition(ctx, operatorapi.ConditionFalse, "Disabled", "Default StorageClass failover is disabled")
+	}
+
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	health, err := listStorageDomainHealth(ctx, ovirtClient)
+	if err != nil {
+		return err
+	}
+	minFreePercent, recoveryFreePercent := *failover.MinFreePercent, *failover.RecoveryFreePercent
+
+	if promoted != nil {
+		original := promoted.Annotations[failoverPromotedStorageClassAnnotation]
+		originalSC, err := c.storageClassLister.Get(original)
+		if apierrors.IsNotFound(err) {
+			// nothing to fail back to, the promoted StorageClass stays the default
+			c.resetHealthySince()
+			if err := patchStorageClassAnnotations(ctx, c.kubeClient, promoted, map[string]interface{}{failoverPromotedStorageClassAnnotation: nil}); err != nil {
+				return err
+			}
+			c.eventRecorder.Eventf("DefaultStorageClassFailback", "StorageClass %s stays the default, the original default StorageClass %s no longer exists", promoted.Name, original)
+			return c.updateCondition(ctx, operatorapi.ConditionFalse, "AsExpected", fmt.Sprintf("StorageClass %s is the default", promoted.Name))
+		}
+		if err != nil {
+			return err
+		}
+
+		originalDomain := originalSC.Parameters["storageDomainName"]
+		var blocked string
+		if health[originalDomain].usable(recoveryFreePercent) {
+			since := c.markHealthy(original)
+			if time.Since(since) >= failover.FailbackDelay.Duration {
+				reason := fmt.Sprintf("its storage domain %s has been usable for %s", originalDomain, failover.FailbackDelay.Duration)
+				if blocked, err = c.failback(ctx, promoted, reason); err != nil {
+					return err
+				}
+				if blocked == "" {
+					c.resetHealthySince()
+					return c.updateCondition(ctx, operatorapi.ConditionFalse, "AsExpected", fmt.Sprintf("StorageClass %s is the default", original))
+				}
+			}
+		} else {
+			c.resetHealthySince()
+		}
+
+		current := promoted.Name
+		promotedDomain := promoted.Parameters["storageDomainName"]
+		if !health[promotedDomain].usable(minFreePercent) {
+			// fail over again, the original default StorageClass stays the one to fail back to
+			reason := health[promotedDomain].describe(promotedDomain, minFreePercent)
+			candidate, err := c.failover(ctx, promoted, original, reason, storageClasses, health, recoveryFreePercent)
+			if err != nil || candidate == "" {
+				return err
+			}
+			current = candidate
+		}
+		message := fmt.Sprintf("StorageClass %s is the default instead of %s", current, original)
+		reason := "FailedOver"
+		switch {
+		case blocked != "":
+			reason = "FailbackBlocked"
+			message += ", " + blocked
+		case health[originalDomain].usable(recoveryFreePercent):
+			message += fmt.Sprintf(", its storage domain %s is usable again since %s", originalDomain, c.markHealthy(original).UTC().Format(time.RFC3339))
+		default:
+			message += ": " + health[originalDomain].describe(originalDomain, recoveryFreePercent)
+		}
+		return c.updateCondition(ctx, operatorapi.ConditionTrue, reason, message)
+	}
+
+	c.resetHealthySince()
+	for _, sc := range storageClasses {
+		if sc.Annotations[defaultStorageClassAnnotation] != "true" {
+			continue
+		}
+		storageDomain := sc.Parameters["storageDomainName"]
+		if health[storageDomain].usable(minFreePercent) {
+			continue
+		}
+		reason := health[storageDomain].describe(storageDomain, minFreePercent)
+		candidate, err := c.failover(ctx, sc, sc.Name, reason, storageClasses, health, recoveryFreePercent)
+		if err != nil || candidate == "" {
+			return err
+		}
+		return c.updateCondition(ctx, operatorapi.ConditionTrue, "FailedOver",
+			fmt.Sprintf("StorageClass %s is the default instead of %s: %s", candidate, sc.Name, reason))
+	}
+	return c.updateCondition(ctx, operatorapi.ConditionFalse, "AsExpected", "The storage domain of the default StorageClass is usable")
+}
+
+// failover makes the usable StorageClass with the most free space the default instead of
+// from. original is the StorageClass to fail back to. It returns the name of the new default
+// StorageClass, or an empty name when there is no usable StorageClass and the condition is
+// already reported.
+func (c *OvirtStorageClassFailoverController) failover(
+	ctx context.Context,
+	from *storagev1.StorageClass,
+	original string,
+	reason string,
+	storageClasses []*storagev1.StorageClass,
+	health map[string]*storageDomainHealth,
+	recoveryFreePercent int,
+) (string, error) {
+	var candidate *storagev1.StorageClass
+	for _, sc := range storageClasses {
+		if sc.Name == from.Name || sc.Name == original || sc.Annotations[defaultStorageClassAnnotation] == "true" {
+			continue
+		}
+		if _, ok := sc.Annotations[demotedStorageClassAnnotation]; ok {
+			continue
+		}
+		if _, ok := sc.Annotations[maintenanceStorageClassAnnotation]; ok {
+			continue
+		}
+		if _, ok := sc.Annotations[failoverDemotedStorageClassAnnotation]; ok {
+			continue
+		}
+		sd := health[sc.Parameters["storageDomainName"]]
+		if !sd.usable(recoveryFreePercent) {
+			continue
+		}
+		// storageClasses are sorted by name, the first one wins a tie
+		if candidate == nil || sd.available > health[candidate.Parameters["storageDomainName"]].available {
+			candidate = sc
+		}
+	}
+	if candidate == nil {
+		message := fmt.Sprintf("StorageClass %s stays the default, no other StorageClass has a usable storage domain with %d%% free space: %s", from.Name, recoveryFreePercent, reason)
+		_, oldStatus, _, err := c.operatorClient.GetOperatorState()
+		if err != nil {
+			return "", err
+		}
+		if previous := v1helpers.FindOperatorCondition(oldStatus.Conditions, storageClassFailoverConditionType); previous == nil || previous.Reason != "NoHealthyStorageClass" {
+			c.eventRecorder.Warning("NoHealthyStorageClass", message)
+		}
+		return "", c.updateCondition(ctx, operatorapi.ConditionFalse, "NoHealthyStorageClass", message)
+	}
+
+	if err := patchStorageClassAnnotations(ctx, c.kubeClient, candidate, map[string]interface{}{
+		defaultStorageClassAnnotation:          "true",
+		failoverPromotedStorageClassAnnotation: original,
+	}); err != nil {
+		return "", err
+	}
+	fromAnnotations := map[string]interface{}{defaultStorageClassAnnotation: "false"}
+	if from.Name == original {
+		fromAnnotations[failoverDemotedStorageClassAnnotation] = "true"
+	} else {
+		fromAnnotations[failoverPromotedStorageClassAnnotation] = nil
+	}
+	if err := patchStorageClassAnnotations(ctx, c.kubeClient, from, fromAnnotations); err != nil {
+		return "", err
+	}
+	c.eventRecorder.Warningf("DefaultStorageClassFailover", "StorageClass %s is the default instead of %s: %s", candidate.Name, from.Name, reason)
+	return candidate.Name, nil
+}
+
+// failback makes the original default StorageClass of promoted the default again. It only
+// clears the markers of the failover. While the original or promoted StorageClass carries the
+// marker of the overcommit guard or the maintenance, which restore the default StorageClass
+// on their own, nothing is changed and the returned message tells why.
+func (c *OvirtStorageClassFailoverController) failback(ctx context.Context, promoted *storagev1.StorageClass, reason string) (string, error) {
+	original := promoted.Annotations[failoverPromotedStorageClassAnnotation]
+	originalSC, err := c.storageClassLister.Get(original)
+	switch {
+	case apierrors.IsNotFound(err):
+		// nothing to fail back to, the promoted StorageClass stays the default
+		originalSC = nil
+	case err != nil:
+		return "", err
+	}
+	for _, sc := range []*storagev1.StorageClass{originalSC, promoted} {
+		if sc == nil {
+			continue
+		}
+		if marker := foreignDemotionMarker(sc); marker != "" {
+			return fmt.Sprintf("the failback to %s waits until StorageClass %s no longer has the %s annotation", original, sc.Name, marker), nil
+		}
+	}
+
+	if originalSC != nil {
+		if err := patchStorageClassAnnotations(ctx, c.kubeClient, originalSC, map[string]interface{}{
+			defaultStorageClassAnnotation:         "true",
+			failoverDemotedStorageClassAnnotation: nil,
+		}); err != nil {
+			return "", err
+		}
+	}
+	promotedAnnotations := map[string]interface{}{failoverPromotedStorageClassAnnotation: nil}
+	if originalSC != nil {
+		promotedAnnotations[defaultStorageClassAnnotation] = "false"
+	}
+	if err := patchStorageClassAnnotations(ctx, c.kubeClient, promoted, promotedAnnotations); err != nil {
+		return "", err
+	}
+	if originalSC != nil {
+		c.eventRecorder.Eventf("DefaultStorageClassFailback", "StorageClass %s is the default again instead of %s, %s", original, promoted.Name, reason)
+	}
+	return "", nil
+}
+
+// foreignDemotionMarker returns the marker annotation the overcommit guard or the maintenance
+// demoted the StorageClass with, empty when there is none.
+func foreignDemotionMarker(sc *storagev1.StorageClass) string {
+	for _, marker := range []string{demotedStorageClassAnnotation, maintenanceStorageClassAnnotation} {
+		if _, ok := sc.Annotations[marker]; ok {
+			return marker
+		}
+	}
+	return ""
+}
+
+func (c *OvirtStorageClassFailoverController) markHealthy(original string) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	since, ok := c.healthySince[original]
+	if !ok {
+		since = time.Now()
+		c.healthySince[original] = since
+	}
+	return since
+}
+
+func (c *OvirtStorageClassFailoverController) resetHealthySince() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.healthySince = map[string]time.Time{}
+}
+
+func (c *OvirtStorageClassFailoverController) updateCondition(ctx context.Context, status operatorapi.ConditionStatus, reason, message string) error {
+	_, _, err := v1helpers.UpdateStatus(ctx, c.operatorClient, v1helpers.UpdateConditionFn(operatorapi.OperatorCondition{
+		Type:    storageClassFailoverConditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}))
+	return err
+}
+
+// listStorageDomainHealth returns the health of the storage domains by their name.
+func listStorageDomainHealth(ctx context.Context, ovirtClient ovirtclient.Client) (map[string]*storageDomainHealth, error) {
+	storageDomains, err := ovirtClient.ListStorageDomains(ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage domains: %w", err)
+	}
+	capacities, err := listStorageDomainCapacities(ctx, ovirtClient)
+	if err != nil {
+		return nil, err
+	}
+	health := map[string]*storageDomainHealth{}
+	for _, sd := range storageDomains {
+		h := &storageDomainHealth{status: sd.Status(), externalStatus: sd.ExternalStatus()}
+		if capacity, ok := capacities[sd.ID()]; ok {
+			h.size, h.available = capacity.size, capacity.available
+		}
+		health[sd.Name()] = h
+	}
+	return health, nil
+}
@@ -0,0 +1,166 @@
+package operator
+
+import (
+	"strings"
+	"testing"
+
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStorageDomainHealthUsable(t *testing.T) {
+	const minFreePercent, recoveryFreePercent = 5, 15
+	active := func(size, available uint64) *storageDomainHealth {
+		return &storageDomainHealth{
+			status:         ovirtclient.StorageDomainStatusActive,
+			externalStatus: ovirtclient.StorageDomainExternalStatusOk,
+			size:           size,
+			available:      available,
+		}
+	}
+	testCases := []struct {
+		name   string
+		health *storageDomainHealth
+		// expectKeep tells whether the default StorageClass stays on the storage domain,
+		// expectTake whether the storage domain may take the default over or get it back
+		expectKeep bool
+		expectTake bool
+		describe   string
+	}{
+		{
+			name:       "plenty of free space",
+			health:     active(1000, 500),
+			expectKeep: true,
+			expectTake: true,
+		},
+		{
+			name:       "exactly the recovery threshold",
+			health:     active(1000, 150),
+			expectKeep: true,
+			expectTake: true,
+		},
+		{
+			name:       "between the thresholds keeps but does not take the default",
+			health:     active(1000, 100),
+			expectKeep: true,
+			expectTake: false,
+			describe:   "below 15%",
+		},
+		{
+			name:       "exactly the failover threshold",
+			health:     active(1000, 50),
+			expectKeep: true,
+			expectTake: false,
+		},
+		{
+			name:     "below the failover threshold",
+			health:   active(1000, 49),
+			describe: "below 5%",
+		},
+		{
+			name:     "full",
+			health:   active(1000, 0),
+			describe: "below 5%",
+		},
+		{
+			name:     "unknown size",
+			health:   active(0, 0),
+			describe: "below 5%",
+		},
+		{
+			name:     "missing in oVirt",
+			health:   nil,
+			describe: "is not found",
+		},
+		{
+			name: "in maintenance",
+			health: &storageDomainHealth{
+				status:    ovirtclient.StorageDomainStatusMaintenance,
+				size:      1000,
+				available: 900,
+			},
+			describe: "is maintenance",
+		},
+		{
+			name: "external status error",
+			health: &storageDomainHealth{
+				status:         ovirtclient.StorageDomainStatusActive,
+				externalStatus: ovirtclient.StorageDomainExternalStatusError,
+				size:           1000,
+				available:      900,
+			},
+			describe: "reports error",
+		},
+		{
+			name: "no status, e.g. a storage domain of another data center",
+			health: &storageDomainHealth{
+				status:    ovirtclient.StorageDomainStatusNA,
+				size:      1000,
+				available: 900,
+			},
+			expectKeep: true,
+			expectTake: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if keep := tc.health.usable(minFreePercent); keep != tc.expectKeep {
+				t.Errorf("expected usable(%d) %t, got %t", minFreePercent, tc.expectKeep, keep)
+			}
+			if take := tc.health.usable(recoveryFreePercent); take != tc.expectTake {
+				t.Errorf("expected usable(%d) %t, got %t", recoveryFreePercent, tc.expectTake, take)
+			}
+			if tc.describe == "" {
+				return
+			}
+			freePercent := minFreePercent
+			if tc.expectKeep {
+				freePercent = recoveryFreePercent
+			}
+			if message := tc.health.describe("nfs", freePercent); !strings.Contains(message, tc.describe) {
+				t.Errorf("expected the description to contain %q, got %q", tc.describe, message)
+			}
+		})
+	}
+}
+
+func TestForeignDemotionMarker(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expect      string
+	}{
+		{
+			name:   "no annotations",
+			expect: "",
+		},
+		{
+			name: "only the markers of the failover",
+			annotations: map[string]string{
+				defaultStorageClassAnnotation:          "true",
+				failoverPromotedStorageClassAnnotation: "ovirt-csi-sc",
+				failoverDemotedStorageClassAnnotation:  "true",
+			},
+			expect: "",
+		},
+		{
+			name:        "demoted by the overcommit guard",
+			annotations: map[string]string{demotedStorageClassAnnotation: "true"},
+			expect:      demotedStorageClassAnnotation,
+		},
+		{
+			name:        "demoted by the maintenance",
+			annotations: map[string]string{maintenanceStorageClassAnnotation: "true"},
+			expect:      maintenanceStorageClassAnnotation,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "ovirt-csi-sc", Annotations: tc.annotations}}
+			if got := foreignDemotionMarker(sc); got != tc.expect {
+				t.Errorf("expected marker %q, got %q", tc.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	operatorapi "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/pkg/version"
+)
+
+const (
+	// operandImagesConditionType is the condition listing the images of the applied operand
+	operandImagesConditionType = "OvirtOperandImages"
+	// operandImageResource is the resource of the status generations which held the operand
+	// images in earlier versions, they are removed
+	operandImageResource = "images"
+)
+
+// OvirtVersionController publishes the operator version in status.version and the images of
+// the applied controller Deployment and node DaemonSet in the OvirtOperandImages condition,
+// so that fleet tooling can detect version skew. The images are read from the workloads
+// rather than from the environment of the operator, they are what actually runs.
+type OvirtVersionController struct {
+	name                  string
+	operatorClient        v1helpers.OperatorClient
+	deploymentLister      appslisters.DeploymentLister
+	daemonSetLister       appslisters.DaemonSetLister
+	controlPlaneNamespace string
+	version               string
+	eventRecorder         events.Recorder
+}
+
+func NewOvirtVersionController(
+	operatorClient v1helpers.OperatorClient,
+	deploymentInformer appsinformers.DeploymentInformer,
+	daemonSetInformer appsinformers.DaemonSetInformer,
+	controlPlaneNamespace string,
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtVersionController{
+		name:                  "OvirtVersionController",
+		operatorClient:        operatorClient,
+		deploymentLister:      deploymentInformer.Lister(),
+		daemonSetLister:       daemonSetInformer.Lister(),
+		controlPlaneNamespace: controlPlaneNamespace,
+		version:               operatorVersion(),
+		eventRecorder:         eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithInformers(
+		operatorClient.Informer(),
+		deploymentInformer.Informer(),
+		daemonSetInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *OvirtVersionController) sync(ctx context.Context, _ factory.SyncContext) error {
+	_, status, _, err := c.operatorClient.GetOperatorState()
+	if err != nil {
+		return err
+	}
+
+	var applied, missing []string
+	images := map[string]string{}
+	deployment, err := c.deploymentLister.Deployments(c.controlPlaneNamespace).Get(controllerDeploymentName)
+	switch {
+	case apierrors.IsNotFound(err):
+		missing = append(missing, "Deployment "+controllerDeploymentName)
+	case err != nil:
+		return err
+	default:
+		applied = append(applied, "Deployment "+controllerDeploymentName+": "+podImages(&deployment.Spec.Template.Spec, images))
+	}
+	daemonSet, err := c.daemonSetLister.DaemonSets(defaultNamespace).Get(nodeDaemonSetName)
+	switch {
+	case apierrors.IsNotFound(err):
+		missing = append(missing, "DaemonSet "+nodeDaemonSetName)
+	case err != nil:
+		return err
+	default:
+		applied = append(applied, "DaemonSet "+nodeDaemonSetName+": "+podImages(&daemonSet.Spec.Template.Spec, images))
+	}
+
+	operandImageInfo.Reset()
+	for name, image := range images {
+		operandImageInfo.WithLabelValues(name, image).Set(1)
+	}
+
+	condition := operatorapi.OperatorCondition{
+		Type:    operandImagesConditionType,
+		Status:  operatorapi.ConditionTrue,
+		Reason:  "Applied",
+		Message: strings.Join(applied, "; "),
+	}
+	if len(missing) > 0 {
+		condition.Status = operatorapi.ConditionFalse
+		condition.Reason = "NotApplied"
+		condition.Message = fmt.Sprintf("%s not found", strings.Join(missing, " and "))
+		if len(applied) > 0 {
+			condition.Message += "; " + strings.Join(applied, "; ")
+		}
+	}
+
+	existing := v1helpers.FindOperatorCondition(status.Conditions, operandImagesConditionType)
+	if status.Version == c.version && !hasImageGenerations(status.Generations) && existing != nil &&
+		existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+		return nil
+	}
+
+	klog.V(2).Infof("Updating the status to version %s, operand images: %s", c.version, condition.Message)
+	_, _, err = v1helpers.UpdateStatus(ctx, c.operatorClient, func(status *operatorapi.OperatorStatus) error {
+		status.Version = c.version
+		var generations []operatorapi.GenerationStatus
+		for _, generation := range status.Generations {
+			if generation.Resource != operandImageResource {
+				generations = append(generations, generation)
+			}
+		}
+		status.Generations = generations
+		return nil
+	}, v1helpers.UpdateConditionFn(condition))
+	return err
+}
+
+// podImages adds the images of the containers of the pod spec to images by container name
+// and returns them as a sorted name=image list.
+func podImages(podSpec *corev1.PodSpec, images map[string]string) string {
+	var pairs []string
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			images[container.Name] = container.Image
+			pairs = append(pairs, container.Name+"="+container.Image)
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// hasImageGenerations reports whether the generations still hold operand images, as
+// published by earlier versions of the operator.
+func hasImageGenerations(generations []operatorapi.GenerationStatus) bool {
+	for _, generation := range generations {
+		if generation.Resource == operandImageResource {
+			return true
+		}
+	}
+	return false
+}
+
+// operatorVersion returns the version the operator was built from.
+func operatorVersion() string {
+	if v := version.Get().GitVersion; v != "" {
+		return v
+	}
+	return "unknown"
+}
@@ -0,0 +1,93 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestVersionSync(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: controllerDeploymentName, Namespace: defaultNamespace},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "csi-provisioner", Image: "registry/provisioner:v1"},
+			{Name: "csi-driver", Image: "registry/driver:v1"},
+		}}}},
+	}
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeDaemonSetName, Namespace: defaultNamespace},
+		Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "csi-driver", Image: "registry/driver:v1"},
+		}}}},
+	}
+
+	testCases := []struct {
+		name          string
+		objects       []runtime.Object
+		expectStatus  opv1.ConditionStatus
+		expectReason  string
+		expectMessage string
+	}{
+		{
+			name:         "applied operand",
+			objects:      []runtime.Object{deployment, daemonSet},
+			expectStatus: opv1.ConditionTrue,
+			expectReason: "Applied",
+			expectMessage: "Deployment ovirt-csi-driver-controller: csi-driver=registry/driver:v1, csi-provisioner=registry/provisioner:v1; " +
+				"DaemonSet ovirt-csi-driver-node: csi-driver=registry/driver:v1",
+		},
+		{
+			name:          "missing DaemonSet",
+			objects:       []runtime.Object{deployment},
+			expectStatus:  opv1.ConditionFalse,
+			expectReason:  "NotApplied",
+			expectMessage: "DaemonSet ovirt-csi-driver-node not found; Deployment ovirt-csi-driver-controller: csi-driver=registry/driver:v1, csi-provisioner=registry/provisioner:v1",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			// Earlier versions published the images from the environment as generations
+			operatorClient := v1helpers.NewFakeOperatorClient(&opv1.OperatorSpec{ManagementState: opv1.Managed}, &opv1.OperatorStatus{
+				Generations: []opv1.GenerationStatus{
+					{Resource: operandImageResource, Namespace: defaultNamespace, Name: "driver", Hash: "registry/driver:v0"},
+					{Group: "apps", Resource: "daemonsets", Namespace: defaultNamespace, Name: nodeDaemonSetName, LastGeneration: 2},
+				},
+			}, nil)
+			c := &OvirtVersionController{
+				name:                  "OvirtVersionController",
+				operatorClient:        operatorClient,
+				deploymentLister:      kubeInformers.Apps().V1().Deployments().Lister(),
+				daemonSetLister:       kubeInformers.Apps().V1().DaemonSets().Lister(),
+				controlPlaneNamespace: defaultNamespace,
+				version:               "v4.14.0",
+				eventRecorder:         events.NewInMemoryRecorder("test"),
+			}
+			if err := c.sync(context.Background(), nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectOperatorCondition(t, operatorClient, operandImagesConditionType, tc.expectStatus, tc.expectReason)
+			_, status, _, err := operatorClient.GetOperatorState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message := v1helpers.FindOperatorCondition(status.Conditions, operandImagesConditionType).Message; message != tc.expectMessage {
+				t.Errorf("expected message %q, got %q", tc.expectMessage, message)
+			}
+			if status.Version != "v4.14.0" {
+				t.Errorf("expected version v4.14.0, got %q", status.Version)
+			}
+			if len(status.Generations) != 1 || status.Generations[0].Resource != "daemonsets" {
+				t.Errorf("expected only the DaemonSet generation, got %v", status.Generations)
+			}
+		})
+	}
+}
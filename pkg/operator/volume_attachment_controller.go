@@ -0,0 +1,60 @@
+package operator
+
+import (
+	"context"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	"k8s.io/apimachinery/pkg/labels"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+)
+
+// OvirtVolumeAttachmentController exports since when the VolumeAttachments of the driver wait
+// for their volume to be attached, or detached once they are deleted, so that the
+// OvirtCSIVolumeAttachmentStuck alert can tell the stuck ones.
+type OvirtVolumeAttachmentController struct {
+	volumeAttachmentLister storagelisters.VolumeAttachmentLister
+	eventRecorder          events.Recorder
+}
+
+func NewOvirtVolumeAttachmentController(
+	operatorClient v1helpers.OperatorClient,
+	volumeAttachmentInformer storagev1informers.VolumeAttachmentInformer,
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtVolumeAttachmentController{
+		volumeAttachmentLister: volumeAttachmentInformer.Lister(),
+		eventRecorder:          eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		volumeAttachmentInformer.Informer(),
+	).ToController("OvirtVolumeAttachmentController", eventRecorder)
+}
+
+func (c *OvirtVolumeAttachmentController) sync(_ context.Context, _ factory.SyncContext) error {
+	attachments, err := c.volumeAttachmentLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	volumeAttachmentPendingSince.Reset()
+	for _, va := range attachments {
+		if va.Spec.Attacher != instanceName {
+			continue
+		}
+		var pv string
+		if va.Spec.Source.PersistentVolumeName != nil {
+			pv = *va.Spec.Source.PersistentVolumeName
+		}
+		switch {
+		case va.DeletionTimestamp != nil:
+			volumeAttachmentPendingSince.WithLabelValues(va.Name, va.Spec.NodeName, pv, "detach").Set(float64(va.DeletionTimestamp.Unix()))
+		case !va.Status.Attached:
+			volumeAttachmentPendingSince.WithLabelValues(va.Name, va.Spec.NodeName, pv, "attach").Set(float64(va.CreationTimestamp.Unix()))
+		}
+	}
+	return nil
+}
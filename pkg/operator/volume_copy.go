@@ -0,0 +1,209 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// copyProgressPath is served by copy-volume with the copied and total bytes
+const copyProgressPath = "progress"
+
+// CopyVolume copies the source volume to the target volume and serves its progress until the
+// copy is done. Both are directories of mounted file systems or both are block devices. It
+// runs in the copy Jobs of a VolumeMigration.
+func CopyVolume(ctx context.Context, source, target string) error {
+	progress := &transferProgress{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+copyProgressPath, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d %d\n", progress.done.Load(), progress.total.Load())
+	})
+	server := &http.Server{Addr: fmt.Sprintf(":%d", imageSourcePort), Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			klog.Warningf("Failed to serve the progress: %v", err)
+		}
+	}()
+	defer server.Close()
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeDevice != 0 {
+		err = copyBlockDevice(source, target, progress)
+	} else {
+		err = copyTree(ctx, source, target, progress)
+	}
+	if err != nil {
+		return err
+	}
+	klog.Infof("Copied %d bytes from %s to %s", progress.done.Load(), source, target)
+	return nil
+}
+
+func copyBlockDevice(source, target string, progress *transferProgress) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	targetSize, err := dst.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if targetSize < size {
+		return fmt.Errorf("%s has %d bytes, smaller than the %d bytes of %s", target, targetSize, size, source)
+	}
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	progress.total.Store(size)
+	if _, err := io.Copy(dst, io.TeeReader(src, progress)); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", source, target, err)
+	}
+	return dst.Sync()
+}
+
+// copyTree copies the files, directories and symbolic links with their owners, modes and
+// modification times. Hard links are copied as separate files, other special files and the
+// lost+found directory of the file system are skipped.
+func copyTree(ctx context.Context, source, target string, progress *transferProgress) error {
+	var total int64
+	err := filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	progress.total.Store(total)
+
+	// The attributes of the directories are copied last, copying their content changes them
+	var dirs []string
+	err = filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(target, rel)
+		switch {
+		case d.IsDir():
+			if rel == "lost+found" {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, rel)
+			return os.MkdirAll(dst, 0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(link, dst); err != nil {
+				return err
+			}
+		case d.Type().IsRegular():
+			if err := copyFile(p, dst, progress); err != nil {
+				return err
+			}
+		default:
+			klog.Warningf("Skipping %s, special files are not copied", p)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyAttributes(dst, info)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(filepath.Join(source, dirs[i]))
+		if err != nil {
+			return err
+		}
+		if err := copyAttributes(filepath.Join(target, dirs[i]), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(source, target string, progress *transferProgress) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, io.TeeReader(src, progress))
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", source, err)
+	}
+	return nil
+}
+
+// copyAttributes sets the owner, mode and modification time of the source on the target. The
+// owner is set first, a change of the owner clears the setuid and setgid bits.
+func copyAttributes(target string, info fs.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	mode := info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
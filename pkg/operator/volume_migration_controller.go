@@ -0,0 +1,930 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	storagev1informers "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+const (
+	// volumeMigrationAnnotation on the new PVCs and the scaled down workloads names their
+	// VolumeMigration
+	volumeMigrationAnnotation = "csi.ovirt.org/volume-migration"
+
+	// migrationReplicasAnnotation on the scaled down workloads holds their replicas before
+	// the migration
+	migrationReplicasAnnotation = "csi.ovirt.org/replicas-before-migration"
+
+	// migratedFromAnnotation on the new PVCs names the migrated PVC, migratedToAnnotation on
+	// the migrated PVC names the new one once the workloads use it
+	migratedFromAnnotation = "csi.ovirt.org/migrated-from"
+	migratedToAnnotation   = "csi.ovirt.org/migrated-to"
+
+	// copyJobLabel on the pods of the copy Jobs is the UID of the migrated PVC
+	copyJobLabel = "csi.ovirt.org/volume-copy"
+
+	// migratedClaimSuffix is appended to the name of a migrated PVC for its new PVC
+	migratedClaimSuffix = "-migrated"
+
+	// migrationProgressInterval is how often running migrations are checked, their workloads
+	// and copy Jobs are not watched
+	migrationProgressInterval = 10 * time.Second
+
+	// migrationRetryInterval is how often pending migrations are checked again
+	migrationRetryInterval = time.Minute
+
+	// copyJobDeadline bounds the run time of a copy Job, a copy which does not finish in time
+	// fails
+	copyJobDeadline = 24 * time.Hour
+
+	// copyJobStartTimeout is how long a copy Job may stay without any pod, e.g. because the
+	// admission refuses the pod of its service account, before the copy fails
+	copyJobStartTimeout = 5 * time.Minute
+)
+
+// VolumeMigrationController reconciles the VolumeMigration resources, which move PVCs bound to
+// PVs of the driver to another StorageClass:
+//   - Pending: the PVCs are checked and the new PVCs created, the Deployments and StatefulSets
+//     whose pod template uses the PVCs are recorded.
+//   - ScalingDown: the workloads are scaled to zero, their replicas are kept in an annotation.
+//     The phase ends once no pod uses the PVCs anymore.
+//   - Copying: a Job per PVC copies the data to the new PVC and serves its progress.
+//   - CuttingOver: the pod templates of the workloads are switched to the new PVCs and the
+//     workloads scaled back up.
+//
+// When a copy fails, runs longer than copyJobDeadline or cannot create its pod within
+// copyJobStartTimeout, the workloads are scaled back up with the original PVCs. The migrated
+// PVCs and their oVirt disks are kept, they are deleted by the admin once the data is verified.
+type VolumeMigrationController struct {
+	name            string
+	operatorClient  v1helpers.OperatorClient
+	kubeClient      kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	migrationLister cache.GenericLister
+	pvcLister       corelisters.PersistentVolumeClaimLister
+	pvLister        corelisters.PersistentVolumeLister
+	scLister        storagelisters.StorageClassLister
+	operatorImage   string
+	eventRecorder   events.Recorder
+}
+
+func NewVolumeMigrationController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	migrationInformer informers.GenericInformer,
+	pvcInformer corev1informers.PersistentVolumeClaimInformer,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	scInformer storagev1informers.StorageClassInformer,
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &VolumeMigrationController{
+		name:            "VolumeMigrationController",
+		operatorClient:  operatorClient,
+		kubeClient:      kubeClient,
+		dynamicClient:   dynamicClient,
+		migrationLister: migrationInformer.Lister(),
+		pvcLister:       pvcInformer.Lister(),
+		pvLister:        pvInformer.Lister(),
+		scLister:        scInformer.Lister(),
+		operatorImage:   os.Getenv(operatorImageEnv),
+		eventRecorder:   eventRecorder,
+	}
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithInformers(
+		operatorClient.Informer(),
+		migrationInformer.Informer(),
+		pvcInformer.Informer(),
+		scInformer.Informer(),
+	).ToController(c.name, c.eventRecorder)
+}
+
+func (c *VolumeMigrationController) sync(ctx context.Context, syncCtx factory.SyncContext) error {
+	if managed, err := operandManaged(c.operatorClient); err != nil || !managed {
+		return err
+	}
+
+	objs, err := c.migrationLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	active := false
+	requeue := migrationRetryInterval
+	var errs []error
+	for _, obj := range objs {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		migration := &v1alpha1.VolumeMigration{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, migration); err != nil {
+			errs = append(errs, fmt.Errorf("failed to decode VolumeMigration %s/%s: %w", u.GetNamespace(), u.GetName(), err))
+			continue
+		}
+		if migration.Status.Phase == v1alpha1.VolumeMigrationSucceeded || migration.Status.Phase == v1alpha1.VolumeMigrationFailed {
+			continue
+		}
+
+		status := migration.Status.DeepCopy()
+		err := c.syncMigration(ctx, migration, status)
+		if err == nil {
+			err = c.updateStatus(ctx, migration, status)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("VolumeMigration %s/%s: %w", migration.Namespace, migration.Name, err))
+		}
+		switch status.Phase {
+		case v1alpha1.VolumeMigrationSucceeded, v1alpha1.VolumeMigrationFailed:
+		case v1alpha1.VolumeMigrationPending, "":
+			active = true
+		default:
+			active, requeue = true, migrationProgressInterval
+		}
+	}
+	if active {
+		syncCtx.Queue().AddAfter(syncCtx.QueueKey(), requeue)
+	}
+	return v1helpers.NewMultiLineAggregate(errs)
+}
+
+// syncMigration advances the migration through as many phases as it can and records its
+// state in the status. All phases can be repeated, e.g. when the status was not updated.
+func (c *VolumeMigrationController) syncMigration(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	for {
+		phase := status.Phase
+		var err error
+		switch phase {
+		case "", v1alpha1.VolumeMigrationPending:
+			err = c.prepare(ctx, migration, status)
+		case v1alpha1.VolumeMigrationScalingDown:
+			err = c.scaleDown(ctx, migration, status)
+		case v1alpha1.VolumeMigrationCopying:
+			err = c.copyVolumes(ctx, migration, status)
+		case v1alpha1.VolumeMigrationCuttingOver:
+			err = c.cutOver(ctx, migration, status)
+		default:
+			return nil
+		}
+		if err != nil || status.Phase == phase {
+			return err
+		}
+		klog.Infof("VolumeMigration %s/%s is %s", migration.Namespace, migration.Name, status.Phase)
+	}
+}
+
+// prepare checks the PVCs, finds their workloads and creates the new PVCs.
+func (c *VolumeMigrationController) prepare(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	pending := func(format string, args ...interface{}) error {
+		status.Phase, status.Message = v1alpha1.VolumeMigrationPending, fmt.Sprintf(format, args...)
+		return nil
+	}
+	spec := migration.Spec
+	if len(spec.ClaimNames) == 0 {
+		c.fail(migration, status, "No PVCs to migrate")
+		return nil
+	}
+	sc, err := c.scLister.Get(spec.StorageClassName)
+	if apierrors.IsNotFound(err) {
+		return pending("StorageClass %s does not exist", spec.StorageClassName)
+	}
+	if err != nil {
+		return err
+	}
+	if sc.Provisioner == instanceName {
+		c.fail(migration, status, fmt.Sprintf("StorageClass %s is of %s, the PVCs must move to another driver", sc.Name, instanceName))
+		return nil
+	}
+	// Checked before anything is scaled down, the copy Jobs could not create their pods
+	serviceAccount := spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	_, err = c.kubeClient.CoreV1().ServiceAccounts(migration.Namespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return pending("ServiceAccount %s does not exist", serviceAccount)
+	}
+	if err != nil {
+		return err
+	}
+
+	claims := map[string]bool{}
+	var pvcs []*corev1.PersistentVolumeClaim
+	for _, name := range spec.ClaimNames {
+		pvc, err := c.pvcLister.PersistentVolumeClaims(migration.Namespace).Get(name)
+		if apierrors.IsNotFound(err) {
+			return pending("PVC %s does not exist", name)
+		}
+		if err != nil {
+			return err
+		}
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return pending("PVC %s is not bound", name)
+		}
+		pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+		if err != nil || pv.Spec.CSI == nil || pv.Spec.CSI.Driver != instanceName {
+			c.fail(migration, status, fmt.Sprintf("PVC %s is not bound to a volume of %s", name, instanceName))
+			return nil
+		}
+		claims[name] = true
+		pvcs = append(pvcs, pvc)
+	}
+
+	workloads, err := c.listWorkloads(ctx, migration.Namespace)
+	if err != nil {
+		return err
+	}
+	var refs []string
+	for _, workload := range workloads {
+		for _, prefix := range workload.claimPrefixes {
+			for name := range claims {
+				if strings.HasPrefix(name, prefix) {
+					c.fail(migration, status, fmt.Sprintf("PVC %s is of a volumeClaimTemplate of %s, which cannot be switched to a new PVC", name, workload.ref))
+					return nil
+				}
+			}
+		}
+		if usesClaims(&workload.template.Spec, claims) {
+			refs = append(refs, workload.ref)
+		}
+	}
+	sort.Strings(refs)
+
+	// The pods of other owners would keep the PVCs in use
+	pods, err := c.podsUsingClaims(ctx, migration.Namespace, claims)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		ref, err := c.podWorkload(ctx, &pods[i])
+		if err != nil {
+			return err
+		}
+		if j := sort.SearchStrings(refs, ref); ref == "" || j == len(refs) || refs[j] != ref {
+			return pending("Pod %s uses the PVCs and is not of a Deployment or StatefulSet", pods[i].Name)
+		}
+	}
+
+	var volumes []v1alpha1.VolumeMigrationVolumeStatus
+	for _, pvc := range pvcs {
+		target, message, err := c.ensureTargetClaim(ctx, migration, pvc, sc)
+		if err != nil {
+			return err
+		}
+		if message != "" {
+			c.fail(migration, status, message)
+			return nil
+		}
+		volumes = append(volumes, v1alpha1.VolumeMigrationVolumeStatus{
+			ClaimName:       pvc.Name,
+			TargetClaimName: target,
+			Phase:           v1alpha1.VolumeMigrationVolumePending,
+		})
+	}
+	status.Phase, status.Message = v1alpha1.VolumeMigrationScalingDown, ""
+	status.Workloads, status.Volumes = refs, volumes
+	return nil
+}
+
+// ensureTargetClaim creates the new PVC of the migrated PVC, with the size of its PV. A new PVC
+// left by an earlier migration of the PVC is reused, other PVCs of the name are refused.
+func (c *VolumeMigrationController) ensureTargetClaim(ctx context.Context, migration *v1alpha1.VolumeMigration, pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass) (string, string, error) {
+	name := pvc.Name + migratedClaimSuffix
+	existing, err := c.pvcLister.PersistentVolumeClaims(pvc.Namespace).Get(name)
+	if err == nil {
+		if existing.Annotations[migratedFromAnnotation] != pvc.Name {
+			return "", fmt.Sprintf("PVC %s exists already and is not the new PVC of %s", name, pvc.Name), nil
+		}
+		return name, "", nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", "", err
+	}
+
+	size := pvc.Status.Capacity[corev1.ResourceStorage]
+	if request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; request.Cmp(size) > 0 {
+		size = request
+	}
+	target := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pvc.Namespace,
+			Name:      name,
+			// The labels are kept for the selectors of e.g. backups
+			Labels: pvc.Labels,
+			Annotations: map[string]string{
+				volumeMigrationAnnotation: migration.Name,
+				migratedFromAnnotation:    pvc.Name,
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: pvc.Spec.AccessModes,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+			StorageClassName: &sc.Name,
+			VolumeMode:       pvc.Spec.VolumeMode,
+		},
+	}
+	if _, err := c.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(ctx, target, metav1.CreateOptions{}); err != nil {
+		return "", "", fmt.Errorf("failed to create PVC %s/%s: %w", pvc.Namespace, name, err)
+	}
+	klog.Infof("Created PVC %s/%s of StorageClass %s for VolumeMigration %s", pvc.Namespace, name, sc.Name, migration.Name)
+	return name, "", nil
+}
+
+// scaleDown scales the workloads to zero and waits for their pods to terminate.
+func (c *VolumeMigrationController) scaleDown(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	for _, ref := range status.Workloads {
+		workload, err := c.getWorkload(ctx, migration.Namespace, ref)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch owner := workload.meta.Annotations[volumeMigrationAnnotation]; owner {
+		case migration.Name:
+			if *workload.replicas == 0 {
+				continue
+			}
+		case "":
+			metav1.SetMetaDataAnnotation(workload.meta, volumeMigrationAnnotation, migration.Name)
+			metav1.SetMetaDataAnnotation(workload.meta, migrationReplicasAnnotation, strconv.Itoa(int(*workload.replicas)))
+		default:
+			status.Message = fmt.Sprintf("Waiting for %s, it is scaled down by VolumeMigration %s", ref, owner)
+			return nil
+		}
+		*workload.replicas = 0
+		if err := workload.update(ctx); err != nil {
+			return fmt.Errorf("failed to scale down %s: %w", ref, err)
+		}
+		c.eventRecorder.Eventf("WorkloadScaledDown", "Scaled down %s/%s for VolumeMigration %s", migration.Namespace, ref, migration.Name)
+	}
+
+	pods, err := c.podsUsingClaims(ctx, migration.Namespace, migratedClaims(status))
+	if err != nil {
+		return err
+	}
+	if len(pods) > 0 {
+		var names []string
+		for _, pod := range pods {
+			names = append(names, pod.Name)
+		}
+		status.Message = fmt.Sprintf("Waiting for pods %s to terminate", strings.Join(names, ", "))
+		return nil
+	}
+	status.Phase, status.Message = v1alpha1.VolumeMigrationCopying, ""
+	return nil
+}
+
+// copyVolumes runs the copy Jobs and reports their progress. A failed copy scales the workloads
+// back up with the original PVCs.
+func (c *VolumeMigrationController) copyVolumes(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	var failed []string
+	for i := range status.Volumes {
+		volume := &status.Volumes[i]
+		if volume.Phase == v1alpha1.VolumeMigrationVolumeCopied {
+			continue
+		}
+		pvc, err := c.pvcLister.PersistentVolumeClaims(migration.Namespace).Get(volume.ClaimName)
+		if apierrors.IsNotFound(err) {
+			volume.Phase, volume.Message = v1alpha1.VolumeMigrationVolumeFailed, "The PVC was deleted"
+		} else if err != nil {
+			return err
+		}
+		if volume.Phase == v1alpha1.VolumeMigrationVolumeFailed {
+			failed = append(failed, volume.ClaimName)
+			continue
+		}
+		if err := c.syncCopyJob(ctx, migration, i, pvc, volume); err != nil {
+			return err
+		}
+		switch volume.Phase {
+		case v1alpha1.VolumeMigrationVolumeFailed:
+			failed = append(failed, volume.ClaimName)
+		case v1alpha1.VolumeMigrationVolumeCopied:
+			klog.Infof("Copied PVC %s/%s to %s", migration.Namespace, volume.ClaimName, volume.TargetClaimName)
+		}
+	}
+
+	if len(failed) > 0 {
+		for _, volume := range status.Volumes {
+			if volume.Phase == v1alpha1.VolumeMigrationVolumeCopying {
+				status.Message = "Waiting for the other copies before scaling up the workloads"
+				return nil
+			}
+		}
+		if err := c.releaseWorkloads(ctx, migration, status, nil); err != nil {
+			return err
+		}
+		c.fail(migration, status, fmt.Sprintf("Failed to copy PVCs %s, the workloads were scaled up with the original PVCs", strings.Join(failed, ", ")))
+		return nil
+	}
+	for _, volume := range status.Volumes {
+		if volume.Phase != v1alpha1.VolumeMigrationVolumeCopied {
+			return nil
+		}
+	}
+	status.Phase, status.Message = v1alpha1.VolumeMigrationCuttingOver, ""
+	return nil
+}
+
+// syncCopyJob creates the copy Job of the volume and records its state.
+func (c *VolumeMigrationController) syncCopyJob(ctx context.Context, migration *v1alpha1.VolumeMigration, index int, pvc *corev1.PersistentVolumeClaim, volume *v1alpha1.VolumeMigrationVolumeStatus) error {
+	name := copyJobName(migration, index)
+	job, err := c.kubeClient.BatchV1().Jobs(migration.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		if c.operatorImage == "" {
+			volume.Phase, volume.Message = v1alpha1.VolumeMigrationVolumeFailed, fmt.Sprintf("%s is not set, volumes cannot be copied", operatorImageEnv)
+			return nil
+		}
+		job = copyJob(name, c.operatorImage, migration, pvc, volume.TargetClaimName)
+		if _, err := c.kubeClient.BatchV1().Jobs(migration.Namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create Job %s/%s: %w", migration.Namespace, name, err)
+		}
+		klog.Infof("Started Job %s/%s copying PVC %s to %s", migration.Namespace, name, pvc.Name, volume.TargetClaimName)
+		volume.Phase = v1alpha1.VolumeMigrationVolumeCopying
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Status.Succeeded > 0 {
+		volume.Phase, volume.Message = v1alpha1.VolumeMigrationVolumeCopied, ""
+		volume.Progress, volume.CopiedBytes = "100%", volume.TotalBytes
+		return nil
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			volume.Phase, volume.Message = v1alpha1.VolumeMigrationVolumeFailed, condition.Message
+			if message := c.copyFailure(ctx, migration.Namespace, pvc.UID); message != "" {
+				volume.Message = message
+			}
+			return nil
+		}
+	}
+	volume.Phase = v1alpha1.VolumeMigrationVolumeCopying
+	if job.Status.Active+job.Status.Succeeded+job.Status.Failed == 0 {
+		return c.checkCopyJobStart(ctx, job, volume)
+	}
+	c.readCopyProgress(ctx, migration.Namespace, pvc.UID, volume)
+	return nil
+}
+
+// checkCopyJobStart reports why the Job has no pod yet. A Job without a pod after
+// copyJobStartTimeout fails the copy and is deleted, so that it does not start the copy later.
+func (c *VolumeMigrationController) checkCopyJobStart(ctx context.Context, job *batchv1.Job, volume *v1alpha1.VolumeMigrationVolumeStatus) error {
+	message := fmt.Sprintf("Job %s has not created its pod yet", job.Name)
+	events, err := c.kubeClient.CoreV1().Events(job.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Job,involvedObject.name=" + job.Name + ",reason=FailedCreate",
+	})
+	if err != nil {
+		klog.V(4).Infof("Failed to list the events of Job %s/%s: %v", job.Namespace, job.Name, err)
+	} else {
+		var last *corev1.Event
+		for i := range events.Items {
+			event := &events.Items[i]
+			if event.InvolvedObject.UID != job.UID || event.Reason != "FailedCreate" {
+				continue
+			}
+			if last == nil || last.LastTimestamp.Before(&event.LastTimestamp) {
+				last = event
+			}
+		}
+		if last != nil {
+			message = fmt.Sprintf("Job %s cannot create its pod: %s", job.Name, last.Message)
+		}
+	}
+	volume.Message = message
+	if time.Since(job.CreationTimestamp.Time) < copyJobStartTimeout {
+		return nil
+	}
+
+	volume.Phase = v1alpha1.VolumeMigrationVolumeFailed
+	propagation := metav1.DeletePropagationBackground
+	err = c.kubeClient.BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+	klog.Warningf("Deleted Job %s/%s, it has no pod after %s: %s", job.Namespace, job.Name, copyJobStartTimeout, message)
+	return nil
+}
+
+// readCopyProgress reads the progress served by the running copy pod of the PVC, through the
+// API server proxy. It is best effort, the progress is kept when it cannot be read.
+func (c *VolumeMigrationController) readCopyProgress(ctx context.Context, namespace string, uid types.UID, volume *v1alpha1.VolumeMigrationVolumeStatus) {
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: copyJobLabel + "=" + string(uid)})
+	if err != nil {
+		klog.V(4).Infof("Failed to list the copy pods of PVC %s/%s: %v", namespace, volume.ClaimName, err)
+		return
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || !isPodReady(&pod) {
+			continue
+		}
+		raw, err := c.kubeClient.CoreV1().RESTClient().Get().
+			Namespace(namespace).
+			Resource("pods").
+			Name(fmt.Sprintf("%s:%d", pod.Name, imageSourcePort)).
+			SubResource("proxy").
+			Suffix(copyProgressPath).
+			DoRaw(ctx)
+		if err != nil {
+			klog.V(4).Infof("Failed to read the progress of pod %s/%s: %v", namespace, pod.Name, err)
+			return
+		}
+		var done, total int64
+		if _, err := fmt.Sscan(string(raw), &done, &total); err != nil {
+			klog.V(4).Infof("Pod %s/%s returned an invalid progress %q", namespace, pod.Name, raw)
+			return
+		}
+		volume.CopiedBytes, volume.TotalBytes = done, total
+		if total > 0 {
+			volume.Progress = fmt.Sprintf("%d%%", done*100/total)
+		}
+		return
+	}
+}
+
+// copyFailure returns the termination message of a failed copy pod of the PVC.
+func (c *VolumeMigrationController) copyFailure(ctx context.Context, namespace string, uid types.UID) string {
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: copyJobLabel + "=" + string(uid)})
+	if err != nil {
+		return ""
+	}
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 && status.State.Terminated.Message != "" {
+				return strings.TrimSpace(status.State.Terminated.Message)
+			}
+		}
+	}
+	return ""
+}
+
+// cutOver switches the workloads to the new PVCs and scales them back up.
+func (c *VolumeMigrationController) cutOver(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	targets := map[string]string{}
+	for _, volume := range status.Volumes {
+		targets[volume.ClaimName] = volume.TargetClaimName
+	}
+	if err := c.releaseWorkloads(ctx, migration, status, targets); err != nil {
+		return err
+	}
+	for _, volume := range status.Volumes {
+		patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, migratedToAnnotation, volume.TargetClaimName)
+		_, err := c.kubeClient.CoreV1().PersistentVolumeClaims(migration.Namespace).Patch(ctx, volume.ClaimName, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to annotate PVC %s/%s: %w", migration.Namespace, volume.ClaimName, err)
+		}
+	}
+	propagation := metav1.DeletePropagationBackground
+	for i := range status.Volumes {
+		name := copyJobName(migration, i)
+		err := c.kubeClient.BatchV1().Jobs(migration.Namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Warningf("Failed to delete Job %s/%s: %v", migration.Namespace, name, err)
+		}
+	}
+
+	now := metav1.Now()
+	status.Phase, status.Message, status.CompletionTime = v1alpha1.VolumeMigrationSucceeded, "", &now
+	c.eventRecorder.Eventf("VolumeMigrated", "VolumeMigration %s/%s moved PVCs %s to StorageClass %s", migration.Namespace, migration.Name, strings.Join(migration.Spec.ClaimNames, ", "), migration.Spec.StorageClassName)
+	return nil
+}
+
+// releaseWorkloads scales the workloads scaled down by the migration back up. With targets,
+// the PVCs in their pod templates are switched to the new PVCs first.
+func (c *VolumeMigrationController) releaseWorkloads(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus, targets map[string]string) error {
+	for _, ref := range status.Workloads {
+		workload, err := c.getWorkload(ctx, migration.Namespace, ref)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if workload.meta.Annotations[volumeMigrationAnnotation] != migration.Name {
+			// Released already
+			continue
+		}
+		for _, volume := range workload.template.Spec.Volumes {
+			if claim := volume.PersistentVolumeClaim; claim != nil && targets[claim.ClaimName] != "" {
+				claim.ClaimName = targets[claim.ClaimName]
+			}
+		}
+		replicas, err := strconv.Atoi(workload.meta.Annotations[migrationReplicasAnnotation])
+		if err != nil {
+			klog.Warningf("%s/%s has an invalid %s annotation, it is scaled to 1", migration.Namespace, ref, migrationReplicasAnnotation)
+			replicas = 1
+		}
+		*workload.replicas = int32(replicas)
+		delete(workload.meta.Annotations, volumeMigrationAnnotation)
+		delete(workload.meta.Annotations, migrationReplicasAnnotation)
+		if err := workload.update(ctx); err != nil {
+			return fmt.Errorf("failed to scale up %s: %w", ref, err)
+		}
+		klog.Infof("Scaled up %s/%s to %d replicas", migration.Namespace, ref, replicas)
+	}
+	return nil
+}
+
+func (c *VolumeMigrationController) fail(migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus, message string) {
+	now := metav1.Now()
+	status.Phase, status.Message, status.CompletionTime = v1alpha1.VolumeMigrationFailed, message, &now
+	c.eventRecorder.Warningf("VolumeMigrationFailed", "VolumeMigration %s/%s failed: %s", migration.Namespace, migration.Name, message)
+}
+
+// podsUsingClaims returns the pods of the namespace which are not terminated and use the
+// claims, except for the copy pods.
+func (c *VolumeMigrationController) podsUsingClaims(ctx context.Context, namespace string, claims map[string]bool) ([]corev1.Pod, error) {
+	pods, err := c.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the pods of namespace %s: %w", namespace, err)
+	}
+	var using []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed || pod.Labels[copyJobLabel] != "" {
+			continue
+		}
+		if usesClaims(&pod.Spec, claims) {
+			using = append(using, pod)
+		}
+	}
+	return using, nil
+}
+
+// podWorkload returns the Deployment or StatefulSet of the pod as Kind/name, or nothing.
+func (c *VolumeMigrationController) podWorkload(ctx context.Context, pod *corev1.Pod) (string, error) {
+	owner := metav1.GetControllerOf(pod)
+	switch {
+	case owner == nil:
+		return "", nil
+	case owner.Kind == "StatefulSet":
+		return "StatefulSet/" + owner.Name, nil
+	case owner.Kind != "ReplicaSet":
+		return "", nil
+	}
+	rs, err := c.kubeClient.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == "Deployment" {
+		return "Deployment/" + owner.Name, nil
+	}
+	return "", nil
+}
+
+func (c *VolumeMigrationController) updateStatus(ctx context.Context, migration *v1alpha1.VolumeMigration, status *v1alpha1.VolumeMigrationStatus) error {
+	if equality.Semantic.DeepEqual(&migration.Status, status) {
+		return nil
+	}
+	updated := migration.DeepCopy()
+	updated.Status = *status
+	updated.APIVersion, updated.Kind = v1alpha1.GroupVersion.String(), v1alpha1.VolumeMigrationKind
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updated)
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(v1alpha1.VolumeMigrationResource).Namespace(migration.Namespace).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	return nil
+}
+
+// migrationWorkload is a Deployment or a StatefulSet. Its fields point into the object, which
+// is written by update.
+type migrationWorkload struct {
+	// ref is Kind/name
+	ref      string
+	meta     *metav1.ObjectMeta
+	template *corev1.PodTemplateSpec
+	replicas *int32
+	// claimPrefixes are the name prefixes of the PVCs of the volumeClaimTemplates
+	claimPrefixes []string
+	update        func(ctx context.Context) error
+}
+
+func (c *VolumeMigrationController) deploymentWorkload(deployment *appsv1.Deployment) *migrationWorkload {
+	if deployment.Spec.Replicas == nil {
+		replicas := int32(1)
+		deployment.Spec.Replicas = &replicas
+	}
+	return &migrationWorkload{
+		ref:      "Deployment/" + deployment.Name,
+		meta:     &deployment.ObjectMeta,
+		template: &deployment.Spec.Template,
+		replicas: deployment.Spec.Replicas,
+		update: func(ctx context.Context) error {
+			_, err := c.kubeClient.AppsV1().Deployments(deployment.Namespace).Update(ctx, deployment, metav1.UpdateOptions{})
+			return err
+		},
+	}
+}
+
+func (c *VolumeMigrationController) statefulSetWorkload(statefulSet *appsv1.StatefulSet) *migrationWorkload {
+	if statefulSet.Spec.Replicas == nil {
+		replicas := int32(1)
+		statefulSet.Spec.Replicas = &replicas
+	}
+	workload := &migrationWorkload{
+		ref:      "StatefulSet/" + statefulSet.Name,
+		meta:     &statefulSet.ObjectMeta,
+		template: &statefulSet.Spec.Template,
+		replicas: statefulSet.Spec.Replicas,
+		update: func(ctx context.Context) error {
+			_, err := c.kubeClient.AppsV1().StatefulSets(statefulSet.Namespace).Update(ctx, statefulSet, metav1.UpdateOptions{})
+			return err
+		},
+	}
+	for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+		workload.claimPrefixes = append(workload.claimPrefixes, fmt.Sprintf("%s-%s-", template.Name, statefulSet.Name))
+	}
+	return workload
+}
+
+func (c *VolumeMigrationController) listWorkloads(ctx context.Context, namespace string) ([]*migrationWorkload, error) {
+	deployments, err := c.kubeClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the Deployments of namespace %s: %w", namespace, err)
+	}
+	statefulSets, err := c.kubeClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the StatefulSets of namespace %s: %w", namespace, err)
+	}
+	var workloads []*migrationWorkload
+	for i := range deployments.Items {
+		workloads = append(workloads, c.deploymentWorkload(&deployments.Items[i]))
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, c.statefulSetWorkload(&statefulSets.Items[i]))
+	}
+	return workloads, nil
+}
+
+func (c *VolumeMigrationController) getWorkload(ctx context.Context, namespace, ref string) (*migrationWorkload, error) {
+	kind, name, _ := strings.Cut(ref, "/")
+	switch kind {
+	case "Deployment":
+		deployment, err := c.kubeClient.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return c.deploymentWorkload(deployment), nil
+	case "StatefulSet":
+		statefulSet, err := c.kubeClient.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return c.statefulSetWorkload(statefulSet), nil
+	}
+	return nil, fmt.Errorf("unsupported workload %s", ref)
+}
+
+func usesClaims(spec *corev1.PodSpec, claims map[string]bool) bool {
+	for _, volume := range spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && claims[volume.PersistentVolumeClaim.ClaimName] {
+			return true
+		}
+	}
+	return false
+}
+
+func migratedClaims(status *v1alpha1.VolumeMigrationStatus) map[string]bool {
+	claims := map[string]bool{}
+	for _, volume := range status.Volumes {
+		claims[volume.ClaimName] = true
+	}
+	return claims
+}
+
+// copyJob copies the PVC to the target PVC with copy-volume. It runs as root to read all files
+// and to keep their owners, with the capabilities this needs only.
+func copyJob(name, image string, migration *v1alpha1.VolumeMigration, pvc *corev1.PersistentVolumeClaim, target string) *batchv1.Job {
+	allowPrivilegeEscalation, runAsNonRoot := false, false
+	runAsUser, backoffLimit := int64(0), int32(2)
+	activeDeadlineSeconds := int64(copyJobDeadline / time.Second)
+	container := corev1.Container{
+		Name:  "copy-volume",
+		Image: image,
+		Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: imageSourcePort}},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/" + copyProgressPath, Port: intstr.FromInt(imageSourcePort)},
+			},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("50Mi"),
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+			RunAsNonRoot:             &runAsNonRoot,
+			RunAsUser:                &runAsUser,
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+				Add:  []corev1.Capability{"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID"},
+			},
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == corev1.PersistentVolumeBlock {
+		container.Args = []string{"copy-volume", "--source", "/dev/source", "--target", "/dev/target"}
+		container.VolumeDevices = []corev1.VolumeDevice{
+			{Name: "source", DevicePath: "/dev/source"},
+			{Name: "target", DevicePath: "/dev/target"},
+		}
+	} else {
+		container.Args = []string{"copy-volume", "--source", "/source", "--target", "/target"}
+		container.VolumeMounts = []corev1.VolumeMount{
+			{Name: "source", MountPath: "/source", ReadOnly: true},
+			{Name: "target", MountPath: "/target"},
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: migration.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: v1alpha1.GroupVersion.String(),
+				Kind:       v1alpha1.VolumeMigrationKind,
+				Name:       migration.Name,
+				UID:        migration.UID,
+			}},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{copyJobLabel: string(pvc.UID)},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: migration.Spec.ServiceAccountName,
+					Containers:         []corev1.Container{container},
+					Volumes: []corev1.Volume{
+						{
+							Name: "source",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.Name, ReadOnly: true},
+							},
+						},
+						{
+							Name: "target",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: target},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// copyJobName is unique per migration and PVC, a later migration of the PVC does not find the
+// Jobs of an earlier one.
+func copyJobName(migration *v1alpha1.VolumeMigration, index int) string {
+	return fmt.Sprintf("ovirt-volume-copy-%s-%d", migration.UID, index)
+}
@@ -0,0 +1,259 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/library-go/pkg/operator/events"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/ovirt/csi-driver-operator/pkg/apis/v1alpha1"
+)
+
+func TestVolumeMigrationSync(t *testing.T) {
+	const namespace = "app"
+	migration := &v1alpha1.VolumeMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: "to-ceph", Namespace: namespace, UID: "9a8b7c6d-5e4f-4a3b-9c2d-1e0f9a8b7c6d"},
+		Spec: v1alpha1.VolumeMigrationSpec{
+			ClaimNames:         []string{"db"},
+			StorageClassName:   "ceph-rbd",
+			ServiceAccountName: "volume-migration",
+		},
+	}
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "ceph-rbd"}, Provisioner: "rbd.csi.ceph.com"}
+	serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "volume-migration", Namespace: namespace}}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: namespace, UID: "1b2c3d4e-5f60-4718-8293-a4b5c6d7e8f9"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+			VolumeName: "pv-db",
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	targetPVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-migrated", Namespace: namespace, Annotations: map[string]string{migratedFromAnnotation: "db"}},
+	}
+	pv := newDriverPV("pv-db", "b0c1d2e3-f405-4617-8829-3a4b5c6d7e8f", time.Now(), nil)
+	newDeployment := func(scaledDown bool) *appsv1.Deployment {
+		replicas := int32(2)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: namespace},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Volumes: []corev1.Volume{{
+							Name:         "data",
+							VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "db"}},
+						}},
+					},
+				},
+			},
+		}
+		if scaledDown {
+			replicas = 0
+			deployment.Annotations = map[string]string{volumeMigrationAnnotation: migration.Name, migrationReplicasAnnotation: "2"}
+		}
+		return deployment
+	}
+	newStatus := func(phase v1alpha1.VolumeMigrationPhase, volumePhase v1alpha1.VolumeMigrationVolumePhase) v1alpha1.VolumeMigrationStatus {
+		return v1alpha1.VolumeMigrationStatus{
+			Phase:     phase,
+			Workloads: []string{"Deployment/db"},
+			Volumes:   []v1alpha1.VolumeMigrationVolumeStatus{{ClaimName: "db", TargetClaimName: "db-migrated", Phase: volumePhase}},
+		}
+	}
+	newJob := func(created time.Time, status batchv1.JobStatus) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              copyJobName(migration, 0),
+				Namespace:         namespace,
+				UID:               "5d6e7f80-9a1b-4c2d-8e3f-4a5b6c7d8e9f",
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Status: status,
+		}
+	}
+	failedCreate := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "copy.17a", Namespace: namespace},
+		InvolvedObject: corev1.ObjectReference{
+			Kind: "Job", Namespace: namespace, Name: copyJobName(migration, 0), UID: "5d6e7f80-9a1b-4c2d-8e3f-4a5b6c7d8e9f",
+		},
+		Reason:  "FailedCreate",
+		Message: "pods is forbidden: unable to validate against any security context constraint",
+	}
+	runningPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-6d5f7c9b8-x2x4q", Namespace: namespace},
+		Spec:       newDeployment(false).Spec.Template.Spec,
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	testCases := []struct {
+		name    string
+		status  v1alpha1.VolumeMigrationStatus
+		objects []runtime.Object
+		// expectMessage is in the message of the migration or of its volume
+		expectMessage  string
+		expectPhase    v1alpha1.VolumeMigrationPhase
+		expectReplicas int32
+		// expectClaim is the PVC in the pod template of the Deployment
+		expectClaim string
+		expectJob   bool
+	}{
+		{
+			name:           "pending until the StorageClass exists",
+			objects:        []runtime.Object{serviceAccount, pvc, pv, newDeployment(false)},
+			expectPhase:    v1alpha1.VolumeMigrationPending,
+			expectMessage:  "StorageClass ceph-rbd does not exist",
+			expectReplicas: 2,
+			expectClaim:    "db",
+		},
+		{
+			name:           "pending until the ServiceAccount exists",
+			objects:        []runtime.Object{sc, pvc, pv, newDeployment(false)},
+			expectPhase:    v1alpha1.VolumeMigrationPending,
+			expectMessage:  "ServiceAccount volume-migration does not exist",
+			expectReplicas: 2,
+			expectClaim:    "db",
+		},
+		{
+			name:        "workloads are scaled down and the copy started",
+			objects:     []runtime.Object{sc, serviceAccount, pvc, pv, newDeployment(false)},
+			expectPhase: v1alpha1.VolumeMigrationCopying,
+			expectClaim: "db",
+			expectJob:   true,
+		},
+		{
+			name:          "scaling down waits for the pods",
+			status:        newStatus(v1alpha1.VolumeMigrationScalingDown, v1alpha1.VolumeMigrationVolumePending),
+			objects:       []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(false), runningPod},
+			expectPhase:   v1alpha1.VolumeMigrationScalingDown,
+			expectMessage: "Waiting for pods " + runningPod.Name,
+			expectClaim:   "db",
+		},
+		{
+			name:        "copy in progress",
+			status:      newStatus(v1alpha1.VolumeMigrationCopying, v1alpha1.VolumeMigrationVolumeCopying),
+			objects:     []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(true), newJob(time.Now(), batchv1.JobStatus{Active: 1})},
+			expectPhase: v1alpha1.VolumeMigrationCopying,
+			expectClaim: "db",
+			expectJob:   true,
+		},
+		{
+			name:          "Job without a pod reports why",
+			status:        newStatus(v1alpha1.VolumeMigrationCopying, v1alpha1.VolumeMigrationVolumeCopying),
+			objects:       []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(true), newJob(time.Now(), batchv1.JobStatus{}), failedCreate},
+			expectPhase:   v1alpha1.VolumeMigrationCopying,
+			expectMessage: "security context constraint",
+			expectClaim:   "db",
+			expectJob:     true,
+		},
+		{
+			name:           "Job without a pod after the timeout releases the workloads",
+			status:         newStatus(v1alpha1.VolumeMigrationCopying, v1alpha1.VolumeMigrationVolumeCopying),
+			objects:        []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(true), newJob(time.Now().Add(-copyJobStartTimeout), batchv1.JobStatus{}), failedCreate},
+			expectPhase:    v1alpha1.VolumeMigrationFailed,
+			expectMessage:  "security context constraint",
+			expectReplicas: 2,
+			expectClaim:    "db",
+		},
+		{
+			name:   "failed copy releases the workloads",
+			status: newStatus(v1alpha1.VolumeMigrationCopying, v1alpha1.VolumeMigrationVolumeCopying),
+			objects: []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(true), newJob(time.Now(), batchv1.JobStatus{
+				Failed:     1,
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded", Message: "Job was active longer than specified deadline"}},
+			})},
+			expectPhase:    v1alpha1.VolumeMigrationFailed,
+			expectMessage:  "deadline",
+			expectReplicas: 2,
+			expectClaim:    "db",
+			expectJob:      true,
+		},
+		{
+			name:           "finished copy cuts over",
+			status:         newStatus(v1alpha1.VolumeMigrationCopying, v1alpha1.VolumeMigrationVolumeCopying),
+			objects:        []runtime.Object{sc, serviceAccount, pvc, targetPVC, pv, newDeployment(true), newJob(time.Now(), batchv1.JobStatus{Succeeded: 1})},
+			expectPhase:    v1alpha1.VolumeMigrationSucceeded,
+			expectReplicas: 2,
+			expectClaim:    "db-migrated",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient, kubeInformers := newFakeKubeInformers(t, tc.objects...)
+			c := &VolumeMigrationController{
+				name:          "VolumeMigrationController",
+				kubeClient:    kubeClient,
+				pvcLister:     kubeInformers.Core().V1().PersistentVolumeClaims().Lister(),
+				pvLister:      kubeInformers.Core().V1().PersistentVolumes().Lister(),
+				scLister:      kubeInformers.Storage().V1().StorageClasses().Lister(),
+				operatorImage: "quay.io/ovirt/csi-driver-operator:latest",
+				eventRecorder: events.NewInMemoryRecorder("test"),
+			}
+			migration := migration.DeepCopy()
+			migration.Status = tc.status
+			status := migration.Status.DeepCopy()
+			if err := c.syncMigration(context.Background(), migration, status); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if status.Phase != tc.expectPhase {
+				t.Errorf("expected phase %s, got %s: %s", tc.expectPhase, status.Phase, status.Message)
+			}
+			messages := []string{status.Message}
+			for _, volume := range status.Volumes {
+				messages = append(messages, volume.Message)
+			}
+			if message := strings.Join(messages, "; "); !strings.Contains(message, tc.expectMessage) {
+				t.Errorf("expected a message containing %q, got %q", tc.expectMessage, message)
+			}
+
+			ctx := context.Background()
+			deployment, err := kubeClient.AppsV1().Deployments(namespace).Get(ctx, "db", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != tc.expectReplicas {
+				t.Errorf("expected %d replicas, got %d", tc.expectReplicas, *deployment.Spec.Replicas)
+			}
+			if claim := deployment.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName; claim != tc.expectClaim {
+				t.Errorf("expected the Deployment to use PVC %s, got %s", tc.expectClaim, claim)
+			}
+			scaledDown := deployment.Annotations[volumeMigrationAnnotation] == migration.Name
+			if expectScaledDown := tc.expectReplicas == 0; scaledDown != expectScaledDown || (scaledDown && deployment.Annotations[migrationReplicasAnnotation] != "2") {
+				t.Errorf("expected the Deployment scaled down by the migration %t, got annotations %v", expectScaledDown, deployment.Annotations)
+			}
+			if tc.expectPhase == v1alpha1.VolumeMigrationPending {
+				if _, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, "db-migrated", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+					t.Errorf("expected no new PVC while pending, got %v", err)
+				}
+			}
+
+			job, err := kubeClient.BatchV1().Jobs(namespace).Get(ctx, copyJobName(migration, 0), metav1.GetOptions{})
+			if exists := err == nil; exists != tc.expectJob {
+				t.Fatalf("expected the copy Job %t, got %v", tc.expectJob, err)
+			}
+			if tc.expectJob && tc.status.Phase == "" {
+				if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != int64(copyJobDeadline/time.Second) {
+					t.Errorf("expected the Job deadline %s, got %v", copyJobDeadline, job.Spec.ActiveDeadlineSeconds)
+				}
+				if sa := job.Spec.Template.Spec.ServiceAccountName; sa != serviceAccount.Name {
+					t.Errorf("expected the Job to run as %s, got %q", serviceAccount.Name, sa)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openshift/library-go/pkg/controller/factory"
+	"github.com/openshift/library-go/pkg/operator/events"
+	"github.com/openshift/library-go/pkg/operator/v1helpers"
+	ovirtclient "github.com/ovirt/go-ovirt-client/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// PV annotations with the latest figures of the disk backing the volume
+	volumeActualSizeAnnotation      = "csi.ovirt.org/actual-size-bytes"
+	volumeProvisionedSizeAnnotation = "csi.ovirt.org/provisioned-size-bytes"
+	volumeStorageDomainAnnotation   = "csi.ovirt.org/storage-domain"
+
+	// volumeUsageResync is the interval the disk figures are refreshed in, as they change
+	// without any event in the cluster
+	volumeUsageResync = 10 * time.Minute
+)
+
+// volumeUsage holds the figures of the disk behind a PV.
+type volumeUsage struct {
+	pv            *corev1.PersistentVolume
+	storageDomain string
+	actual        uint64
+	provisioned   uint64
+}
+
+// OvirtVolumeUsageController exports the actual and provisioned size of the disks behind
+// the PVs of the driver as metrics and PV annotations. With thin provisioning the actual
+// size is usually far below the provisioned one.
+type OvirtVolumeUsageController struct {
+	operatorClient     v1helpers.OperatorClient
+	kubeClient         kubernetes.Interface
+	pvLister           corelisters.PersistentVolumeLister
+	ovirtClientFactory func() (ovirtclient.Client, error)
+	listDisks          func(ctx context.Context) (*diskListing, error)
+	eventRecorder      events.Recorder
+}
+
+func NewOvirtVolumeUsageController(
+	operatorClient v1helpers.OperatorClient,
+	kubeClient kubernetes.Interface,
+	pvInformer corev1informers.PersistentVolumeInformer,
+	ovirtClientFactory func() (ovirtclient.Client, error),
+	listDisks func(ctx context.Context) (*diskListing, error),
+	eventRecorder events.Recorder,
+) factory.Controller {
+	c := &OvirtVolumeUsageController{
+		operatorClient:     operatorClient,
+		kubeClient:         kubeClient,
+		pvLister:           pvInformer.Lister(),
+		ovirtClientFactory: ovirtClientFactory,
+		listDisks:          listDisks,
+		eventRecorder:      eventRecorder,
+	}
+	// Driven by the resync only: the PV annotations of this and other controllers would
+	// trigger a sync, and an engine request, on every PV update
+	return factory.New().WithSync(c.sync).WithSyncDegradedOnError(operatorClient).WithBareInformers(
+		operatorClient.Informer(),
+		pvInformer.Informer(),
+	).ResyncEvery(volumeUsageResync).ToController("OvirtVolumeUsageController", eventRecorder)
+}
+
+func (c *OvirtVolumeUsageController) sync(ctx context.Context, _ factory.SyncContext) error {
+	pvs, err := driverPersistentVolumes(c.pvLister)
+	if err != nil {
+		return err
+	}
+
+	ovirtClient, err := c.ovirtClientFactory()
+	if err != nil {
+		return fmt.Errorf("failed to create oVirt client (%w)", err)
+	}
+	storageDomainNames, err := listStorageDomainNames(ctx, ovirtClient)
+	if err != nil {
+		return err
+	}
+
+	// The disks are listed before any update to keep the previous figures when the engine fails
+	listing, err := c.listDisks(ctx)
+	if err != nil {
+		return err
+	}
+
+	usages := make([]volumeUsage, 0, len(pvs))
+	for _, pv := range pvs {
+		disk, ok := listing.disks[ovirtclient.DiskID(pv.Spec.CSI.VolumeHandle)]
+		if !ok {
+			klog.V(2).Infof("Disk %s of PV %s not found", pv.Spec.CSI.VolumeHandle, pv.Name)
+			continue
+		}
+		usage := volumeUsage{pv: pv, actual: disk.TotalSize(), provisioned: disk.ProvisionedSize()}
+		if ids := disk.StorageDomainIDs(); len(ids) > 0 {
+			usage.storageDomain = storageDomainNames[ids[0]]
+		}
+		usages = append(usages, usage)
+	}
+
+	volumeActualBytes.Reset()
+	volumeProvisionedBytes.Reset()
+	for _, u := range usages {
+		namespace, claim := "", ""
+		if u.pv.Spec.ClaimRef != nil {
+			namespace, claim = u.pv.Spec.ClaimRef.Namespace, u.pv.Spec.ClaimRef.Name
+		}
+		volumeActualBytes.WithLabelValues(namespace, claim, u.pv.Name, u.storageDomain).Set(float64(u.actual))
+		volumeProvisionedBytes.WithLabelValues(namespace, claim, u.pv.Name, u.storageDomain).Set(float64(u.provisioned))
+	}
+
+	for _, u := range usages {
+		if err := annotatePersistentVolume(ctx, c.kubeClient, u.pv, map[string]string{
+			volumeActualSizeAnnotation:      strconv.FormatUint(u.actual, 10),
+			volumeProvisionedSizeAnnotation: strconv.FormatUint(u.provisioned, 10),
+			volumeStorageDomainAnnotation:   u.storageDomain,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// annotatePersistentVolume patches the annotations of the PV, unless they are up to date already.
+func annotatePersistentVolume(ctx context.Context, kubeClient kubernetes.Interface, pv *corev1.PersistentVolume, annotations map[string]string) error {
+	changed := false
+	for k, v := range annotations {
+		if pv.Annotations[k] != v {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate PV %s: %w", pv.Name, err)
+	}
+	return nil
+}
+
+// driverPersistentVolumes returns the PVs provisioned by the driver.
+func driverPersistentVolumes(pvLister corelisters.PersistentVolumeLister) ([]*corev1.PersistentVolume, error) {
+	pvs, err := pvLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var driverPVs []*corev1.PersistentVolume
+	for _, pv := range pvs {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == instanceName {
+			driverPVs = append(driverPVs, pv)
+		}
+	}
+	return driverPVs, nil
+}
+
+// listStorageDomainNames returns the names of all storage domains by their ID.
+func listStorageDomainNames(ctx context.Context, ovirtClient ovirtclient.Client) (map[ovirtclient.StorageDomainID]string, error) {
+	storageDomains, err := ovirtClient.ListStorageDomains(ovirtclient.ContextStrategy(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage domains: %w", err)
+	}
+	names := make(map[ovirtclient.StorageDomainID]string, len(storageDomains))
+	for _, sd := range storageDomains {
+		names[sd.ID()] = sd.Name()
+	}
+	return names, nil
+}
@@ -1,9 +1,9 @@
 package version
 
 import (
-	"github.com/prometheus/client_golang/prometheus"
-
 	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
 )
 
 var (
@@ -34,14 +34,16 @@ func Get() version.Info {
 }
 
 func init() {
-	buildInfo := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "openshift_ovirt_csi_driver_operator",
-			Help: "A metric with a constant '1' value labeled by major, minor, git commit & git version from which OpenShift oVirt CSI Driver Operator was built.",
+	buildInfo := metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Name:           "openshift_ovirt_csi_driver_operator",
+			Help:           "A metric with a constant '1' value labeled by major, minor, git commit & git version from which OpenShift oVirt CSI Driver Operator was built.",
+			StabilityLevel: metrics.ALPHA,
 		},
 		[]string{"major", "minor", "gitCommit", "gitVersion"},
 	)
+	// legacyregistry is served on the metrics endpoint of the controller command, the
+	// default prometheus registry is not
+	legacyregistry.MustRegister(buildInfo)
 	buildInfo.WithLabelValues(majorFromGit, minorFromGit, commitFromGit, versionFromGit).Set(1)
-
-	prometheus.MustRegister(buildInfo)
 }